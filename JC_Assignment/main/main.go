@@ -1,25 +1,56 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/qwex23/JC_Assignment/stats"
 )
 
 func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	maxBodyBytes := flag.Int64("max-body", 1<<20, "largest request body accepted, in bytes")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "time allowed for in flight requests to finish on shutdown")
+	flag.Parse()
 
 	st := stats.NewStats()
 
-	call1 := "{\"AcTion\":\"jump\", \"tIMe\":100}"
-	call2 := "{\"action\":\"run\", \"time\":75}"
-	call3 := "{\"action\":\"jump\", \"time\":200}"
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           newServer(&st, *maxBodyBytes),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	//stop accepting requests on ctrl-c or a termination signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	st.AddAction(call1)
-	st.AddAction(call2)
-	st.AddAction(call3)
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("stats server listening on %s", *addr)
+		errs <- srv.ListenAndServe()
+	}()
 
-	statsJson, err := st.GetStats()
-	if err != nil {
-		println("Bad news, we had an error!")
+	select {
+	case err := <-errs:
+		log.Fatalf("stats server failed: %s", err.Error())
+	case <-ctx.Done():
 	}
-	print(statsJson)
 
+	//let in flight requests finish
+	log.Print("shutting down stats server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Fatalf("stats server did not shut down cleanly: %s", err.Error())
+	}
 }
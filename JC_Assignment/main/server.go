@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/qwex23/JC_Assignment/stats"
+)
+
+//http api in front of a stats struct
+//
+//	POST   /actions          add a single sample object or a json array of samples
+//	GET    /stats            all averages, optionally filtered with ?action=jump&action=run
+//	                         or queried with ?sort=name|avg|count&order=asc|desc&limit=&offset=&prefix=&match=&group=
+//	GET    /stats/{action}   the average of one action
+//	DELETE /stats/{action}   remove one action
+//	GET    /metrics          every action in the Prometheus text format
+type server struct {
+	st *stats.Stats
+	//largest request body accepted, in bytes
+	maxBodyBytes int64
+}
+
+//error body returned for every failed request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func newServer(st *stats.Stats, maxBodyBytes int64) *server {
+	return &server{
+		st:           st,
+		maxBodyBytes: maxBodyBytes,
+	}
+}
+
+//routes requests by path and method
+func (srv *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/actions":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		srv.postActions(w, r)
+	case r.URL.Path == "/stats":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		srv.getStats(w, r)
+	case r.URL.Path == "/metrics":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		srv.st.MetricsHandler().ServeHTTP(w, r)
+	case strings.HasPrefix(r.URL.Path, "/stats/") && len(r.URL.Path) > len("/stats/"):
+		action := strings.TrimPrefix(r.URL.Path, "/stats/")
+		switch r.Method {
+		case http.MethodGet:
+			srv.getAction(w, action)
+		case http.MethodDelete:
+			srv.deleteAction(w, action)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+//adds one sample or a batch of samples.
+//a single sample responds 204 or 400, a batch always reports which samples were rejected
+func (srv *server) postActions(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, srv.maxBodyBytes))
+	if err != nil {
+		//MaxBytesReader has no typed error to check for before go 1.19
+		if strings.Contains(err.Error(), "request body too large") {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body is larger than the limit")
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		report, err := srv.st.AddActions(string(trimmed))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, struct {
+				errorResponse
+				stats.BatchReport
+			}{errorResponse{err.Error()}, report})
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	if err := srv.st.AddAction(string(trimmed)); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//returns every average, or only the actions named in the query
+func (srv *server) getStats(w http.ResponseWriter, r *http.Request) {
+	actions := r.URL.Query()["action"]
+	if len(actions) == 0 {
+		opts, err := parseQuery(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		statsJson, err := srv.st.GetStats(opts...)
+		if errors.Is(err, stats.ErrInvalidUnit) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(statsJson))
+		return
+	}
+	//single actions are looked up as they are, so the options for listing cannot apply
+	for _, param := range queryParams {
+		if _, ok := r.URL.Query()[param]; ok {
+			writeError(w, http.StatusBadRequest, "action cannot be combined with "+param)
+			return
+		}
+	}
+	averages := make([]stats.SampleAverage, 0, len(actions))
+	for _, action := range actions {
+		if average, ok := srv.lookup(action); ok {
+			averages = append(averages, average)
+		}
+	}
+	writeJSON(w, http.StatusOK, averages)
+}
+
+//parameters of GET /stats that parseQuery reads
+var queryParams = []string{"sort", "order", "limit", "offset", "rollup", "prefix", "match", "group", "unit", "duration"}
+
+//turns the query string of GET /stats into options for GetStats
+func parseQuery(values url.Values) ([]stats.QueryOption, error) {
+	var opts []stats.QueryOption
+	field := stats.SortByName
+	switch values.Get("sort") {
+	case "", "name":
+	case "avg":
+		field = stats.SortByAverage
+	case "count":
+		field = stats.SortByCount
+	default:
+		return nil, errors.New("sort must be name, avg or count")
+	}
+	order := stats.Ascending
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		order = stats.Descending
+	default:
+		return nil, errors.New("order must be asc or desc")
+	}
+	opts = append(opts, stats.SortBy(field, order))
+
+	for _, param := range []string{"limit", "offset", "rollup"} {
+		value := values.Get(param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, errors.New(param + " must be 0 or a positive number")
+		}
+		switch param {
+		case "limit":
+			opts = append(opts, stats.Limit(n))
+		case "offset":
+			opts = append(opts, stats.Offset(n))
+		case "rollup":
+			opts = append(opts, stats.Rollup(n))
+		}
+	}
+	if prefix := values.Get("prefix"); prefix != "" {
+		opts = append(opts, stats.Prefix(prefix))
+	}
+	if match := values.Get("match"); match != "" {
+		re, err := regexp.Compile(match)
+		if err != nil {
+			return nil, errors.New("match is not a valid regex-> " + err.Error())
+		}
+		opts = append(opts, stats.Match(re))
+	}
+	if group := values.Get("group"); group != "" {
+		opts = append(opts, stats.GroupBy(group))
+	}
+	if unit := values.Get("unit"); unit != "" {
+		opts = append(opts, stats.InUnit(unit))
+	}
+	switch values.Get("duration") {
+	case "", "false":
+	case "true":
+		opts = append(opts, stats.AsDuration())
+	default:
+		return nil, errors.New("duration must be true or false")
+	}
+	return opts, nil
+}
+
+func (srv *server) getAction(w http.ResponseWriter, action string) {
+	average, ok := srv.lookup(action)
+	if !ok {
+		writeError(w, http.StatusNotFound, "action "+action+" not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, average)
+}
+
+func (srv *server) deleteAction(w http.ResponseWriter, action string) {
+	if !srv.st.Remove(action) {
+		writeError(w, http.StatusNotFound, "action "+action+" not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//average of a single action in the same shape as GetStats
+func (srv *server) lookup(action string) (stats.SampleAverage, bool) {
+	average, ok := srv.st.Lookup(action)
+	if !ok {
+		return stats.SampleAverage{}, false
+	}
+	return stats.SampleAverage{
+		Action:  action,
+		Average: average.UnitAverage(),
+		Unit:    average.Unit,
+	}, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(errorResponse{err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{message})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qwex23/JC_Assignment/stats"
+)
+
+//helper to start a test server around fresh stats
+func newTestServer(t *testing.T, maxBodyBytes int64) *httptest.Server {
+	t.Helper()
+	st := stats.NewStats()
+	ts := httptest.NewServer(newServer(&st, maxBodyBytes))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+//helper to make a request and read the whole response
+func do(t *testing.T, method string, url string, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(respBody)
+}
+
+//helper to read a stats array into a map of action to average
+func averagesByAction(t *testing.T, body string) map[string]uint64 {
+	t.Helper()
+	haveStruct := make([]stats.SampleAverage, 0)
+	if err := json.Unmarshal([]byte(body), &haveStruct); err != nil {
+		t.Fatalf("stats are not valid json %s: %s", err.Error(), body)
+	}
+	have := make(map[string]uint64)
+	for _, h := range haveStruct {
+		have[h.Action] = h.Average
+	}
+	return have
+}
+
+//test the hello world calls through the api
+func TestServer_PostAndGetStats(t *testing.T) {
+	ts := newTestServer(t, 1<<20)
+
+	for _, call := range []string{
+		"{\"action\":\"jump\", \"time\":100}",
+		"{\"action\":\"run\", \"time\":75}",
+		"{\"action\":\"jump\", \"time\":200}",
+	} {
+		if status, body := do(t, http.MethodPost, ts.URL+"/actions", call); status != http.StatusNoContent {
+			t.Fatalf("post %s returned %d %s", call, status, body)
+		}
+	}
+
+	status, body := do(t, http.MethodGet, ts.URL+"/stats", "")
+	if status != http.StatusOK {
+		t.Fatalf("get stats returned %d %s", status, body)
+	}
+	have := averagesByAction(t, body)
+	if len(have) != 2 || have["jump"] != 150 || have["run"] != 75 {
+		t.Fatalf("wrong stats %v", have)
+	}
+
+	status, body = do(t, http.MethodGet, ts.URL+"/stats?action=run&action=swim", "")
+	have = averagesByAction(t, body)
+	if status != http.StatusOK || len(have) != 1 || have["run"] != 75 {
+		t.Fatalf("wrong filtered stats %d %v", status, have)
+	}
+
+	status, body = do(t, http.MethodGet, ts.URL+"/metrics", "")
+	if status != http.StatusOK || !strings.Contains(body, `stats_action_time_average{action="jump"} 150`) {
+		t.Fatalf("wrong metrics %d %s", status, body)
+	}
+}
+
+//sorting, paging and filtering through the query string
+func TestServer_QueryStats(t *testing.T) {
+	ts := newTestServer(t, 1<<20)
+	do(t, http.MethodPost, ts.URL+"/actions", `[{"action":"jump", "time":100}, {"action":"run", "time":75}, {"action":"api.get", "time":300}]`)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", `[{"action":"api.get","avg":300},{"action":"jump","avg":100},{"action":"run","avg":75}]`},
+		{"?sort=avg&order=desc&limit=2", `[{"action":"api.get","avg":300},{"action":"jump","avg":100}]`},
+		{"?offset=1&limit=1", `[{"action":"jump","avg":100}]`},
+		{"?limit=0&offset=0", `[{"action":"api.get","avg":300},{"action":"jump","avg":100},{"action":"run","avg":75}]`},
+		{"?prefix=api.", `[{"action":"api.get","avg":300}]`},
+		{"?match=%5E(run%7Cjump)%24&sort=avg", `[{"action":"run","avg":75},{"action":"jump","avg":100}]`},
+	}
+	for _, tc := range tests {
+		status, body := do(t, http.MethodGet, ts.URL+"/stats"+tc.query, "")
+		if status != http.StatusOK || body != tc.want {
+			t.Errorf("%s returned %d\nhave %s\nwant %s", tc.query, status, body, tc.want)
+		}
+	}
+
+	//dotted names roll up by depth
+	do(t, http.MethodPost, ts.URL+"/actions", `[{"action":"api.put", "time":500}]`)
+	if _, body := do(t, http.MethodGet, ts.URL+"/stats?rollup=1&prefix=api.", ""); body != `[{"action":"api.*","avg":400}]` {
+		t.Errorf("wrong rollup %s", body)
+	}
+
+	//samples in different units are averaged in the first unit, or in the unit asked for
+	do(t, http.MethodPost, ts.URL+"/actions", `[{"action":"swim", "time":2, "unit":"ms"}, {"action":"swim", "time":1500, "unit":"us"}]`)
+	if _, body := do(t, http.MethodGet, ts.URL+"/stats?prefix=swim", ""); body != `[{"action":"swim","avg":1,"unit":"ms"}]` {
+		t.Errorf("wrong mixed unit stats %s", body)
+	}
+	if _, body := do(t, http.MethodGet, ts.URL+"/stats?prefix=swim&unit=us&duration=true", ""); body != `[{"action":"swim","avg":1750,"unit":"us","value":1750,"duration":"1.75ms"}]` {
+		t.Errorf("wrong converted stats %s", body)
+	}
+
+	for _, query := range []string{"?sort=speed", "?order=up", "?limit=-1", "?offset=x", "?match=%5B", "?unit=days", "?duration=yes", "?rollup=-1", "?action=jump&sort=avg", "?action=jump&limit=1", "?action=jump&unit="} {
+		if status, body := do(t, http.MethodGet, ts.URL+"/stats"+query, ""); status != http.StatusBadRequest {
+			t.Errorf("%s returned %d %s", query, status, body)
+		}
+	}
+}
+
+//a batch reports rejected samples and keeps the good ones
+func TestServer_PostBatch(t *testing.T) {
+	ts := newTestServer(t, 1<<20)
+
+	status, body := do(t, http.MethodPost, ts.URL+"/actions", `[{"action":"jump", "time":100}, {"action":"jump", "time":-1}]`)
+	if status != http.StatusOK {
+		t.Fatalf("post batch returned %d %s", status, body)
+	}
+	var report struct {
+		Accepted int `json:"accepted"`
+		Rejected []struct {
+			Line int `json:"line"`
+		} `json:"rejected"`
+	}
+	json.Unmarshal([]byte(body), &report)
+	if report.Accepted != 1 || len(report.Rejected) != 1 || report.Rejected[0].Line != 2 {
+		t.Fatalf("wrong batch report %s", body)
+	}
+
+	status, body = do(t, http.MethodPost, ts.URL+"/actions", `[{"action":"jump", "time":100}, {wd;;;]}`)
+	if status != http.StatusBadRequest || !strings.Contains(body, `"accepted":1`) {
+		t.Fatalf("bad batch returned %d %s", status, body)
+	}
+}
+
+//single actions can be read and removed
+func TestServer_ActionRoutes(t *testing.T) {
+	ts := newTestServer(t, 1<<20)
+	do(t, http.MethodPost, ts.URL+"/actions", "{\"action\":\"jump\", \"time\":100}")
+
+	status, body := do(t, http.MethodGet, ts.URL+"/stats/jump", "")
+	if status != http.StatusOK || body != `{"action":"jump","avg":100}` {
+		t.Fatalf("get action returned %d %s", status, body)
+	}
+	if status, _ := do(t, http.MethodDelete, ts.URL+"/stats/jump", ""); status != http.StatusNoContent {
+		t.Fatalf("delete action returned %d", status)
+	}
+	if status, _ := do(t, http.MethodGet, ts.URL+"/stats/jump", ""); status != http.StatusNotFound {
+		t.Fatalf("get removed action returned %d", status)
+	}
+	if status, _ := do(t, http.MethodDelete, ts.URL+"/stats/jump", ""); status != http.StatusNotFound {
+		t.Fatalf("delete removed action returned %d", status)
+	}
+}
+
+//bad requests get the right status codes
+func TestServer_Errors(t *testing.T) {
+	ts := newTestServer(t, 64)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"bad json", http.MethodPost, "/actions", "{wd;;;]}", http.StatusBadRequest},
+		{"too large", http.MethodPost, "/actions", `{"action":"` + strings.Repeat("a", 100) + `", "time":1}`, http.StatusRequestEntityTooLarge},
+		{"get actions", http.MethodGet, "/actions", "", http.StatusMethodNotAllowed},
+		{"post stats", http.MethodPost, "/stats", "", http.StatusMethodNotAllowed},
+		{"post metrics", http.MethodPost, "/metrics", "", http.StatusMethodNotAllowed},
+		{"put action", http.MethodPut, "/stats/jump", "", http.StatusMethodNotAllowed},
+		{"unknown path", http.MethodGet, "/nope", "", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := do(t, tc.method, ts.URL+tc.path, tc.body)
+			if status != tc.want {
+				t.Fatalf("have %d want %d: %s", status, tc.want, body)
+			}
+			if !strings.Contains(body, `"error"`) {
+				t.Fatalf("error body missing: %s", body)
+			}
+		})
+	}
+}
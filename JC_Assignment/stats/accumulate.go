@@ -0,0 +1,256 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+)
+
+//how the total time of an action is kept once it no longer fits in a uint64
+type Accumulation int
+
+const (
+	//exact uint64 totals, a sample that would overflow the total is refused. The default
+	AccumulateExact Accumulation = iota
+	//exact totals that grow past uint64 into a big.Int
+	AccumulateBig
+	//a Kahan compensated float64 sum, approximate but it never overflows
+	AccumulateKahan
+	//the total and the sample count are halved whenever the total would overflow,
+	//which keeps the average accurate but makes the count a weight rather than a count
+	AccumulateRescale
+)
+
+//sets how totals are kept once they no longer fit in a uint64.
+//With any strategy but AccumulateExact TotalTime stops at math.MaxUint64 and AverageTime
+//should be used instead of dividing TotalTime by NumSamples
+func WithAccumulation(accumulation Accumulation) Option {
+	return func(o *options) {
+		o.accumulation = accumulation
+	}
+}
+
+//adds two totals, stopping at math.MaxUint64 instead of wrapping around.
+//Used for window and label totals, which only need to be exact while the action total is
+func addSaturating(a uint64, b uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+	return sum
+}
+
+//adds a time to the totals of the average.
+//Returns false without changing anything when the total would overflow with AccumulateExact
+func (a *Average) accumulate(time uint64, accumulation Accumulation) bool {
+	if accumulation == AccumulateKahan {
+		a.addKahan(float64(time))
+	}
+	total, carry := bits.Add64(a.TotalTime, time, 0)
+	switch {
+	case carry == 0 && a.bigTotal == nil:
+		a.TotalTime = total
+	case accumulation == AccumulateBig:
+		if a.bigTotal == nil {
+			a.bigTotal = new(big.Int).SetUint64(a.TotalTime)
+		}
+		a.bigTotal.Add(a.bigTotal, new(big.Int).SetUint64(time))
+		a.TotalTime = math.MaxUint64
+	case accumulation == AccumulateKahan:
+		a.TotalTime = math.MaxUint64
+		a.saturated = true
+	case accumulation == AccumulateRescale:
+		a.NumSamples++
+		a.TotalTime = rescaleTotal(carry, total, a.NumSamples)
+		a.rescale()
+		return true
+	default:
+		return false
+	}
+	a.NumSamples++
+	return true
+}
+
+//scales the 128 bit total carry:total of count samples to count/2 samples with the same average.
+//count is at least 2 because a single sample always fits
+func rescaleTotal(carry uint64, total uint64, count uint64) uint64 {
+	avg, rem := bits.Div64(carry, total, count)
+	half := count / 2
+	//the remainder is scaled too so an odd count does not move the average
+	hi, lo := bits.Mul64(rem, half)
+	scaledRem, _ := bits.Div64(hi, lo, count)
+	return avg*half + scaledRem
+}
+
+//Neumaier's variant of Kahan summation, which also stays accurate when the time is larger than the sum
+func (a *Average) addKahan(v float64) {
+	sum := a.kahanSum + v
+	if math.Abs(a.kahanSum) >= math.Abs(v) {
+		a.kahanC += (a.kahanSum - sum) + v
+	} else {
+		a.kahanC += (v - sum) + a.kahanSum
+	}
+	a.kahanSum = sum
+}
+
+//halves the sample count and every count that must stay in step with it.
+//The total is halved by the caller, the mean, min and max do not change.
+//The count is at least 2 when the total overflows so it never reaches zero
+func (a *Average) rescale() {
+	a.NumSamples /= 2
+	a.m2 /= 2
+	for i := range a.hist.buckets {
+		a.hist.buckets[i].count = (a.hist.buckets[i].count + 1) / 2
+	}
+	for _, values := range a.labels {
+		for _, totals := range values {
+			totals.NumSamples = (totals.NumSamples + 1) / 2
+			totals.TotalTime /= 2
+		}
+	}
+}
+
+//the average time of the samples, correct with every accumulation strategy.
+//In nanoseconds once the action has a unit
+func (a *Average) AverageTime() uint64 {
+	if a.NumSamples == 0 {
+		return 0
+	}
+	switch {
+	case a.bigTotal != nil:
+		return new(big.Int).Quo(a.bigTotal, new(big.Int).SetUint64(a.NumSamples)).Uint64()
+	case a.saturated:
+		avg := (a.kahanSum + a.kahanC) / float64(a.NumSamples)
+		//the average can never be more than the largest time
+		if avg >= float64(a.Max) {
+			return a.Max
+		}
+		return uint64(avg)
+	}
+	return a.TotalTime / a.NumSamples
+}
+
+//the average time of the samples without truncating
+func (a *Average) averageFloat() float64 {
+	if a.NumSamples == 0 {
+		return 0
+	}
+	switch {
+	case a.bigTotal != nil:
+		avg, _ := new(big.Float).Quo(new(big.Float).SetInt(a.bigTotal), new(big.Float).SetUint64(a.NumSamples)).Float64()
+		return avg
+	case a.saturated:
+		return math.Min((a.kahanSum+a.kahanC)/float64(a.NumSamples), float64(a.Max))
+	}
+	return float64(a.TotalTime) / float64(a.NumSamples)
+}
+
+//the total time of the samples as a decimal, exact unless it is a Kahan sum
+func (a *Average) totalString() string {
+	switch {
+	case a.bigTotal != nil:
+		return a.bigTotal.String()
+	case a.saturated:
+		return strconv.FormatFloat(a.kahanSum+a.kahanC, 'f', 0, 64)
+	}
+	return strconv.FormatUint(a.TotalTime, 10)
+}
+
+//checks that totals kept with accumulation can be read into the stats.
+//Exact totals fit every strategy, anything else only fits the same strategy
+func (s *Stats) canAccumulate(accumulation Accumulation) error {
+	if accumulation != AccumulateExact && accumulation != s.accumulation {
+		return fmt.Errorf("totals kept with accumulation %d cannot be read into stats using accumulation %d", accumulation, s.accumulation)
+	}
+	return nil
+}
+
+//sets the totals of a restored average that do not fit in TotalTime
+func (s *Stats) restoreTotal(a *Average, st ActionState) error {
+	if st.BigTotal != "" {
+		if s.accumulation != AccumulateBig {
+			return errors.New("total past uint64 needs AccumulateBig")
+		}
+		total, ok := new(big.Int).SetString(st.BigTotal, 10)
+		if !ok || total.Sign() < 0 {
+			return fmt.Errorf("total %q is not a number", st.BigTotal)
+		}
+		a.bigTotal = total
+		a.TotalTime = math.MaxUint64
+	}
+	if st.Saturated && s.accumulation != AccumulateKahan {
+		return errors.New("saturated total needs AccumulateKahan")
+	}
+	if s.accumulation == AccumulateKahan {
+		a.kahanSum, a.kahanC = kahanOf(st)
+		a.saturated = st.Saturated
+		if math.IsNaN(a.kahanSum+a.kahanC) || math.IsInf(a.kahanSum+a.kahanC, 0) {
+			return errors.New("total is not a number")
+		}
+	}
+	return nil
+}
+
+//the compensated sum of a state, exact totals become a sum of their own
+func kahanOf(st ActionState) (sum float64, c float64) {
+	if st.KahanSum == 0 && st.KahanC == 0 {
+		return float64(st.TotalTime), 0
+	}
+	return st.KahanSum, st.KahanC
+}
+
+//the total of a state as a big.Int
+func bigOf(st ActionState) *big.Int {
+	if st.BigTotal != "" {
+		if total, ok := new(big.Int).SetString(st.BigTotal, 10); ok {
+			return total
+		}
+	}
+	return new(big.Int).SetUint64(st.TotalTime)
+}
+
+//adds the totals of two states into merged the same way accumulate adds a time
+func mergeTotals(a ActionState, b ActionState, merged *ActionState, accumulation Accumulation) error {
+	total, carry := bits.Add64(a.TotalTime, b.TotalTime, 0)
+	merged.TotalTime = total
+	switch {
+	case accumulation == AccumulateKahan:
+		//sum each side with its compensation, then add the compensations
+		aSum, aC := kahanOf(a)
+		bSum, bC := kahanOf(b)
+		avg := &Average{kahanSum: aSum, kahanC: aC + bC}
+		avg.addKahan(bSum)
+		merged.KahanSum, merged.KahanC = avg.kahanSum, avg.kahanC
+		merged.Saturated = a.Saturated || b.Saturated || carry != 0
+		if merged.Saturated {
+			merged.TotalTime = math.MaxUint64
+		}
+	case carry == 0 && a.BigTotal == "" && b.BigTotal == "":
+	case accumulation == AccumulateBig:
+		merged.BigTotal = new(big.Int).Add(bigOf(a), bigOf(b)).String()
+		merged.TotalTime = math.MaxUint64
+	case accumulation == AccumulateRescale && carry != 0:
+		merged.TotalTime = rescaleTotal(carry, total, merged.NumSamples)
+		merged.rescale()
+	default:
+		return fmt.Errorf("%w-> merging total time %d will overflow unint64 with current time total %d", ErrOverflow, b.TotalTime, a.TotalTime)
+	}
+	return nil
+}
+
+//halves the sample count of a state and the counts that must stay in step with it, like Average.rescale
+func (st *ActionState) rescale() {
+	st.NumSamples /= 2
+	st.M2 /= 2
+	for i := range st.Histogram {
+		st.Histogram[i][1] = (st.Histogram[i][1] + 1) / 2
+	}
+	for name, values := range st.Labels {
+		for value, totals := range values {
+			st.Labels[name][value] = LabelState{NumSamples: (totals.NumSamples + 1) / 2, TotalTime: totals.TotalTime / 2}
+		}
+	}
+}
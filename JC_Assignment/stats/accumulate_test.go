@@ -0,0 +1,117 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+//rescaling halves the count but the average, min and max stay right
+func TestAccumulateRescale(t *testing.T) {
+	st := NewStats(WithAccumulation(AccumulateRescale))
+	//the total overflows every few hundred samples
+	for i := 0; i < 5000; i++ {
+		st.addAction(Sample{Action: "jump", Time: 1 << 54})
+		st.addAction(Sample{Action: "jump", Time: 3 << 54})
+	}
+	jump, _ := st.Lookup("jump")
+	if math.Abs(float64(jump.AverageTime())/float64(2<<54)-1) > 0.001 {
+		t.Errorf("wrong average %d", jump.AverageTime())
+	}
+	if jump.Min != 1<<54 || jump.Max != 3<<54 {
+		t.Errorf("wrong min and max %+v", jump)
+	}
+	if jump.NumSamples >= 10000 || jump.NumSamples < 2 {
+		t.Errorf("count was not rescaled %d", jump.NumSamples)
+	}
+	//the running mean weights the latest sample by the rescaled count, so it is only close
+	if math.Abs(jump.mean/float64(2<<54)-1) > 0.01 {
+		t.Errorf("wrong running mean %f", jump.mean)
+	}
+}
+
+//window and label totals stop at the largest uint64 instead of wrapping around
+func TestAccumulate_Saturating(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	st := NewStats(WithAccumulation(AccumulateBig), WithWindow(time.Minute, time.Second), WithClock(clock))
+	for i := 0; i < 3; i++ {
+		st.AddSample(Sample{Action: "jump", Time: math.MaxUint64, Labels: map[string]string{"host": "a"}})
+	}
+	if have := windowAverages(t, &st, time.Minute); have["jump"] != math.MaxUint64/3 {
+		t.Errorf("window total wrapped around %v", have)
+	}
+	if have := groupedAverages(t, &st, "host"); have[[2]string{"jump", "a"}] != math.MaxUint64/3 {
+		t.Errorf("label total wrapped around %v", have)
+	}
+	statsJson, _ := st.GetStats()
+	if !strings.Contains(statsJson, "\"avg\":18446744073709551615") {
+		t.Errorf("big total lost the average %s", statsJson)
+	}
+}
+
+//totals past uint64 survive snapshots in both formats
+func TestAccumulation_Snapshot(t *testing.T) {
+	for _, accumulation := range []Accumulation{AccumulateBig, AccumulateKahan, AccumulateRescale} {
+		st := NewStats(WithAccumulation(accumulation))
+		st.addAction(Sample{Action: "jump", Time: math.MaxUint64})
+		st.addAction(Sample{Action: "jump", Time: math.MaxUint64 - 4095})
+		want, _ := st.Lookup("jump")
+
+		for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+			var buf bytes.Buffer
+			if format == SnapshotBinary {
+				encodeBinaryState(&buf, st.State())
+			} else {
+				json.NewEncoder(&buf).Encode(st.State())
+			}
+			restored := NewStats(WithAccumulation(accumulation))
+			if err := restored.Restore(&buf); err != nil {
+				t.Fatal(err.Error())
+			}
+			have, _ := restored.Lookup("jump")
+			if have.AverageTime() != want.AverageTime() || have.totalString() != want.totalString() {
+				t.Errorf("accumulation %d format %d restored %s / %d, want %s / %d", accumulation, format,
+					have.totalString(), have.AverageTime(), want.totalString(), want.AverageTime())
+			}
+		}
+
+		//totals past uint64 only fit the strategy that made them
+		exact := NewStats()
+		if err := exact.SetState(st.State()); err == nil {
+			t.Errorf("accumulation %d restored into exact totals", accumulation)
+		}
+	}
+
+	//exact totals can be read with any strategy
+	exact := NewStats()
+	exact.addAction(Sample{Action: "jump", Time: math.MaxUint64})
+	kahan := NewStats(WithAccumulation(AccumulateKahan))
+	if err := kahan.SetState(exact.State()); err != nil {
+		t.Fatal(err.Error())
+	}
+	kahan.addAction(Sample{Action: "jump", Time: math.MaxUint64})
+	jump, _ := kahan.Lookup("jump")
+	if jump.NumSamples != 2 || jump.AverageTime() != math.MaxUint64 {
+		t.Errorf("wrong totals after restoring exact totals %+v", jump)
+	}
+}
+
+//the sum is exported exactly from a big total
+func TestWriteOpenMetrics_BigTotal(t *testing.T) {
+	st := NewStats(WithAccumulation(AccumulateBig))
+	st.addAction(Sample{Action: "jump", Time: math.MaxUint64})
+	st.addAction(Sample{Action: "jump", Time: math.MaxUint64})
+	var buf bytes.Buffer
+	st.WriteOpenMetrics(&buf)
+	for _, line := range []string{
+		`stats_action_time_sum{action="jump"} 36893488147419103230`,
+		`stats_action_time_average{action="jump"} 18446744073709551615`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing line %s", line)
+		}
+	}
+}
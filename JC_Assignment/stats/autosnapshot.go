@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//writes a snapshot to path without ever leaving a half written file behind.
+//The snapshot is written and synced to a temporary file in the same directory,
+//which is then renamed over path
+func (s *Stats) SnapshotFile(path string) error {
+	return writeFileAtomic(path, s.Snapshot)
+}
+
+//writes a file with write, through a synced temporary file that is renamed over path
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	//clean up the temporary file on any failure, after the rename this is a no-op
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	//sync the directory so the rename itself survives a crash
+	syncDir(dir)
+	return nil
+}
+
+//replaces every action with the snapshot stored at path
+func (s *Stats) RestoreFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Restore(f)
+}
+
+//writes a snapshot to path every interval until the returned stop func is called.
+//A failed periodic snapshot is passed to onError, if it is not nil, and retried on the next tick.
+//stop writes one final snapshot and returns its error. The interval must be positive
+func (s *Stats) AutoSnapshot(path string, interval time.Duration, onError func(error)) (stop func() error, err error) {
+	//checked here since the ticker would panic inside the goroutine, where the caller cannot recover
+	if interval <= 0 {
+		return nil, errors.New("snapshot interval must be positive")
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.SnapshotFile(path); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	var stopErr error
+	return func() error {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			stopErr = s.SnapshotFile(path)
+		})
+		return stopErr
+	}, nil
+}
@@ -0,0 +1,106 @@
+package stats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//a snapshot file can be restored and leaves no temporary files behind
+func TestSnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.snapshot")
+
+	st := makeSnapshotStats(WithSnapshotFormat(SnapshotBinary))
+	if err := st.SnapshotFile(path); err != nil {
+		t.Fatalf("error from snapshot file %s", err.Error())
+	}
+	//overwriting an existing snapshot goes through the same rename
+	st.addAction(Sample{Action: "swim", Time: 1})
+	if err := st.SnapshotFile(path); err != nil {
+		t.Fatalf("error from second snapshot file %s", err.Error())
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("expected only the snapshot in the directory but found %d files", len(files))
+	}
+
+	restored := NewStats()
+	if err := restored.RestoreFile(path); err != nil {
+		t.Fatalf("error from restore file %s", err.Error())
+	}
+	if _, ok := restored.Lookup("swim"); !ok {
+		t.Fatal("the second snapshot was not restored")
+	}
+}
+
+//errors writing the temporary file are returned
+func TestSnapshotFile_MissingDir(t *testing.T) {
+	st := makeSnapshotStats()
+	if err := st.SnapshotFile(filepath.Join(os.TempDir(), "does-not-exist", "stats.snapshot")); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
+
+//the periodic snapshots and the final one on stop are written to the path
+func TestAutoSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.snapshot")
+
+	st := NewStats()
+	st.addAction(Sample{Action: "jump", Time: 100})
+	stop, err := st.AutoSnapshot(path, 10*time.Millisecond, func(err error) {
+		t.Errorf("error from periodic snapshot %s", err.Error())
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	//wait for a periodic snapshot
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no periodic snapshot was written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	st.addAction(Sample{Action: "run", Time: 75})
+	if err := stop(); err != nil {
+		t.Fatalf("error from the final snapshot %s", err.Error())
+	}
+	//stop is safe to call twice
+	stop()
+
+	restored := NewStats()
+	if err := restored.RestoreFile(path); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, ok := restored.Lookup("run"); !ok {
+		t.Fatal("the final snapshot was not written on stop")
+	}
+}
+
+//an interval the ticker cannot use is returned as an error instead of panicking in the goroutine
+func TestAutoSnapshot_Interval(t *testing.T) {
+	st := NewStats()
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if stop, err := st.AutoSnapshot(filepath.Join(t.TempDir(), "stats.snapshot"), interval, nil); err == nil || stop != nil {
+			t.Errorf("started snapshots every %s", interval)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+//number of NDJSON lines decoded before they are applied to the stats
+const ndjsonBatchSize = 1024
+
+//a sample from a batch that was not added, with the line it came from
+type SampleError struct {
+	//line of the NDJSON stream, or position in the JSON array, counting from 1
+	Line int
+	Err  error
+}
+
+func (e SampleError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+}
+
+func (e SampleError) Unwrap() error {
+	return e.Err
+}
+
+//errors do not marshal on their own, so write out the message
+func (e SampleError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Line  int    `json:"line"`
+		Error string `json:"error"`
+	}{e.Line, e.Err.Error()})
+}
+
+//result of adding a batch of samples
+//every valid sample is added even when others in the batch are rejected
+type BatchReport struct {
+	Accepted int           `json:"accepted"`
+	Rejected []SampleError `json:"rejected"`
+}
+
+//a decoded sample waiting to be applied along with where it came from
+type batchSample struct {
+	line   int
+	sample Sample
+}
+
+//adds every sample in a json array of samples.
+//Elements are decoded one at a time and rejected elements are listed in the report.
+//The returned error is only set when the array itself is malformed, in which case the
+//samples decoded before the problem are still added
+func (s *Stats) AddActions(jsonArray string) (BatchReport, error) {
+	report := BatchReport{Rejected: make([]SampleError, 0)}
+	batch := make([]batchSample, 0)
+	dec := json.NewDecoder(strings.NewReader(jsonArray))
+	if s.validation.disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+
+	//must open with [
+	tok, err := dec.Token()
+	if err != nil {
+		return report, errors.New("JSON Array is invalid-> " + err.Error())
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return report, errors.New("JSON Array is invalid-> expected [")
+	}
+
+	var decodeErr error
+	for line := 1; dec.More(); line++ {
+		var sample Sample
+		if err := dec.Decode(&sample); err != nil {
+			//type and unknown field errors still consume the element, anything else leaves the decoder lost
+			var typeErr *json.UnmarshalTypeError
+			rejectErr := decodeError(err)
+			if !errors.As(err, &typeErr) && !errors.Is(rejectErr, ErrUnknownField) {
+				decodeErr = errors.New("JSON Array is invalid-> " + err.Error())
+				break
+			}
+			report.Rejected = append(report.Rejected, SampleError{Line: line, Err: rejectErr})
+			continue
+		}
+		batch = append(batch, batchSample{line: line, sample: sample})
+	}
+	if decodeErr == nil {
+		if _, err := dec.Token(); err != nil {
+			decodeErr = errors.New("JSON Array is invalid-> " + err.Error())
+		}
+	}
+
+	if err := s.addBatch(batch, &report); err != nil && decodeErr == nil {
+		decodeErr = err
+	}
+	return report, decodeErr
+}
+
+//adds every sample from a stream of newline delimited json samples.
+//The stream is decoded line by line and applied in batches so memory stays flat for large inputs.
+//Malformed lines are listed in the report, the returned error is only set when reading
+//or the write ahead log fails
+func (s *Stats) IngestNDJSON(r io.Reader) (BatchReport, error) {
+	report := BatchReport{Rejected: make([]SampleError, 0)}
+	accepted, err := s.IngestNDJSONFunc(r, func(line int, err error) {
+		report.Rejected = append(report.Rejected, SampleError{Line: line, Err: err})
+	})
+	report.Accepted = accepted
+	return report, err
+}
+
+//same as IngestNDJSON but passes each rejected line to reject instead of listing it,
+//so streams with any number of bad lines use the same memory. Lines are rejected in order
+//once the batch they are in is applied. Returns the number of samples added
+func (s *Stats) IngestNDJSONFunc(r io.Reader, reject func(line int, err error)) (int, error) {
+	accepted := 0
+	report := BatchReport{Rejected: make([]SampleError, 0)}
+	batch := make([]batchSample, 0, ndjsonBatchSize)
+	//applies the batch and hands its rejected lines on, the report only ever holds one batch
+	flush := func() error {
+		err := s.addBatch(batch, &report)
+		accepted += report.Accepted
+		for _, rejected := range report.Rejected {
+			reject(rejected.Line, rejected.Err)
+		}
+		report = BatchReport{Rejected: report.Rejected[:0]}
+		batch = batch[:0]
+		return err
+	}
+	reader := bufio.NewReader(r)
+
+	for line := 1; ; line++ {
+		raw, readErr := reader.ReadBytes('\n')
+		raw = bytes.TrimSpace(raw)
+		if len(raw) > 0 {
+			if sample, err := s.decodeSample(raw); err != nil {
+				report.Rejected = append(report.Rejected, SampleError{Line: line, Err: err})
+			} else {
+				batch = append(batch, batchSample{line: line, sample: sample})
+			}
+		}
+		//lines that fail to decode count towards the batch so a stream of them is not held either
+		if len(batch)+len(report.Rejected) >= ndjsonBatchSize {
+			if err := flush(); err != nil {
+				return accepted, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			flush()
+			return accepted, readErr
+		}
+	}
+	err := flush()
+	return accepted, err
+}
+
+//applies decoded samples, locking each shard only once for the whole batch.
+//Samples for the same action are always in the same shard so they are applied in order
+func (s *Stats) addBatch(batch []batchSample, report *BatchReport) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	//group the valid samples by the shard that owns them
+	rejected := make([]SampleError, 0)
+	byShard := make(map[*shard][]batchSample)
+	for _, b := range batch {
+		if err := s.validate(b.sample); err != nil {
+			rejected = append(rejected, SampleError{Line: b.line, Err: err})
+			continue
+		}
+		sh := s.shardFor(b.sample.Action)
+		byShard[sh] = append(byShard[sh], b)
+	}
+
+	overflowed := make([]batchSample, 0)
+	crowded := make([]batchSample, 0)
+	var wal *WAL
+	for sh, samples := range byShard {
+		sh.mu.Lock()
+		wal = s.wal.log
+		for _, b := range samples {
+			err := s.addLocked(sh, b.sample)
+			if err == errActionsFull {
+				overflowed = append(overflowed, b)
+				continue
+			}
+			if err == errNoRoom {
+				crowded = append(crowded, b)
+				continue
+			}
+			if err != nil {
+				rejected = append(rejected, SampleError{Line: b.line, Err: err})
+				continue
+			}
+			report.Accepted++
+		}
+		sh.mu.Unlock()
+	}
+	//new actions that need another action evicted are added once every shard is unlocked, in order
+	for _, b := range crowded {
+		added, err := s.addToShard(b.sample)
+		wal = added
+		if err != nil {
+			rejected = append(rejected, SampleError{Line: b.line, Err: err})
+			continue
+		}
+		report.Accepted++
+	}
+	//samples for actions past WithMaxActions go to the overflow action once the other shards are unlocked
+	for _, b := range overflowed {
+		if err := s.addOverflow(b.sample); err != nil {
+			rejected = append(rejected, SampleError{Line: b.line, Err: err})
+			continue
+		}
+		report.Accepted++
+	}
+	//the whole batch is synced at once
+	syncErr := wal.syncAdd()
+
+	if len(rejected) == 0 {
+		return syncErr
+	}
+	//shards are visited in random order, keep the report in line order.
+	//decode errors from the lines of this batch are already in the report, so merge with them
+	sort.Slice(rejected, func(i, j int) bool {
+		return rejected[i].Line < rejected[j].Line
+	})
+	start := sort.Search(len(report.Rejected), func(i int) bool {
+		return report.Rejected[i].Line > batch[0].line
+	})
+	merged := make([]SampleError, 0, len(report.Rejected)-start+len(rejected))
+	tail := report.Rejected[start:]
+	for len(tail) > 0 || len(rejected) > 0 {
+		if len(rejected) == 0 || (len(tail) > 0 && tail[0].Line < rejected[0].Line) {
+			merged = append(merged, tail[0])
+			tail = tail[1:]
+		} else {
+			merged = append(merged, rejected[0])
+			rejected = rejected[1:]
+		}
+	}
+	report.Rejected = append(report.Rejected[:start], merged...)
+	return syncErr
+}
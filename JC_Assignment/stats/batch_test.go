@@ -0,0 +1,212 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//test a batch with good and bad elements keeps the good ones
+func TestAddActions(t *testing.T) {
+	st := NewStats()
+	batch := fmt.Sprintf(`[
+		{"action":"jump", "time":100},
+		{"action":"run", "time":-5},
+		{"action":"run", "time":75},
+		{"action":"jump", "time":%d},
+		{"action":"jump", "time":200}
+	]`, uint64(math.MaxUint64))
+
+	report, err := st.AddActions(batch)
+	if err != nil {
+		t.Fatalf("error from add actions %s", err.Error())
+	}
+	if report.Accepted != 3 {
+		t.Errorf("expected 3 accepted samples but found %d", report.Accepted)
+	}
+	if len(report.Rejected) != 2 || report.Rejected[0].Line != 2 || report.Rejected[1].Line != 4 {
+		t.Fatalf("wrong rejected samples %v", report.Rejected)
+	}
+
+	jump, _ := st.Lookup("jump")
+	if jump.NumSamples != 2 || jump.TotalTime != 300 {
+		t.Errorf("wrong totals for jump %+v", jump)
+	}
+	run, _ := st.Lookup("run")
+	if run.NumSamples != 1 || run.TotalTime != 75 {
+		t.Errorf("wrong totals for run %+v", run)
+	}
+}
+
+//a broken array still keeps the samples decoded before the problem
+func TestAddActions_BadJson(t *testing.T) {
+	st := NewStats()
+	report, err := st.AddActions(`[{"action":"jump", "time":100}, {wd;;;]}`)
+	if err == nil {
+		t.Fatal("Accepted Bad JSON!")
+	}
+	if report.Accepted != 1 {
+		t.Errorf("expected the first sample to be kept but found %d", report.Accepted)
+	}
+
+	for _, bad := range []string{"", "{\"action\":\"jump\", \"time\":100}", "[{\"action\":\"jump\", \"time\":100}"} {
+		if _, err := st.AddActions(bad); err == nil {
+			t.Errorf("Accepted Bad JSON array %q", bad)
+		}
+	}
+}
+
+//test a stream with blank, malformed and good lines
+func TestIngestNDJSON(t *testing.T) {
+	st := NewStats()
+	stream := strings.Join([]string{
+		`{"action":"jump", "time":100}`,
+		``,
+		`{wd;;;]}`,
+		`{"action":"run", "time":75}`,
+		`{"action":"jump", "time":200}`,
+	}, "\n")
+
+	report, err := st.IngestNDJSON(strings.NewReader(stream))
+	if err != nil {
+		t.Fatalf("error from ingest %s", err.Error())
+	}
+	if report.Accepted != 3 {
+		t.Errorf("expected 3 accepted samples but found %d", report.Accepted)
+	}
+	if len(report.Rejected) != 1 || report.Rejected[0].Line != 3 {
+		t.Fatalf("wrong rejected lines %v", report.Rejected)
+	}
+	jump, _ := st.Lookup("jump")
+	if jump.TotalTime/jump.NumSamples != 150 {
+		t.Errorf("wrong average for jump %+v", jump)
+	}
+}
+
+//rejections from decoding and from overflow interleave across batches, they must stay in line order
+func TestIngestNDJSON_ManyBatches(t *testing.T) {
+	st := NewStats()
+	var sb strings.Builder
+	for i := 1; i <= ndjsonBatchSize*3; i++ {
+		switch {
+		case i%7 == 0:
+			sb.WriteString("not json\n")
+		case i%11 == 0:
+			fmt.Fprintf(&sb, "{\"action\":\"big\", \"time\":%d}\n", uint64(math.MaxUint64))
+		default:
+			fmt.Fprintf(&sb, "{\"action\":\"Action%d\", \"time\":1}\n", i%50)
+		}
+	}
+
+	report, err := st.IngestNDJSON(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if report.Accepted+len(report.Rejected) != ndjsonBatchSize*3 {
+		t.Fatalf("accepted %d and rejected %d do not add up", report.Accepted, len(report.Rejected))
+	}
+	for i := 1; i < len(report.Rejected); i++ {
+		if report.Rejected[i-1].Line >= report.Rejected[i].Line {
+			t.Fatalf("rejected lines out of order at %d: %v", i, report.Rejected[i-1:i+1])
+		}
+	}
+	big, _ := st.Lookup("big")
+	if big.NumSamples != 1 {
+		t.Errorf("only the first big sample fits, found %d", big.NumSamples)
+	}
+}
+
+//rejected lines are handed on in order while the stream is still being read
+func TestIngestNDJSONFunc(t *testing.T) {
+	st := NewStats()
+	r, w := io.Pipe()
+	rejectedFirst := make(chan struct{})
+	streamed := make(chan bool, 1)
+	go func() {
+		for i := 0; i < ndjsonBatchSize; i++ {
+			fmt.Fprintf(w, "{\"action\":\"jump\", \"time\":-%d}\n", i+1)
+		}
+		//nothing more is written until the bad lines so far have been passed on
+		select {
+		case <-rejectedFirst:
+			streamed <- true
+		case <-time.After(5 * time.Second):
+			streamed <- false
+		}
+		w.Write([]byte("{\"action\":\"jump\", \"time\":100}\nnot json\n"))
+		w.Close()
+	}()
+
+	lines := make([]int, 0)
+	accepted, err := st.IngestNDJSONFunc(r, func(line int, err error) {
+		if len(lines) == 0 {
+			close(rejectedFirst)
+		}
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !<-streamed {
+		t.Errorf("rejected lines were held until the end of the stream")
+	}
+	want := make([]int, 0, ndjsonBatchSize+1)
+	for line := 1; line <= ndjsonBatchSize; line++ {
+		want = append(want, line)
+	}
+	want = append(want, ndjsonBatchSize+2)
+	if accepted != 1 || !reflect.DeepEqual(lines, want) {
+		t.Errorf("accepted %d and rejected lines %v", accepted, lines)
+	}
+}
+
+//reader that fails part way through
+type failingReader struct {
+	r io.Reader
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+//a read error is returned but the samples read before it are kept
+func TestIngestNDJSON_ReadError(t *testing.T) {
+	st := NewStats()
+	report, err := st.IngestNDJSON(&failingReader{strings.NewReader("{\"action\":\"jump\", \"time\":100}\n")})
+	if err == nil {
+		t.Fatal("expected the read error")
+	}
+	if report.Accepted != 1 {
+		t.Fatalf("expected 1 accepted sample but found %d", report.Accepted)
+	}
+}
+
+//benchmark adding a 1000 sample json array spread over 10 actions
+func BenchmarkAddActions(b *testing.B) {
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		st := newStats()
+		var sb strings.Builder
+		sb.WriteString("[")
+		for i := 0; i < 1000; i++ {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			fmt.Fprintf(&sb, "{\"action\":\"Action%d\", \"time\":1}", i%10)
+		}
+		sb.WriteString("]")
+		batch := sb.String()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.AddActions(batch)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//keeps the averages in a bbolt database file, so they survive restarts and can grow past memory.
+//Each shard keeps its actions in a bucket of its own. Opening the file with a different number of
+//shards moves the actions into the buckets of the new shards.
+//Every add is a transaction of its own and adds to different shards wait on the same file.
+//A storage is used by one stats struct at a time, and only one process can have the file open,
+//a second OpenBoltStorage returns an error if the file is not closed within the open timeout
+type BoltStorage struct {
+	db *bolt.DB
+	mu sync.Mutex
+	//number of shards the buckets are split into, 0 until the first Store
+	shards int
+	//first error moving actions between shards, returned by every store
+	err error
+}
+
+//configures a BoltStorage
+type BoltOption func(*boltOptions)
+
+type boltOptions struct {
+	noSync  bool
+	timeout time.Duration
+}
+
+//how long OpenBoltStorage waits for another process to close the file by default
+const defaultBoltTimeout = time.Second
+
+//skips syncing the file after every add. Adds are much faster,
+//but the latest ones can be lost if the machine crashes
+func WithBoltNoSync() BoltOption {
+	return func(o *boltOptions) {
+		o.noSync = true
+	}
+}
+
+//how long OpenBoltStorage waits for another process to close the file before returning an error.
+//A timeout of 0 or less keeps the default of one second
+func WithBoltTimeout(timeout time.Duration) BoltOption {
+	return func(o *boltOptions) {
+		if timeout > 0 {
+			o.timeout = timeout
+		}
+	}
+}
+
+//opens or creates the bbolt file at path
+//e.g. storage, err := OpenBoltStorage("stats.db"); st := NewStats(WithStorage(storage))
+func OpenBoltStorage(path string, opts ...BoltOption) (*BoltStorage, error) {
+	o := boltOptions{timeout: defaultBoltTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: o.timeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("cannot open storage-> %s is open in another process", path)
+	}
+	if err != nil {
+		return nil, errors.New("cannot open storage-> " + err.Error())
+	}
+	db.NoSync = o.noSync
+	return &BoltStorage{db: db}, nil
+}
+
+//closes the file, the stats using it must not be used afterwards
+func (b *BoltStorage) Close() error {
+	return b.db.Close()
+}
+
+//name of the bucket of shard i out of shards
+func boltBucket(i int, shards int) []byte {
+	return []byte("shard-" + strconv.Itoa(i) + "-of-" + strconv.Itoa(shards))
+}
+
+//key of action, prefixed because bbolt does not allow empty keys and actions can be empty
+func boltKey(action string) []byte {
+	return append([]byte{'/'}, action...)
+}
+
+//the store of shard i out of shards, moving the actions of other shard counts into place first
+func (b *BoltStorage) Store(shard int, shards int) Store {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.shards != shards && b.err == nil {
+		b.err = b.rebalance(shards)
+		b.shards = shards
+	}
+	store := &boltStore{db: b.db, bucket: boltBucket(shard, shards), err: b.err}
+	if store.err != nil {
+		return store
+	}
+	store.err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(store.bucket)
+		if err != nil {
+			return err
+		}
+		store.n = bucket.Stats().KeyN
+		return nil
+	})
+	return store
+}
+
+//moves every action kept in the buckets of a different number of shards into the buckets of shards
+func (b *BoltStorage) rebalance(shards int) error {
+	suffix := []byte("-of-" + strconv.Itoa(shards))
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		stale := make([][]byte, 0)
+		tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if bytes.HasPrefix(name, []byte("shard-")) && !bytes.HasSuffix(name, suffix) {
+				stale = append(stale, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		for _, name := range stale {
+			err := tx.Bucket(name).ForEach(func(k []byte, v []byte) error {
+				bucket, err := tx.CreateBucketIfNotExists(boltBucket(int(hashAction(string(k[1:]))%uint32(shards)), shards))
+				if err != nil {
+					return err
+				}
+				return bucket.Put(append([]byte(nil), k...), append([]byte(nil), v...))
+			})
+			if err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot move actions into %d shards-> %s", shards, err.Error())
+	}
+	return nil
+}
+
+//the actions of one shard in a bucket of their own. Every call is its own transaction
+type boltStore struct {
+	db     *bolt.DB
+	bucket []byte
+	//number of actions in the bucket, counted once when opened and kept up to date after
+	n   int
+	err error
+}
+
+func (b *boltStore) Get(action string) (*Average, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	var average *Average
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(b.bucket).Get(boltKey(action))
+		if data == nil {
+			return nil
+		}
+		average = &Average{}
+		return average.UnmarshalBinary(data)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s-> %s", action, err.Error())
+	}
+	return average, nil
+}
+
+func (b *boltStore) Put(action string, average *Average) error {
+	if b.err != nil {
+		return b.err
+	}
+	data, err := average.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	created := false
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.bucket)
+		created = bucket.Get(boltKey(action)) == nil
+		return bucket.Put(boltKey(action), data)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot write %s-> %s", action, err.Error())
+	}
+	if created {
+		b.n++
+	}
+	return nil
+}
+
+func (b *boltStore) Delete(action string) error {
+	if b.err != nil {
+		return b.err
+	}
+	deleted := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.bucket)
+		deleted = bucket.Get(boltKey(action)) != nil
+		return bucket.Delete(boltKey(action))
+	})
+	if err != nil {
+		return fmt.Errorf("cannot remove %s-> %s", action, err.Error())
+	}
+	if deleted {
+		b.n--
+	}
+	return nil
+}
+
+//fn runs inside a read transaction, so it must not call back into the store
+func (b *boltStore) Range(fn func(action string, average *Average) error) error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).ForEach(func(k []byte, v []byte) error {
+			average := &Average{}
+			if err := average.UnmarshalBinary(v); err != nil {
+				return fmt.Errorf("cannot read %s-> %s", k[1:], err.Error())
+			}
+			return fn(string(k[1:]), average)
+		})
+	})
+}
+
+func (b *boltStore) Len() int {
+	return b.n
+}
+
+func (b *boltStore) Clear() error {
+	if b.err != nil {
+		return b.err
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(b.bucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(b.bucket)
+		return err
+	})
+	if err != nil {
+		return errors.New("cannot clear storage-> " + err.Error())
+	}
+	b.n = 0
+	return nil
+}
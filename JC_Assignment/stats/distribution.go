@@ -0,0 +1,198 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+//number of bits used to split each power of two into linear sub buckets
+//5 bits gives 32 sub buckets, so any recorded value is within ~3% of the truth
+const histSubBucketBits = 5
+const histSubBuckets = 1 << histSubBucketBits
+
+//model for the distribution output object, extends SampleAverage with the spread of the samples
+type SampleDistribution struct {
+	SampleAverage
+	Count    uint64  `json:"count"`
+	Min      uint64  `json:"min"`
+	Max      uint64  `json:"max"`
+	Variance float64 `json:"variance"`
+	StdDev   float64 `json:"stddev"`
+	P50      uint64  `json:"p50"`
+	P90      uint64  `json:"p90"`
+	P99      uint64  `json:"p99"`
+}
+
+//one non empty bucket of the histogram
+type histBucket struct {
+	index uint16
+	count uint64
+}
+
+//log-linear histogram in the style of HDR histogram.
+//Only non empty buckets are stored, sorted by index. There are at most
+//64*histSubBuckets possible buckets so memory is bounded no matter how many samples are added
+type histogram struct {
+	buckets []histBucket
+}
+
+//maps a value to its bucket index
+//values smaller than histSubBuckets get an exact bucket each
+func histIndex(v uint64) uint16 {
+	if v < histSubBuckets {
+		return uint16(v)
+	}
+	shift := uint(bits.Len64(v)-1) - histSubBucketBits
+	mantissa := (v >> shift) & (histSubBuckets - 1)
+	return uint16((uint64(shift)+1)*histSubBuckets + mantissa)
+}
+
+//returns the smallest and largest value that land in the bucket at index
+func histBounds(index uint16) (lower uint64, upper uint64) {
+	if index < histSubBuckets {
+		return uint64(index), uint64(index)
+	}
+	shift := uint(index/histSubBuckets) - 1
+	mantissa := uint64(index % histSubBuckets)
+	lower = (histSubBuckets + mantissa) << shift
+	return lower, lower + (uint64(1) << shift) - 1
+}
+
+//records a single value
+func (h *histogram) add(v uint64) {
+	h.addCount(histIndex(v), 1)
+}
+
+//adds count to the bucket at index, keeping the buckets sorted
+func (h *histogram) addCount(index uint16, count uint64) {
+	i := sort.Search(len(h.buckets), func(i int) bool {
+		return h.buckets[i].index >= index
+	})
+	if i < len(h.buckets) && h.buckets[i].index == index {
+		h.buckets[i].count += count
+		return
+	}
+	h.buckets = append(h.buckets, histBucket{})
+	copy(h.buckets[i+1:], h.buckets[i:])
+	h.buckets[i] = histBucket{index: index, count: count}
+}
+
+//returns the value at quantile q (0 < q <= 1) of the total samples
+//the value reported is the middle of the bucket the quantile lands in
+func (h *histogram) quantile(q float64, total uint64) uint64 {
+	if total == 0 || len(h.buckets) == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for _, b := range h.buckets {
+		seen += b.count
+		if seen >= rank {
+			lower, upper := histBounds(b.index)
+			return lower + (upper-lower)/2
+		}
+	}
+	//total and the bucket counts disagree, report the top bucket
+	_, upper := histBounds(h.buckets[len(h.buckets)-1].index)
+	return upper
+}
+
+//updates the min, max, running variance and histogram of the average with a new accepted time.
+//must be called after NumSamples has been incremented
+func (a *Average) observe(time uint64) {
+	//the histogram is only empty before the first sample
+	first := len(a.hist.buckets) == 0
+	if first || time < a.Min {
+		a.Min = time
+	}
+	if first || time > a.Max {
+		a.Max = time
+	}
+	//Welford's online algorithm, stable for large values unlike a sum of squares
+	delta := float64(time) - a.mean
+	a.mean += delta / float64(a.NumSamples)
+	a.m2 += delta * (float64(time) - a.mean)
+	a.hist.add(time)
+}
+
+//population variance of the samples
+func (a *Average) variance() float64 {
+	if a.NumSamples == 0 {
+		return 0
+	}
+	return a.m2 / float64(a.NumSamples)
+}
+
+//clamps a quantile from the histogram to the exact observed range
+func (a *Average) quantile(q float64) uint64 {
+	v := a.hist.quantile(q, a.NumSamples)
+	if v < a.Min {
+		return a.Min
+	}
+	if v > a.Max {
+		return a.Max
+	}
+	return v
+}
+
+//builds the distribution output object for an action
+func (a *Average) distribution(action string) SampleDistribution {
+	//the spread is shown in the unit of the action, the variance in its square
+	f := float64(unitNanos(a.Unit))
+	variance := a.variance() / (f * f)
+	return SampleDistribution{
+		SampleAverage: SampleAverage{
+			Action:  action,
+			Average: a.UnitAverage(),
+			Unit:    a.Unit,
+		},
+		Count:    a.NumSamples,
+		Min:      a.inUnit(a.Min),
+		Max:      a.inUnit(a.Max),
+		Variance: variance,
+		StdDev:   math.Sqrt(variance),
+		P50:      a.inUnit(a.quantile(0.50)),
+		P90:      a.inUnit(a.quantile(0.90)),
+		P99:      a.inUnit(a.quantile(0.99)),
+	}
+}
+
+//returns min, max, variance, stddev and percentiles of every action as a json array sorted by name
+func (s *Stats) GetDistribution() (string, error) {
+	sliceDist, err := s.getSampleDistributionSlice()
+	if err != nil {
+		return "", err
+	}
+	jsonString, err := json.Marshal(sliceDist)
+	return string(jsonString), err
+}
+
+//traverses the stats map and builds the distribution of every action
+func (s *Stats) getSampleDistributionSlice() (DistributionSlice []SampleDistribution, errorReturn error) {
+	//catch any panics
+	defer func() {
+		if r := recover(); r != nil {
+			errorReturn = errors.New("error while getting distribution")
+		}
+	}()
+	DistributionSlice = make([]SampleDistribution, 0)
+
+	//thread safety
+	err := s.rangeAverages(func(action string, average *Average) {
+		DistributionSlice = append(DistributionSlice, average.distribution(action))
+	})
+	if err != nil {
+		return nil, err
+	}
+	//same stable order as GetStats
+	sort.Slice(DistributionSlice, func(i, j int) bool {
+		return DistributionSlice[i].Action < DistributionSlice[j].Action
+	})
+	return DistributionSlice, errorReturn
+}
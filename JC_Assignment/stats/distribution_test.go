@@ -0,0 +1,122 @@
+package stats
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+//helper to check a percentile lands within the relative error of the histogram
+func assertNear(t *testing.T, name string, have uint64, want uint64) {
+	t.Helper()
+	diff := math.Abs(float64(have) - float64(want))
+	if diff > float64(want)/histSubBuckets+1 {
+		t.Errorf("%s is %d, want about %d", name, have, want)
+	}
+}
+
+//test the distribution of 1..100 from the json api
+func TestGetDistribution(t *testing.T) {
+	st := NewStats()
+	for i := 1; i <= 100; i++ {
+		sample, _ := json.Marshal(Sample{Action: "jump", Time: uint64(i)})
+		if err := st.AddAction(string(sample)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	st.AddAction("{\"action\":\"run\", \"time\":75}")
+
+	distJson, err := st.GetDistribution()
+	if err != nil {
+		t.Fatalf("error from get distribution %s", err.Error())
+	}
+	haveStruct := make([]SampleDistribution, 0)
+	if err := json.Unmarshal([]byte(distJson), &haveStruct); err != nil {
+		t.Fatalf("distribution is not valid json %s", err.Error())
+	}
+	if len(haveStruct) != 2 {
+		t.Fatalf("expected 2 actions but found %d", len(haveStruct))
+	}
+
+	for _, h := range haveStruct {
+		switch h.Action {
+		case "jump":
+			if h.Count != 100 || h.Min != 1 || h.Max != 100 || h.Average != 50 {
+				t.Errorf("jump has wrong summary %+v", h)
+			}
+			//population variance of 1..n is (n^2-1)/12
+			if math.Abs(h.Variance-833.25) > 1e-9 {
+				t.Errorf("jump has wrong variance %f", h.Variance)
+			}
+			if math.Abs(h.StdDev-math.Sqrt(833.25)) > 1e-9 {
+				t.Errorf("jump has wrong stddev %f", h.StdDev)
+			}
+			assertNear(t, "p50", h.P50, 50)
+			assertNear(t, "p90", h.P90, 90)
+			assertNear(t, "p99", h.P99, 99)
+		case "run":
+			if h.Count != 1 || h.Min != 75 || h.Max != 75 || h.P50 != 75 || h.P99 != 75 || h.Variance != 0 {
+				t.Errorf("run has wrong distribution %+v", h)
+			}
+		default:
+			t.Errorf("unexpected action %s", h.Action)
+		}
+	}
+}
+
+//an overflowing sample is rejected, so it must not show up in the distribution either
+func TestGetDistribution_IntOverflow(t *testing.T) {
+	st := NewStats()
+	st.addAction(Sample{Action: "jump", Time: math.MaxUint64})
+	st.addAction(Sample{Action: "jump", Time: 1})
+
+	dist, err := st.getSampleDistributionSlice()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if dist[0].Count != 1 || dist[0].Min != math.MaxUint64 || dist[0].P50 != math.MaxUint64 {
+		t.Fatalf("rejected sample changed the distribution %+v", dist[0])
+	}
+}
+
+//every value must land in a bucket whose bounds contain it
+func TestHistogramBounds(t *testing.T) {
+	values := []uint64{0, 1, 31, 32, 33, 63, 64, 65, 1000, 123456789, math.MaxUint64 - 1, math.MaxUint64}
+	for i := 0; i < 1000; i++ {
+		values = append(values, rand.Uint64()>>uint(rand.Intn(64)))
+	}
+	for _, v := range values {
+		lower, upper := histBounds(histIndex(v))
+		if v < lower || v > upper {
+			t.Fatalf("value %d landed in bucket [%d, %d]", v, lower, upper)
+		}
+	}
+}
+
+//the histogram must stay bounded no matter how many distinct values it sees
+func TestHistogramBoundedMemory(t *testing.T) {
+	var h histogram
+	for i := 0; i < 1000000; i++ {
+		h.add(rand.Uint64() >> uint(rand.Intn(64)))
+	}
+	if len(h.buckets) > 64*histSubBuckets {
+		t.Fatalf("histogram grew to %d buckets", len(h.buckets))
+	}
+	for i := 1; i < len(h.buckets); i++ {
+		if h.buckets[i-1].index >= h.buckets[i].index {
+			t.Fatalf("histogram buckets are not sorted at %d", i)
+		}
+	}
+}
+
+//benchmark underlying distribution call with 10 unique actions
+func BenchmarkGetDistributionSmall_direct(b *testing.B) {
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		st := makeStatsWithUniqueActions(newStats, 10)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.getSampleDistributionSlice()
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+//exponentially weighted moving average of the times of one action.
+//Both the sum and the weight decay by half every half life, so the average is
+//sum/weight and samples at the same instant are weighted equally
+type ewma struct {
+	sum    float64
+	weight float64
+	//unix nanoseconds the sum and weight were last decayed to
+	at int64
+}
+
+//serializable state of a moving average
+type EWMAState struct {
+	Sum    float64 `json:"sum"`
+	Weight float64 `json:"weight"`
+	At     int64   `json:"at"`
+}
+
+//enables an exponentially weighted moving average for every action.
+//A sample halfLife old counts half as much as one added now
+//e.g. WithEWMA(time.Minute) follows changes in latency within a few minutes
+func WithEWMA(halfLife time.Duration) Option {
+	return func(o *options) {
+		o.ewmaHalfLife = halfLife
+	}
+}
+
+//whether moving averages were enabled with WithEWMA
+func (s *Stats) ewmaEnabled() bool {
+	return s.ewmaHalfLife > 0
+}
+
+//how much a value recorded elapsed nanoseconds ago still counts
+func decay(elapsed int64, halfLife time.Duration) float64 {
+	return math.Exp2(-float64(elapsed) / float64(halfLife))
+}
+
+//adds a time recorded at unix nanoseconds at.
+//A time older than the last one is weighted down instead of decaying everything else
+func (e *ewma) add(at int64, time uint64, halfLife time.Duration) {
+	weight := 1.0
+	if at > e.at {
+		d := decay(at-e.at, halfLife)
+		e.sum *= d
+		e.weight *= d
+		e.at = at
+	} else {
+		weight = decay(e.at-at, halfLife)
+	}
+	e.sum += weight * float64(time)
+	e.weight += weight
+}
+
+//the moving average, the decay since the last sample cancels out so no time is needed
+func (e *ewma) value() float64 {
+	if e.weight == 0 {
+		return 0
+	}
+	return e.sum / e.weight
+}
+
+//adds the moving average of the same action from another set of samples
+func (e ewma) merge(other ewma, halfLife time.Duration) ewma {
+	if other.at > e.at {
+		e, other = other, e
+	}
+	d := decay(e.at-other.at, halfLife)
+	e.sum += other.sum * d
+	e.weight += other.weight * d
+	return e
+}
+
+//records an accepted sample in the moving average of the average, if moving averages are enabled.
+//Samples are placed by their timestamp like windows, timestamps in the future count as now
+func (s *Stats) observeEWMA(average *Average, sample Sample) {
+	if !s.ewmaEnabled() {
+		return
+	}
+	at := s.clock.Now()
+	if !sample.Timestamp.IsZero() && sample.Timestamp.Before(at) {
+		at = sample.Timestamp
+	}
+	if average.ewma == nil {
+		average.ewma = &ewma{at: at.UnixNano()}
+	}
+	average.ewma.add(at.UnixNano(), sample.Time, s.ewmaHalfLife)
+}
+
+//returns the moving average of one action in its unit and whether it exists.
+//Always false when moving averages were not enabled with WithEWMA
+func (s *Stats) EWMA(action string) (float64, bool) {
+	sh := s.shardFor(action)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	average, err := sh.store.Get(action)
+	if err != nil || average == nil || average.ewma == nil {
+		return 0, false
+	}
+	return average.inUnitFloat(average.ewma.value()), true
+}
@@ -0,0 +1,128 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+//true when have is within a millionth of want
+func closeTo(have float64, want float64) bool {
+	return math.Abs(have-want) <= math.Abs(want)*1e-6
+}
+
+//a sample one half life old counts half as much as a new one
+func TestEWMA(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	st := NewStats(WithEWMA(time.Minute), WithClock(clock))
+
+	st.AddAction("{\"action\":\"jump\", \"time\":100}")
+	st.AddAction("{\"action\":\"jump\", \"time\":200}")
+	if have, _ := st.EWMA("jump"); !closeTo(have, 150) {
+		t.Errorf("samples at the same time should be averaged, have %f", have)
+	}
+
+	clock.Advance(time.Minute)
+	st.AddAction("{\"action\":\"jump\", \"time\":400}")
+	//the first two have a weight of a half each
+	if have, _ := st.EWMA("jump"); !closeTo(have, (50+100+400)/2.0) {
+		t.Errorf("wrong moving average after one half life %f", have)
+	}
+
+	//a timestamped sample from a minute ago is weighted down
+	st.AddSample(Sample{Action: "run", Time: 100})
+	st.AddSample(Sample{Action: "run", Time: 400, Timestamp: clock.now.Add(-time.Minute)})
+	if have, _ := st.EWMA("run"); !closeTo(have, (100+200)/1.5) {
+		t.Errorf("wrong moving average for an old sample %f", have)
+	}
+
+	//a long time later the drift shows without hiding the lifetime average
+	clock.Advance(time.Hour)
+	st.AddAction("{\"action\":\"jump\", \"time\":1000}")
+	statsJson, _ := st.GetStats()
+	haveStruct := make([]SampleAverage, 0)
+	json.Unmarshal([]byte(statsJson), &haveStruct)
+	for _, h := range haveStruct {
+		if h.Action == "jump" && (h.Average != 425 || !closeTo(h.EWMA, 1000)) {
+			t.Errorf("wrong averages for jump %+v", h)
+		}
+	}
+
+	if _, ok := st.EWMA("walk"); ok {
+		t.Error("missing action has a moving average")
+	}
+	plain := NewStats()
+	plain.AddAction("{\"action\":\"jump\", \"time\":100}")
+	if _, ok := plain.EWMA("jump"); ok {
+		t.Error("moving averages are not enabled")
+	}
+	if statsJson, _ := plain.GetStats(); strings.Contains(statsJson, "ewma") {
+		t.Errorf("moving average in output when not enabled %s", statsJson)
+	}
+}
+
+//merging two halves gives the same moving average as adding every sample to one
+func TestEWMA_MergeAndSnapshot(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	all := NewStats(WithEWMA(time.Minute), WithClock(clock))
+	a := NewStats(WithEWMA(time.Minute), WithClock(clock))
+	b := NewStats(WithEWMA(time.Minute), WithClock(clock))
+	for i, sampleTime := range []uint64{100, 300, 50, 800, 20, 400} {
+		all.AddSample(Sample{Action: "jump", Time: sampleTime})
+		if i%2 == 0 {
+			a.AddSample(Sample{Action: "jump", Time: sampleTime})
+		} else {
+			b.AddSample(Sample{Action: "jump", Time: sampleTime})
+		}
+		clock.Advance(15 * time.Second)
+	}
+	if err := a.Merge(&b); err != nil {
+		t.Fatal(err.Error())
+	}
+	want, _ := all.EWMA("jump")
+	if have, _ := a.EWMA("jump"); !closeTo(have, want) {
+		t.Errorf("merged moving average %f, want %f", have, want)
+	}
+
+	for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+		var buf bytes.Buffer
+		if format == SnapshotBinary {
+			encodeBinaryState(&buf, all.State())
+		} else {
+			json.NewEncoder(&buf).Encode(all.State())
+		}
+		restored := NewStats(WithEWMA(time.Minute))
+		if err := restored.Restore(&buf); err != nil {
+			t.Fatal(err.Error())
+		}
+		if have, _ := restored.EWMA("jump"); have != want {
+			t.Errorf("format %d restored moving average %f, want %f", format, have, want)
+		}
+	}
+
+	//a different half life cannot be carried over
+	other := NewStats(WithEWMA(time.Hour))
+	other.SetState(all.State())
+	if _, ok := other.EWMA("jump"); ok {
+		t.Error("restored a moving average with a different half life")
+	}
+	if _, err := all.State().Merge(other.State()); err == nil {
+		t.Error("merged moving averages with different half lives")
+	}
+}
+
+//the moving average is exposed as a gauge
+func TestWriteOpenMetrics_EWMA(t *testing.T) {
+	st := NewStats(WithEWMA(time.Minute), WithClock(&fakeClock{now: time.Unix(1600000000, 0)}))
+	st.AddAction("{\"action\":\"jump\", \"time\":100}")
+	st.AddAction("{\"action\":\"jump\", \"time\":200}")
+	var buf bytes.Buffer
+	st.WriteOpenMetrics(&buf)
+	if !strings.Contains(buf.String(), "# TYPE stats_action_time_ewma gauge\n") ||
+		!strings.Contains(buf.String(), "stats_action_time_ewma{action=\"jump\"} 150\n") {
+		t.Fatalf("missing moving average\n%s", buf.String())
+	}
+}
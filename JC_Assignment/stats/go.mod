@@ -1,3 +1,5 @@
 module github.com/qwex23/JC_Assignment/stats
 
 go 1.16
+
+require go.etcd.io/bbolt v1.3.6
@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+)
+
+//totals for the samples of an action that share one label value
+type labelTotals struct {
+	NumSamples uint64
+	TotalTime  uint64
+}
+
+//whether unit is one of the units a sample can declare
+func validUnit(unit string) bool {
+	switch unit {
+	case "", "ns", "us", "ms", "s":
+		return true
+	}
+	return false
+}
+
+//adds a sample timed with a time.Duration, recorded in nanoseconds.
+//labels are optional and can be nil
+func (s *Stats) Record(action string, d time.Duration, labels map[string]string) error {
+	if d < 0 {
+		return fmt.Errorf("%w-> %s is negative", ErrTimeOutOfRange, d)
+	}
+	return s.addAction(Sample{
+		Action: action,
+		Time:   uint64(d),
+		Unit:   "ns",
+		Labels: labels,
+	})
+}
+
+//adds an accepted sample to the totals of each of its label values.
+//Label totals are a split of the action totals, they stop at math.MaxUint64 when the action total has grown past it
+func (a *Average) observeLabels(sample Sample) {
+	if len(sample.Labels) == 0 && len(a.labels) == 0 {
+		return
+	}
+	if a.labels == nil {
+		a.labels = make(map[string]map[string]*labelTotals)
+	}
+	for name, value := range sample.Labels {
+		values := a.labels[name]
+		if values == nil {
+			values = make(map[string]*labelTotals)
+			//samples before the first with this label belong to the empty value
+			if earlier := a.NumSamples - 1; earlier > 0 {
+				values[""] = &labelTotals{NumSamples: earlier}
+				//a saturated or rescaled total can be less than the time
+				if a.TotalTime > sample.Time {
+					values[""].TotalTime = a.TotalTime - sample.Time
+				}
+			}
+			a.labels[name] = values
+		}
+		addLabelTotals(values, value, sample.Time)
+	}
+	//samples without a label that other samples had count towards the empty value
+	for name, values := range a.labels {
+		if _, ok := sample.Labels[name]; !ok {
+			addLabelTotals(values, "", sample.Time)
+		}
+	}
+}
+
+func addLabelTotals(values map[string]*labelTotals, value string, time uint64) {
+	totals := values[value]
+	if totals == nil {
+		totals = &labelTotals{}
+		values[value] = totals
+	}
+	totals.NumSamples++
+	totals.TotalTime = addSaturating(totals.TotalTime, time)
+}
+
+//the averages of an action split by the values of the label q groups by
+func (a *Average) groupedAverages(action string, q *query) []statsRow {
+	values := a.labels[q.groupBy]
+	if len(values) == 0 {
+		//no sample had the label, so all of them have the empty value
+		row := q.row(action, a.Unit, a.AverageTime(), a.averageFloat(), a.NumSamples)
+		row.average.Labels = map[string]string{q.groupBy: ""}
+		return []statsRow{row}
+	}
+	rows := make([]statsRow, 0, len(values))
+	for value, totals := range values {
+		row := q.row(action, a.Unit, totals.TotalTime/totals.NumSamples, float64(totals.TotalTime)/float64(totals.NumSamples), totals.NumSamples)
+		row.average.Labels = map[string]string{q.groupBy: value}
+		rows = append(rows, row)
+	}
+	return rows
+}
@@ -0,0 +1,205 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+//helper to read grouped stats into a map of action and label value to average
+func groupedAverages(t *testing.T, st *Stats, label string) map[[2]string]uint64 {
+	t.Helper()
+	statsJson, err := st.GetStats(GroupBy(label))
+	if err != nil {
+		t.Fatalf("error from get stats %s", err.Error())
+	}
+	haveStruct := make([]SampleAverage, 0)
+	json.Unmarshal([]byte(statsJson), &haveStruct)
+	have := make(map[[2]string]uint64)
+	for _, h := range haveStruct {
+		value, ok := h.Labels[label]
+		if !ok || len(h.Labels) != 1 {
+			t.Fatalf("group is missing the %s label %+v", label, h)
+		}
+		have[[2]string{h.Action, value}] = h.Average
+	}
+	return have
+}
+
+//test the typed api records durations in nanoseconds
+func TestRecord(t *testing.T) {
+	st := NewStats()
+	if err := st.Record("jump", 100*time.Millisecond, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := st.Record("jump", 200*time.Millisecond, map[string]string{"host": "a"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := st.Record("jump", -time.Second, nil); err == nil {
+		t.Fatal("accepted a negative duration")
+	}
+
+	jump, _ := st.Lookup("jump")
+	if jump.NumSamples != 2 || jump.TotalTime != uint64(300*time.Millisecond) || jump.Unit != "ns" {
+		t.Fatalf("wrong totals for jump %+v", jump)
+	}
+
+	//the overflow error is no longer swallowed
+	if err := st.Record("jump", time.Duration(math.MaxInt64), nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := st.Record("jump", time.Duration(math.MaxInt64), nil); err == nil {
+		t.Fatal("TotalTime for jump exceeded maxuint64")
+	}
+}
+
+//AddAction returns the overflow error from addAction
+func TestAddAction_IntOverflowReturned(t *testing.T) {
+	st := NewStats()
+	st.addAction(Sample{Action: "jump", Time: math.MaxUint64})
+	if err := st.AddAction("{\"action\":\"jump\", \"time\":1}"); err == nil {
+		t.Fatal("TotalTime for jump exceeded maxuint64")
+	}
+}
+
+//GetStats can split each action by a label
+func TestGetStats_GroupBy(t *testing.T) {
+	st := NewStats()
+	st.AddAction(`{"action":"jump", "time":50}`)
+	st.AddAction(`{"action":"jump", "time":100, "labels":{"host":"a", "region":"east"}}`)
+	st.AddAction(`{"action":"jump", "time":200, "labels":{"host":"a"}}`)
+	st.AddAction(`{"action":"jump", "time":400, "labels":{"host":"b", "region":"east"}}`)
+	st.AddAction(`{"action":"run", "time":75}`)
+
+	byHost := groupedAverages(t, &st, "host")
+	want := map[[2]string]uint64{
+		{"jump", ""}:  50,
+		{"jump", "a"}: 150,
+		{"jump", "b"}: 400,
+		{"run", ""}:   75,
+	}
+	if len(byHost) != len(want) {
+		t.Fatalf("wrong groups by host %v", byHost)
+	}
+	for k, v := range want {
+		if byHost[k] != v {
+			t.Errorf("%v has average %d, want %d", k, byHost[k], v)
+		}
+	}
+
+	byRegion := groupedAverages(t, &st, "region")
+	if byRegion[[2]string{"jump", "east"}] != 250 || byRegion[[2]string{"jump", ""}] != 125 {
+		t.Errorf("wrong groups by region %v", byRegion)
+	}
+
+	//the ungrouped averages are unchanged
+	jump, _ := st.Lookup("jump")
+	if jump.NumSamples != 4 || jump.TotalTime != 750 {
+		t.Errorf("wrong average for jump %+v", jump)
+	}
+}
+
+//samples for one action cannot mix units
+func TestAddSample_Units(t *testing.T) {
+	st := NewStats()
+	if err := st.AddSample(Sample{Action: "jump", Time: 100}); err != nil {
+		t.Fatal(err.Error())
+	}
+	//the unit of the sample before is unknown, so a unit cannot be declared after it
+	if err := st.AddSample(Sample{Action: "jump", Time: 100, Unit: "ms"}); !errors.Is(err, ErrUnitMismatch) {
+		t.Fatalf("accepted milliseconds for an action without a unit %v", err)
+	}
+	if err := st.AddSample(Sample{Action: "jump", Time: 1, Unit: "s"}); err == nil {
+		t.Fatal("accepted seconds for an action without a unit")
+	}
+	if err := st.AddSample(Sample{Action: "run", Time: 1, Unit: "minutes"}); err == nil {
+		t.Fatal("accepted an unknown unit")
+	}
+	if _, ok := st.Lookup("run"); ok {
+		t.Fatal("a rejected sample created an action")
+	}
+	jump, _ := st.Lookup("jump")
+	if jump.NumSamples != 1 || jump.Unit != "" {
+		t.Fatalf("wrong totals for jump %+v", jump)
+	}
+}
+
+//timestamps place samples in older window buckets
+func TestAddSample_Timestamp(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	st := NewStats(WithWindow(10*time.Minute, 10*time.Second), WithClock(clock))
+
+	st.AddSample(Sample{Action: "jump", Time: 100, Timestamp: clock.now.Add(-5 * time.Minute)})
+	st.AddSample(Sample{Action: "jump", Time: 300})
+	//too old for the window, only counted in the lifetime totals
+	st.AddSample(Sample{Action: "jump", Time: 1000, Timestamp: clock.now.Add(-time.Hour)})
+	//from the future, counted as now
+	st.AddSample(Sample{Action: "jump", Time: 500, Timestamp: clock.now.Add(time.Hour)})
+
+	if have := windowAverages(t, &st, time.Minute); have["jump"] != 400 {
+		t.Errorf("wrong 1m window %v", have)
+	}
+	if have := windowAverages(t, &st, 10*time.Minute); have["jump"] != 300 {
+		t.Errorf("wrong 10m window %v", have)
+	}
+	jump, _ := st.Lookup("jump")
+	if jump.NumSamples != 4 {
+		t.Errorf("the old sample was left out of the lifetime totals %+v", jump)
+	}
+}
+
+//labels and units survive snapshots and merges
+func TestLabels_SnapshotAndMerge(t *testing.T) {
+	st := NewStats()
+	st.AddSample(Sample{Action: "jump", Time: 100, Unit: "ms", Labels: map[string]string{"host": "a"}})
+	other := NewStats()
+	other.AddSample(Sample{Action: "jump", Time: 300, Unit: "ms"})
+	other.AddSample(Sample{Action: "jump", Time: 500, Unit: "ms", Labels: map[string]string{"host": "b"}})
+
+	if err := st.Merge(&other); err != nil {
+		t.Fatal(err.Error())
+	}
+	want := map[[2]string]uint64{
+		{"jump", "a"}: 100,
+		{"jump", ""}:  300,
+		{"jump", "b"}: 500,
+	}
+	for k, v := range groupedAverages(t, &st, "host") {
+		if want[k] != v {
+			t.Errorf("%v has average %d after merge, want %d", k, v, want[k])
+		}
+	}
+
+	for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+		var buf bytes.Buffer
+		if format == SnapshotBinary {
+			encodeBinaryState(&buf, st.State())
+		} else {
+			json.NewEncoder(&buf).Encode(st.State())
+		}
+		restored := NewStats()
+		if err := restored.Restore(&buf); err != nil {
+			t.Fatal(err.Error())
+		}
+		if got := groupedAverages(t, &restored, "host"); len(got) != 3 {
+			t.Errorf("format %d lost label groups %v", format, got)
+		}
+		jump, _ := restored.Lookup("jump")
+		if jump.Unit != "ms" {
+			t.Errorf("format %d lost the unit %+v", format, jump)
+		}
+	}
+
+	//seconds are converted, the finer unit is kept
+	seconds := NewStats()
+	seconds.AddSample(Sample{Action: "jump", Time: 1, Unit: "s"})
+	if err := seconds.Merge(&st); err != nil {
+		t.Fatal(err.Error())
+	}
+	if jump, _ := seconds.Lookup("jump"); jump.Unit != "ms" || jump.UnitAverage() != 475 {
+		t.Fatalf("wrong average after merging seconds and milliseconds %+v", jump)
+	}
+}
@@ -0,0 +1,406 @@
+package stats
+
+import (
+	"container/heap"
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+//name of the action that absorbs the samples of new actions rejected by WithMaxActions.
+//It never counts towards the cap and is never evicted
+const OverflowAction = "_overflow"
+
+//what happens to a new action once WithMaxActions is reached
+type EvictionPolicy int
+
+const (
+	//samples for new actions are added to OverflowAction instead
+	RejectNew EvictionPolicy = iota
+	//the action that least recently had a sample added is removed to make room
+	EvictLRU
+	//the action with the fewest samples is removed to make room
+	EvictLeastFrequent
+)
+
+//returned by addLocked when a new action does not fit under the cap and should go to OverflowAction
+var errActionsFull = errors.New("too many actions")
+
+//returned by addLocked when a new action does not fit under the cap until another action is evicted
+var errNoRoom = errors.New("no room for a new action")
+
+//caps the number of distinct actions kept so a client sending endless unique names cannot exhaust memory.
+//The cap covers every shard, and the action evicted to make room is picked from all of them
+//e.g. WithMaxActions(100000, EvictLRU)
+func WithMaxActions(maxActions int, policy EvictionPolicy) Option {
+	return func(o *options) {
+		o.maxActions = maxActions
+		o.evictionPolicy = policy
+	}
+}
+
+//the cap shared by every shard of the stats
+type actionLimit struct {
+	//actions kept that count towards the cap, only changed atomically
+	actions int64
+	//stamps each action when it gets a sample, so the least recently used action
+	//can be found across shards. Only changed atomically
+	clock  uint64
+	max    int
+	policy EvictionPolicy
+	//one new action at a time looks for an action to evict, so two never evict for the same room
+	evictMu sync.Mutex
+}
+
+//nil when there is no cap
+func newActionLimit(max int, policy EvictionPolicy) *actionLimit {
+	if max <= 0 {
+		return nil
+	}
+	return &actionLimit{max: max, policy: policy}
+}
+
+//whether a should be evicted before b
+func (l *actionLimit) before(a *evictEntry, b *evictEntry) bool {
+	if l.policy == EvictLeastFrequent && a.numSamples != b.numSamples {
+		return a.numSamples < b.numSamples
+	}
+	return a.used < b.used
+}
+
+//counts of the actions kept, evicted and rejected under WithMaxActions
+type CardinalityStats struct {
+	//distinct actions kept, not counting OverflowAction
+	Actions int `json:"actions"`
+	//the cap, 0 when there is none
+	MaxActions int `json:"maxActions"`
+	//actions removed to make room for new ones
+	Evicted uint64 `json:"evicted"`
+	//samples for new actions that were added to OverflowAction instead
+	Rejected uint64 `json:"rejected"`
+}
+
+//returns how many actions are kept and how many were evicted or rejected
+func (s *Stats) Cardinality() CardinalityStats {
+	s.lockAll()
+	defer s.unlockAll()
+	c := CardinalityStats{MaxActions: s.maxActions}
+	for _, sh := range s.shards {
+		c.Actions += sh.numActions()
+		c.Evicted += sh.evicted
+		c.Rejected += sh.rejected
+	}
+	return c
+}
+
+//shares the cap with the shards and sets up eviction, before the stats are used
+func (s *Stats) limitShards() {
+	if s.limit == nil {
+		return
+	}
+	for _, sh := range s.shards {
+		sh.limit = s.limit
+	}
+	//actions already in the storage count towards the cap
+	if err := s.retrack(); err != nil {
+		for _, sh := range s.shards {
+			if sh.err == nil {
+				sh.err = errors.New("cannot track the actions already in the storage-> " + err.Error())
+			}
+		}
+	}
+}
+
+//number of actions in the shard that count towards the cap
+func (sh *shard) numActions() int {
+	n := sh.store.Len()
+	if overflow, _ := sh.store.Get(OverflowAction); overflow != nil {
+		n--
+	}
+	return n
+}
+
+//takes a slot under the cap for a new action, the shard must be locked.
+//Returns errActionsFull when the policy is to reject new actions and errNoRoom when
+//an action has to be evicted first
+func (sh *shard) reserve(action string) error {
+	if sh.limit == nil || action == OverflowAction {
+		return nil
+	}
+	if atomic.AddInt64(&sh.limit.actions, 1) <= int64(sh.limit.max) {
+		return nil
+	}
+	atomic.AddInt64(&sh.limit.actions, -1)
+	if sh.evictor == nil {
+		sh.rejected++
+		return errActionsFull
+	}
+	return errNoRoom
+}
+
+//gives back the slot of an action that was removed or never added
+func (sh *shard) release(action string) {
+	if sh.limit != nil && action != OverflowAction {
+		atomic.AddInt64(&sh.limit.actions, -1)
+	}
+}
+
+//evicts the action picked by the eviction policy out of every shard if the cap is still reached.
+//No shard may be locked, each is locked in turn while its next victim is compared
+func (s *Stats) makeRoom() error {
+	s.limit.evictMu.Lock()
+	defer s.limit.evictMu.Unlock()
+	if atomic.LoadInt64(&s.limit.actions) < int64(s.limit.max) {
+		return nil
+	}
+	sh := s.victimShard(false)
+	if sh == nil {
+		return errors.New("no action can be evicted to make room for a new action")
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	//emptied since it was looked at, the caller tries again
+	if len(sh.tracked) == 0 {
+		return nil
+	}
+	return sh.evict()
+}
+
+//the shard holding the action to evict next, nil when no action is tracked.
+//locked is whether every shard is already locked
+func (s *Stats) victimShard(locked bool) *shard {
+	var found *shard
+	var victim evictEntry
+	for _, sh := range s.shards {
+		if !locked {
+			sh.mu.Lock()
+		}
+		if len(sh.tracked) > 0 {
+			if next := sh.evictor.victim(); found == nil || s.limit.before(next, &victim) {
+				found, victim = sh, *next
+			}
+		}
+		if !locked {
+			sh.mu.Unlock()
+		}
+	}
+	return found
+}
+
+//removes the action of the shard picked by the eviction policy
+func (sh *shard) evict() error {
+	victim := sh.evictor.victim()
+	if err := sh.store.Delete(victim.action); err != nil {
+		return err
+	}
+	sh.evictor.remove(victim)
+	delete(sh.tracked, victim.action)
+	delete(sh.above, victim.action)
+	sh.release(victim.action)
+	sh.evicted++
+	return nil
+}
+
+//starts tracking a new action for eviction
+func (sh *shard) track(action string, average *Average) {
+	if sh.evictor == nil || action == OverflowAction {
+		return
+	}
+	entry := &evictEntry{action: action, numSamples: average.NumSamples, used: atomic.AddUint64(&sh.limit.clock, 1)}
+	sh.tracked[action] = entry
+	sh.evictor.add(entry)
+}
+
+//records that a sample was added to a tracked action
+func (sh *shard) touch(action string, average *Average) {
+	if entry := sh.tracked[action]; entry != nil {
+		entry.numSamples = average.NumSamples
+		entry.used = atomic.AddUint64(&sh.limit.clock, 1)
+		sh.evictor.touch(entry)
+	}
+}
+
+//stops tracking an action that was removed
+func (sh *shard) untrack(action string) {
+	if entry := sh.tracked[action]; entry != nil {
+		sh.evictor.remove(entry)
+		delete(sh.tracked, action)
+	}
+}
+
+//rebuilds the eviction order and the count of actions after the averages were replaced,
+//then evicts down to the cap. Every shard must be locked
+func (s *Stats) retrack() error {
+	if s.limit == nil {
+		return nil
+	}
+	actions := 0
+	for _, sh := range s.shards {
+		sh.resetEvictor(s.evictionPolicy)
+		err := sh.store.Range(func(action string, average *Average) error {
+			sh.track(action, average)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		actions += sh.numActions()
+	}
+	atomic.StoreInt64(&s.limit.actions, int64(actions))
+	for atomic.LoadInt64(&s.limit.actions) > int64(s.limit.max) {
+		sh := s.victimShard(true)
+		if sh == nil {
+			return nil
+		}
+		if err := sh.evict(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sh *shard) resetEvictor(policy EvictionPolicy) {
+	sh.tracked = make(map[string]*evictEntry)
+	switch policy {
+	case EvictLRU:
+		sh.evictor = &lruEvictor{order: list.New()}
+	case EvictLeastFrequent:
+		sh.evictor = &lfuEvictor{}
+	default:
+		sh.evictor = nil
+	}
+}
+
+//checks that count actions fit under the cap, only needed when new actions are rejected
+func (s *Stats) fits(count int) error {
+	if s.limit == nil || s.evictionPolicy != RejectNew || count <= s.limit.max {
+		return nil
+	}
+	return fmt.Errorf("%d actions do not fit under the cap of %d, WithMaxActions is set to reject new actions", count, s.limit.max)
+}
+
+//adds a sample to the shard of its action, evicting another action first when it is new and
+//the cap is reached. No shard may be locked. Returns the log the sample was written to
+func (s *Stats) addToShard(sample Sample) (*WAL, error) {
+	sh := s.shardFor(sample.Action)
+	for {
+		sh.mu.Lock()
+		err := s.addLocked(sh, sample)
+		wal := s.wal.log
+		sh.mu.Unlock()
+		if err != errNoRoom {
+			return wal, err
+		}
+		if err := s.makeRoom(); err != nil {
+			return wal, err
+		}
+	}
+}
+
+//adds a sample that was rejected by WithMaxActions to OverflowAction, no shard may be locked
+func (s *Stats) addOverflow(sample Sample) error {
+	s.publish(Event{Kind: EventRejected, Action: sample.Action})
+	sample.Action = OverflowAction
+	sh := s.shardFor(OverflowAction)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return s.addLocked(sh, sample)
+}
+
+//an action tracked for eviction
+type evictEntry struct {
+	action string
+	//samples of the action when it was last added to, the order of the lfu heap
+	numSamples uint64
+	//stamp of the last sample, the order of the lru list
+	used uint64
+	//position in the lfu heap
+	index int
+	//element of the lru list
+	elem *list.Element
+}
+
+//orders the actions of a shard for eviction
+type evictor interface {
+	add(entry *evictEntry)
+	touch(entry *evictEntry)
+	remove(entry *evictEntry)
+	//the entry to remove next, the shard is never empty when it is called
+	victim() *evictEntry
+}
+
+//least recently used, the most recently added to action is at the front of the list
+type lruEvictor struct {
+	order *list.List
+}
+
+func (e *lruEvictor) add(entry *evictEntry) {
+	entry.elem = e.order.PushFront(entry)
+}
+
+func (e *lruEvictor) touch(entry *evictEntry) {
+	e.order.MoveToFront(entry.elem)
+}
+
+func (e *lruEvictor) remove(entry *evictEntry) {
+	e.order.Remove(entry.elem)
+}
+
+func (e *lruEvictor) victim() *evictEntry {
+	return e.order.Back().Value.(*evictEntry)
+}
+
+//least frequently used, a min heap on the number of samples
+type lfuEvictor struct {
+	entries []*evictEntry
+}
+
+func (e *lfuEvictor) add(entry *evictEntry) {
+	heap.Push(e, entry)
+}
+
+func (e *lfuEvictor) touch(entry *evictEntry) {
+	heap.Fix(e, entry.index)
+}
+
+func (e *lfuEvictor) remove(entry *evictEntry) {
+	heap.Remove(e, entry.index)
+}
+
+func (e *lfuEvictor) victim() *evictEntry {
+	return e.entries[0]
+}
+
+//heap.Interface
+func (e *lfuEvictor) Len() int {
+	return len(e.entries)
+}
+
+//ties go to the least recently used, the same as across shards
+func (e *lfuEvictor) Less(i, j int) bool {
+	if e.entries[i].numSamples != e.entries[j].numSamples {
+		return e.entries[i].numSamples < e.entries[j].numSamples
+	}
+	return e.entries[i].used < e.entries[j].used
+}
+
+func (e *lfuEvictor) Swap(i, j int) {
+	e.entries[i], e.entries[j] = e.entries[j], e.entries[i]
+	e.entries[i].index = i
+	e.entries[j].index = j
+}
+
+func (e *lfuEvictor) Push(x interface{}) {
+	entry := x.(*evictEntry)
+	entry.index = len(e.entries)
+	e.entries = append(e.entries, entry)
+}
+
+func (e *lfuEvictor) Pop() interface{} {
+	last := e.entries[len(e.entries)-1]
+	e.entries[len(e.entries)-1] = nil
+	e.entries = e.entries[:len(e.entries)-1]
+	return last
+}
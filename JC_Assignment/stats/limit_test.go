@@ -0,0 +1,225 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//helper to add numActions unique actions with one sample each, like makeStatsWithUniqueActions
+func addUniqueActions(st *Stats, numActions int) {
+	for i := 0; i < numActions; i++ {
+		st.addAction(Sample{
+			Action: fmt.Sprintf("Action%d", i),
+			Time:   1,
+		})
+	}
+}
+
+//new actions past the cap go to the overflow action
+func TestMaxActions_RejectNew(t *testing.T) {
+	st := NewStats(WithShards(1), WithMaxActions(3, RejectNew))
+	for _, action := range []string{"jump", "run", "walk", "swim", "jump", "fly"} {
+		if err := st.AddAction("{\"action\":\"" + action + "\", \"time\":100}"); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	for _, action := range []string{"swim", "fly"} {
+		if _, ok := st.Lookup(action); ok {
+			t.Errorf("%s was kept past the cap", action)
+		}
+	}
+	if jump, _ := st.Lookup("jump"); jump.NumSamples != 2 {
+		t.Errorf("an existing action stopped taking samples %+v", jump)
+	}
+	if overflow, _ := st.Lookup(OverflowAction); overflow.NumSamples != 2 || overflow.TotalTime != 200 {
+		t.Errorf("wrong overflow totals %+v", overflow)
+	}
+	if have := st.Cardinality(); have != (CardinalityStats{Actions: 3, MaxActions: 3, Rejected: 2}) {
+		t.Errorf("wrong cardinality %+v", have)
+	}
+
+	report, _ := st.AddActions(`[{"action":"jump", "time":1}, {"action":"dive", "time":1}]`)
+	if report.Accepted != 2 || len(report.Rejected) != 0 {
+		t.Errorf("wrong batch report %+v", report)
+	}
+	if overflow, _ := st.Lookup(OverflowAction); overflow.NumSamples != 3 {
+		t.Errorf("batch sample did not go to the overflow action %+v", overflow)
+	}
+}
+
+//the least recently added to action makes room
+func TestMaxActions_EvictLRU(t *testing.T) {
+	st := NewStats(WithShards(1), WithMaxActions(2, EvictLRU))
+	for _, action := range []string{"jump", "run", "jump", "walk"} {
+		st.addAction(Sample{Action: action, Time: 1})
+	}
+	if _, ok := st.Lookup("run"); ok {
+		t.Error("run should have been evicted")
+	}
+	for _, action := range []string{"jump", "walk"} {
+		if _, ok := st.Lookup(action); !ok {
+			t.Errorf("%s was evicted", action)
+		}
+	}
+	//a removed action is no longer tracked
+	st.Remove("walk")
+	st.addAction(Sample{Action: "swim", Time: 1})
+	if have := st.Cardinality(); have != (CardinalityStats{Actions: 2, MaxActions: 2, Evicted: 1}) {
+		t.Errorf("wrong cardinality %+v", have)
+	}
+}
+
+//the action with the fewest samples makes room
+func TestMaxActions_EvictLeastFrequent(t *testing.T) {
+	st := NewStats(WithShards(1), WithMaxActions(2, EvictLeastFrequent))
+	for _, action := range []string{"jump", "jump", "jump", "run", "run", "walk", "swim"} {
+		st.addAction(Sample{Action: action, Time: 1})
+	}
+	if _, ok := st.Lookup("jump"); !ok {
+		t.Error("the most frequent action was evicted")
+	}
+	if have := st.Cardinality(); have.Actions != 2 || have.Evicted != 2 {
+		t.Errorf("wrong cardinality %+v", have)
+	}
+}
+
+//the cap covers every shard, so nothing is rejected or evicted until the total reaches it
+func TestMaxActions_AcrossShards(t *testing.T) {
+	rejecting := NewStats(WithMaxActions(100, RejectNew))
+	addUniqueActions(&rejecting, 100)
+	if have := rejecting.Cardinality(); have != (CardinalityStats{Actions: 100, MaxActions: 100}) {
+		t.Errorf("rejected below the cap %+v", have)
+	}
+	rejecting.addAction(Sample{Action: "jump", Time: 1})
+	if have := rejecting.Cardinality(); have != (CardinalityStats{Actions: 100, MaxActions: 100, Rejected: 1}) {
+		t.Errorf("wrong cardinality past the cap %+v", have)
+	}
+
+	for _, policy := range []EvictionPolicy{EvictLRU, EvictLeastFrequent} {
+		st := NewStats(WithMaxActions(1000, policy))
+		addUniqueActions(&st, 1000)
+		if have := st.Cardinality(); have != (CardinalityStats{Actions: 1000, MaxActions: 1000}) {
+			t.Errorf("policy %d evicted below the cap %+v", policy, have)
+		}
+		//the victim is picked across shards, Action0 is the oldest until it gets another sample
+		st.addAction(Sample{Action: "Action0", Time: 1})
+		st.addAction(Sample{Action: "jump", Time: 1})
+		if _, ok := st.Lookup("Action1"); ok {
+			t.Errorf("policy %d did not evict the least used action", policy)
+		}
+		if _, ok := st.Lookup("Action0"); !ok {
+			t.Errorf("policy %d evicted an action that is used more", policy)
+		}
+		if have := st.Cardinality(); have.Actions != 1000 || have.Evicted != 1 {
+			t.Errorf("policy %d wrong cardinality %+v", policy, have)
+		}
+	}
+}
+
+//concurrent adds of new actions never push the total past the cap
+func TestMaxActions_Concurrent(t *testing.T) {
+	for _, policy := range []EvictionPolicy{RejectNew, EvictLRU, EvictLeastFrequent} {
+		st := NewStats(WithMaxActions(500, policy))
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					st.addAction(Sample{Action: fmt.Sprintf("Action%d-%d", g, i), Time: 1})
+				}
+			}(g)
+		}
+		wg.Wait()
+		have := st.Cardinality()
+		if have.Actions != 500 || uint64(have.Actions)+have.Evicted+have.Rejected != 8000 {
+			t.Errorf("policy %d wrong cardinality %+v", policy, have)
+		}
+	}
+}
+
+//millions of unique names cannot grow the stats past the cap
+func TestMaxActions_MegaUnique(t *testing.T) {
+	if testing.Short() {
+		t.Skip("adds millions of actions")
+	}
+	numActions := 1000000
+	maxActions := 1000
+	for _, policy := range []EvictionPolicy{RejectNew, EvictLRU, EvictLeastFrequent} {
+		st := NewStats(WithMaxActions(maxActions, policy))
+		//a hot action added first must survive the flood unless it is the least recently used
+		for i := 0; i < 100; i++ {
+			st.addAction(Sample{Action: "hot", Time: 1})
+		}
+		addUniqueActions(&st, numActions)
+
+		have := st.Cardinality()
+		if have.Actions != maxActions {
+			t.Errorf("policy %d kept %d actions for a cap of %d", policy, have.Actions, maxActions)
+		}
+		if uint64(have.Actions)+have.Evicted+have.Rejected != uint64(numActions)+1 {
+			t.Errorf("policy %d lost track of actions %+v", policy, have)
+		}
+		_, hot := st.Lookup("hot")
+		if policy != EvictLRU && !hot {
+			t.Errorf("policy %d evicted the hot action", policy)
+		}
+		if policy == RejectNew {
+			overflow, _ := st.Lookup(OverflowAction)
+			if overflow.NumSamples != have.Rejected {
+				t.Errorf("overflow has %d samples for %d rejected", overflow.NumSamples, have.Rejected)
+			}
+		}
+	}
+}
+
+//restored and merged actions respect the cap
+func TestMaxActions_SetStateAndMerge(t *testing.T) {
+	full := NewStats(WithShards(1))
+	addUniqueActions(&full, 10)
+
+	rejecting := NewStats(WithShards(1), WithMaxActions(5, RejectNew))
+	if err := rejecting.SetState(full.State()); err == nil {
+		t.Error("restored more actions than the cap")
+	}
+	if err := rejecting.Merge(&full); err == nil {
+		t.Error("merged more actions than the cap")
+	}
+	if have := rejecting.Cardinality(); have.Actions != 0 {
+		t.Errorf("a failed restore changed the stats %+v", have)
+	}
+
+	evicting := NewStats(WithShards(1), WithMaxActions(5, EvictLRU))
+	if err := evicting.Merge(&full); err != nil {
+		t.Fatal(err.Error())
+	}
+	if have := evicting.Cardinality(); have.Actions != 5 || have.Evicted != 5 {
+		t.Errorf("wrong cardinality after merge %+v", have)
+	}
+	//eviction still works after the merge
+	evicting.addAction(Sample{Action: "jump", Time: 1})
+	if have := evicting.Cardinality(); have.Actions != 5 || have.Evicted != 6 {
+		t.Errorf("wrong cardinality after add %+v", have)
+	}
+}
+
+//the counters are exported as metrics
+func TestWriteOpenMetrics_MaxActions(t *testing.T) {
+	st := NewStats(WithShards(1), WithMaxActions(1, RejectNew))
+	addUniqueActions(&st, 3)
+	var buf bytes.Buffer
+	st.WriteOpenMetrics(&buf)
+	for _, line := range []string{
+		"stats_actions 1",
+		"stats_actions_evicted_total 0",
+		"stats_actions_rejected_total 2",
+		`stats_action_time_count{action="_overflow"} 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing line %s", line)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+//combines the state of the same action from two sets of samples.
+//Merging is commutative and associative, so partial states can be reduced in any order.
+//Like addAction, totals that grow past uint64 are kept with the accumulation strategy of o,
+//so with AccumulateExact a merge that would overflow is refused.
+//Moving averages are decayed to the later of the two with the half life of o
+func (a ActionState) merge(b ActionState, o options) (ActionState, error) {
+	if math.MaxUint64-a.NumSamples < b.NumSamples {
+		return ActionState{}, fmt.Errorf("merging %d samples will overflow unint64 with current sample count %d", b.NumSamples, a.NumSamples)
+	}
+	if a.NumSamples == 0 {
+		return b, nil
+	}
+	if b.NumSamples == 0 {
+		return a, nil
+	}
+	//times with a unit are in nanoseconds, times without one cannot be converted
+	if (a.Unit == "") != (b.Unit == "") {
+		return ActionState{}, fmt.Errorf("%w-> one side has samples without a unit", ErrUnitMismatch)
+	}
+
+	merged := ActionState{
+		NumSamples: a.NumSamples + b.NumSamples,
+		Min:        a.Min,
+		Max:        a.Max,
+		Unit:       a.Unit,
+	}
+	//the finer of two units is shown, so the order of a merge does not matter
+	if merged.Unit == "" || (b.Unit != "" && unitNanos(b.Unit) < unitNanos(merged.Unit)) {
+		merged.Unit = b.Unit
+	}
+	if b.Min < merged.Min {
+		merged.Min = b.Min
+	}
+	if b.Max > merged.Max {
+		merged.Max = b.Max
+	}
+
+	//parallel form of Welford's algorithm (Chan et al.), written symmetrically so a+b == b+a
+	na, nb, n := float64(a.NumSamples), float64(b.NumSamples), float64(merged.NumSamples)
+	delta := b.Mean - a.Mean
+	merged.Mean = (na*a.Mean + nb*b.Mean) / n
+	merged.M2 = a.M2 + b.M2 + delta*delta*na*nb/n
+
+	merged.Histogram = mergeHistograms(a.Histogram, b.Histogram)
+	merged.Window = mergeWindows(a.Window, b.Window)
+	merged.Labels = mergeLabels(a, b)
+	merged.EWMA = mergeEWMA(a.EWMA, b.EWMA, o.ewmaHalfLife)
+	//last, a rescale halves the counts merged above
+	if err := mergeTotals(a, b, &merged, o.accumulation); err != nil {
+		return ActionState{}, err
+	}
+	return merged, nil
+}
+
+//adds two moving averages, a side without one is left out
+func mergeEWMA(a *EWMAState, b *EWMAState, halfLife time.Duration) *EWMAState {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	merged := ewma{sum: a.Sum, weight: a.Weight, at: a.At}.merge(ewma{sum: b.Sum, weight: b.Weight, at: b.At}, halfLife)
+	return &EWMAState{Sum: merged.sum, Weight: merged.weight, At: merged.at}
+}
+
+//adds the label totals of two states of the same action.
+//A label only one side has gets the other side's samples under the empty value, as addAction would
+//label totals are a split of the action totals, they stop at math.MaxUint64 like the window totals
+func mergeLabels(a ActionState, b ActionState) map[string]map[string]LabelState {
+	if len(a.Labels) == 0 && len(b.Labels) == 0 {
+		return nil
+	}
+	merged := make(map[string]map[string]LabelState)
+	for _, side := range []struct {
+		state ActionState
+		other ActionState
+	}{{a, b}, {b, a}} {
+		for name, values := range side.state.Labels {
+			if merged[name] == nil {
+				merged[name] = make(map[string]LabelState)
+			}
+			for value, totals := range values {
+				addLabelState(merged[name], value, totals)
+			}
+			if _, ok := side.other.Labels[name]; !ok {
+				addLabelState(merged[name], "", LabelState{NumSamples: side.other.NumSamples, TotalTime: side.other.TotalTime})
+			}
+		}
+	}
+	return merged
+}
+
+func addLabelState(values map[string]LabelState, value string, totals LabelState) {
+	if totals.NumSamples == 0 {
+		return
+	}
+	existing := values[value]
+	values[value] = LabelState{
+		NumSamples: existing.NumSamples + totals.NumSamples,
+		TotalTime:  addSaturating(existing.TotalTime, totals.TotalTime),
+	}
+}
+
+//adds the counts of two sorted lists of [index, count] buckets
+func mergeHistograms(a [][2]uint64, b [][2]uint64) [][2]uint64 {
+	merged := make([][2]uint64, 0, len(a)+len(b))
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0][0] < b[0][0]):
+			merged = append(merged, a[0])
+			a = a[1:]
+		case len(a) == 0 || b[0][0] < a[0][0]:
+			merged = append(merged, b[0])
+			b = b[1:]
+		default:
+			merged = append(merged, [2]uint64{a[0][0], a[0][1] + b[0][1]})
+			a, b = a[1:], b[1:]
+		}
+	}
+	return merged
+}
+
+//adds the totals of window buckets with the same epoch, sorted by epoch
+func mergeWindows(a []WindowState, b []WindowState) []WindowState {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	byEpoch := make(map[int64]WindowState)
+	for _, list := range [][]WindowState{a, b} {
+		for _, w := range list {
+			existing := byEpoch[w.Epoch]
+			byEpoch[w.Epoch] = WindowState{
+				Epoch:      w.Epoch,
+				NumSamples: existing.NumSamples + w.NumSamples,
+				TotalTime:  addSaturating(existing.TotalTime, w.TotalTime),
+			}
+		}
+	}
+	merged := make([]WindowState, 0, len(byEpoch))
+	for _, w := range byEpoch {
+		merged = append(merged, w)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Epoch < merged[j].Epoch
+	})
+	return merged
+}
+
+//combines two partial states into one, as if all of their samples had been added to a single stats struct.
+//Merging is commutative and associative. Neither state is changed, and nothing is returned on error
+func (st State) Merge(other State) (State, error) {
+	if !supportedVersion(st.Version) || !supportedVersion(other.Version) {
+		return State{}, fmt.Errorf("cannot merge snapshot versions %d and %d", st.Version, other.Version)
+	}
+	if st.WindowResolution != 0 && other.WindowResolution != 0 && st.WindowResolution != other.WindowResolution {
+		return State{}, fmt.Errorf("cannot merge windows with resolutions %s and %s", st.WindowResolution, other.WindowResolution)
+	}
+	if st.EWMAHalfLife != 0 && other.EWMAHalfLife != 0 && st.EWMAHalfLife != other.EWMAHalfLife {
+		return State{}, fmt.Errorf("cannot merge moving averages with half lives %s and %s", st.EWMAHalfLife, other.EWMAHalfLife)
+	}
+	if st.Accumulation != AccumulateExact && other.Accumulation != AccumulateExact && st.Accumulation != other.Accumulation {
+		return State{}, fmt.Errorf("cannot merge totals kept with accumulations %d and %d", st.Accumulation, other.Accumulation)
+	}
+	st, err := st.upgrade()
+	if err != nil {
+		return State{}, err
+	}
+	other, err = other.upgrade()
+	if err != nil {
+		return State{}, err
+	}
+	merged := State{
+		Version:          snapshotVersion,
+		WindowResolution: st.WindowResolution,
+		EWMAHalfLife:     st.EWMAHalfLife,
+		Accumulation:     st.Accumulation,
+		Averages:         make(map[string]ActionState, len(st.Averages)),
+	}
+	if merged.Accumulation == AccumulateExact {
+		merged.Accumulation = other.Accumulation
+	}
+	if merged.WindowResolution == 0 {
+		merged.WindowResolution = other.WindowResolution
+	}
+	if merged.EWMAHalfLife == 0 {
+		merged.EWMAHalfLife = other.EWMAHalfLife
+	}
+	for action, a := range st.Averages {
+		merged.Averages[action] = a
+	}
+	for action, b := range other.Averages {
+		m, err := merged.Averages[action].merge(b, options{ewmaHalfLife: merged.EWMAHalfLife, accumulation: merged.Accumulation})
+		if err != nil {
+			return State{}, fmt.Errorf("cannot merge %s-> %w", action, err)
+		}
+		merged.Averages[action] = m
+	}
+	return merged, nil
+}
+
+//adds the samples in a partial state to the stats.
+//Either every action is merged or, on error, none are, unless WithStorage fails part way through writing them.
+//Windows are only merged when they were counted at the same resolution,
+//and moving averages when they decayed with the same half life
+func (s *Stats) MergeState(st State) error {
+	if !supportedVersion(st.Version) {
+		return fmt.Errorf("unsupported snapshot version %d", st.Version)
+	}
+	if err := s.canAccumulate(st.Accumulation); err != nil {
+		return err
+	}
+	st, err := st.upgrade()
+	if err != nil {
+		return err
+	}
+	s.lockAll()
+	defer s.unlockAll()
+
+	//build every merged action before changing any so an error leaves the stats untouched
+	merged := make(map[string]*Average, len(st.Averages))
+	for action, in := range st.Averages {
+		if !s.windowed() || st.WindowResolution != s.windowResolution {
+			in.Window = nil
+		}
+		if !s.ewmaEnabled() || st.EWMAHalfLife != s.ewmaHalfLife {
+			in.EWMA = nil
+		}
+		combined := in
+		existing, err := s.shardFor(action).store.Get(action)
+		if err != nil {
+			return fmt.Errorf("cannot merge %s-> %s", action, err.Error())
+		}
+		if existing != nil {
+			combined, err = existing.state().merge(in, s.options)
+			if err != nil {
+				return fmt.Errorf("cannot merge %s-> %w", action, err)
+			}
+		}
+		average, err := s.averageFromState(combined, s.windowResolution, s.ewmaHalfLife)
+		if err != nil {
+			return fmt.Errorf("cannot merge %s-> %s", action, err.Error())
+		}
+		merged[action] = average
+	}
+	//new actions must fit under WithMaxActions when they would be rejected
+	count := 0
+	for _, sh := range s.shards {
+		count += sh.numActions()
+	}
+	for action := range merged {
+		existing, err := s.shardFor(action).store.Get(action)
+		if err != nil {
+			return fmt.Errorf("cannot merge %s-> %s", action, err.Error())
+		}
+		if existing == nil && action != OverflowAction {
+			count++
+		}
+	}
+	if err := s.fits(count); err != nil {
+		return fmt.Errorf("cannot merge-> %s", err.Error())
+	}
+	for action, average := range merged {
+		sh := s.shardFor(action)
+		if err := sh.store.Put(action, average); err != nil {
+			return fmt.Errorf("cannot merge %s-> %s", action, err.Error())
+		}
+		sh.markPopulated()
+	}
+	//merged averages replace the tracked ones, so the eviction order starts over
+	if len(merged) > 0 {
+		return s.retrack()
+	}
+	return nil
+}
+
+//adds all of the samples from other into the stats, other is not changed
+func (s *Stats) Merge(other *Stats) error {
+	st, err := other.readState()
+	if err != nil {
+		return err
+	}
+	return s.MergeState(st)
+}
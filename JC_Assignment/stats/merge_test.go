@@ -0,0 +1,175 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//helper to make stats from a run of samples, spread over a few actions
+func makeMergeStats(from int, to int) *Stats {
+	st := NewStats()
+	for i := from; i < to; i++ {
+		st.addAction(Sample{Action: fmt.Sprintf("Action%d", i%3), Time: uint64(i * i)})
+	}
+	return &st
+}
+
+//helper to compare states, the running variance is floating point so it only has to be close
+func assertStatesMatch(t *testing.T, have State, want State) {
+	t.Helper()
+	if len(have.Averages) != len(want.Averages) {
+		t.Fatalf("have %d actions, want %d", len(have.Averages), len(want.Averages))
+	}
+	for action, w := range want.Averages {
+		h := have.Averages[action]
+		if math.Abs(h.Mean-w.Mean) > 1e-9*math.Abs(w.Mean) || math.Abs(h.M2-w.M2) > 1e-9*math.Abs(w.M2) {
+			t.Errorf("%s has mean %f and m2 %f, want %f and %f", action, h.Mean, h.M2, w.Mean, w.M2)
+		}
+		h.Mean, h.M2, w.Mean, w.M2 = 0, 0, 0, 0
+		if !reflect.DeepEqual(h, w) {
+			t.Errorf("%s differs\nhave %+v\nwant %+v", action, h, w)
+		}
+	}
+}
+
+//merging stats gives the same result as adding every sample to one stats struct
+func TestMerge(t *testing.T) {
+	all := makeMergeStats(0, 300)
+	st := makeMergeStats(0, 100)
+	if err := st.Merge(makeMergeStats(100, 250)); err != nil {
+		t.Fatalf("error from merge %s", err.Error())
+	}
+	if err := st.Merge(makeMergeStats(250, 300)); err != nil {
+		t.Fatalf("error from merge %s", err.Error())
+	}
+	assertStatesMatch(t, st.State(), all.State())
+}
+
+//sliding windows at the same resolution are merged bucket by bucket
+func TestMerge_Window(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	st := NewStats(WithWindow(time.Minute, time.Second), WithClock(clock))
+	other := NewStats(WithWindow(time.Minute, time.Second), WithClock(clock))
+	st.addAction(Sample{Action: "jump", Time: 100})
+	other.addAction(Sample{Action: "jump", Time: 300})
+	clock.Advance(30 * time.Second)
+	other.addAction(Sample{Action: "jump", Time: 900})
+
+	if err := st.Merge(&other); err != nil {
+		t.Fatal(err.Error())
+	}
+	if have := windowAverages(t, &st, time.Minute); have["jump"] != 433 {
+		t.Errorf("wrong merged 1m window %v", have)
+	}
+	if have := windowAverages(t, &st, time.Second); have["jump"] != 900 {
+		t.Errorf("wrong merged 1s window %v", have)
+	}
+}
+
+//partial states can be reduced in any order
+func TestStateMerge_CommutativeAssociative(t *testing.T) {
+	a := makeMergeStats(0, 100).State()
+	b := makeMergeStats(100, 170).State()
+	c := makeMergeStats(170, 300).State()
+	//c also has an action the others do not
+	extra := NewStats()
+	extra.addAction(Sample{Action: "run", Time: 75})
+	c, _ = c.Merge(extra.State())
+
+	ab, _ := a.Merge(b)
+	ba, _ := b.Merge(a)
+	if !reflect.DeepEqual(ab, ba) {
+		t.Fatal("merge is not commutative")
+	}
+
+	abC, _ := ab.Merge(c)
+	bc, _ := b.Merge(c)
+	aBC, err := a.Merge(bc)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assertStatesMatch(t, abC, aBC)
+
+	all := makeMergeStats(0, 300)
+	all.addAction(Sample{Action: "run", Time: 75})
+	assertStatesMatch(t, abC, all.State())
+}
+
+//partial states travel between processes as json
+func TestStateMerge_Serialized(t *testing.T) {
+	partial, err := json.Marshal(makeMergeStats(100, 200).State())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var received State
+	if err := json.Unmarshal(partial, &received); err != nil {
+		t.Fatal(err.Error())
+	}
+	st := makeMergeStats(0, 100)
+	if err := st.MergeState(received); err != nil {
+		t.Fatal(err.Error())
+	}
+	assertStatesMatch(t, st.State(), makeMergeStats(0, 200).State())
+}
+
+//the uint64 overflow check from addAction applies to merged totals, and a failed merge changes nothing
+func TestMerge_IntOverflow(t *testing.T) {
+	st := NewStats()
+	st.addAction(Sample{Action: "jump", Time: math.MaxUint64})
+	other := NewStats()
+	other.addAction(Sample{Action: "run", Time: 75})
+	other.addAction(Sample{Action: "jump", Time: 1})
+
+	if err := st.Merge(&other); err == nil {
+		t.Fatal("TotalTime for jump exceeded maxuint64")
+	}
+	if _, ok := st.Lookup("run"); ok {
+		t.Fatal("a failed merge added run")
+	}
+	jump, _ := st.Lookup("jump")
+	if jump.NumSamples != 1 || jump.TotalTime != math.MaxUint64 {
+		t.Fatalf("a failed merge changed jump %+v", jump)
+	}
+
+	if _, err := st.State().Merge(other.State()); err == nil {
+		t.Fatal("state merge exceeded maxuint64")
+	}
+
+	//the other accumulation strategies merge past uint64 and keep the average
+	for _, accumulation := range []Accumulation{AccumulateBig, AccumulateKahan, AccumulateRescale} {
+		st := NewStats(WithAccumulation(accumulation))
+		st.addAction(Sample{Action: "jump", Time: math.MaxUint64 - 1})
+		other := NewStats(WithAccumulation(accumulation))
+		other.addAction(Sample{Action: "jump", Time: math.MaxUint64 - 3})
+		other.addAction(Sample{Action: "jump", Time: math.MaxUint64 - 5})
+		if err := st.Merge(&other); err != nil {
+			t.Fatalf("accumulation %d refused a merge %s", accumulation, err.Error())
+		}
+		jump, _ := st.Lookup("jump")
+		if have := jump.AverageTime(); have < math.MaxUint64-4096 || have > math.MaxUint64-1 {
+			t.Errorf("accumulation %d has average %d after merge", accumulation, have)
+		}
+		if accumulation == AccumulateBig && jump.AverageTime() != math.MaxUint64-3 {
+			t.Errorf("big totals should be exact, have %d", jump.AverageTime())
+		}
+	}
+}
+
+//windows at different resolutions cannot be combined
+func TestStateMerge_WindowResolution(t *testing.T) {
+	a := State{Version: snapshotVersion, WindowResolution: 1}
+	b := State{Version: snapshotVersion, WindowResolution: 2}
+	if _, err := a.Merge(b); err == nil {
+		t.Fatal("merged windows with different resolutions")
+	}
+	if _, err := a.Merge(State{Version: snapshotVersion}); err != nil {
+		t.Fatalf("a state without windows should merge %s", err.Error())
+	}
+	if _, err := a.Merge(State{Version: snapshotVersion + 1}); err == nil {
+		t.Fatal("merged an unsupported version")
+	}
+}
@@ -0,0 +1,150 @@
+package stats
+
+import (
+	"bufio"
+	"io"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//content types for the two exposition formats, the body is valid for both
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+//what is needed from one action to write its metrics, copied so nothing is written under the shard locks
+type actionMetrics struct {
+	action     string
+	numSamples uint64
+	average    uint64
+	totalTime  string
+	max        uint64
+	buckets    []histBucket
+	//moving average, nil when moving averages are not enabled
+	ewma *float64
+}
+
+//escapes a label value for the exposition format, which only escapes backslash, double quote and newline.
+//Label values must also be valid utf-8
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelEscaper.Replace(strings.ToValidUTF8(v, "�"))
+}
+
+//writes the count, sum, average and histogram buckets of every action in the
+//Prometheus text exposition format. The output ends with # EOF so it is also valid OpenMetrics.
+//
+//Histogram buckets are powers of two, le="0", "1", "3", "7" ... 2^k-1, up to the first bucket
+//that holds the largest sample of the action, followed by +Inf. Sub buckets of the
+//distribution sketch never cross a power of two so the cumulative counts are exact.
+//Times of actions with a unit are written in nanoseconds, so their buckets line up whatever unit was declared.
+//With WithEWMA the moving averages are written as the stats_action_time_ewma gauge,
+//and with WithMaxActions the number of actions kept, evicted and rejected are written too
+func (s *Stats) WriteOpenMetrics(w io.Writer) error {
+	metrics := make([]actionMetrics, 0)
+	err := s.rangeAverages(func(action string, average *Average) {
+		m := actionMetrics{
+			action:     action,
+			numSamples: average.NumSamples,
+			average:    average.AverageTime(),
+			totalTime:  average.totalString(),
+			max:        average.Max,
+			buckets:    append([]histBucket(nil), average.hist.buckets...),
+		}
+		if average.ewma != nil {
+			v := average.ewma.value()
+			m.ewma = &v
+		}
+		metrics = append(metrics, m)
+	})
+	if err != nil {
+		return err
+	}
+	//stable output makes scrapes easy to diff
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].action < metrics[j].action
+	})
+
+	var cardinality CardinalityStats
+	if s.maxActions > 0 {
+		cardinality = s.Cardinality()
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# HELP stats_action_time Time of the samples added for each action.\n")
+	bw.WriteString("# TYPE stats_action_time histogram\n")
+	for _, m := range metrics {
+		label := `action="` + escapeLabelValue(m.action) + `"`
+		writeHistogramBuckets(bw, label, m)
+		bw.WriteString("stats_action_time_bucket{" + label + `,le="+Inf"} ` + strconv.FormatUint(m.numSamples, 10) + "\n")
+		bw.WriteString("stats_action_time_sum{" + label + "} " + m.totalTime + "\n")
+		bw.WriteString("stats_action_time_count{" + label + "} " + strconv.FormatUint(m.numSamples, 10) + "\n")
+	}
+	bw.WriteString("# HELP stats_action_time_average Average time of the samples added for each action.\n")
+	bw.WriteString("# TYPE stats_action_time_average gauge\n")
+	for _, m := range metrics {
+		bw.WriteString(`stats_action_time_average{action="` + escapeLabelValue(m.action) + `"} ` + strconv.FormatUint(m.average, 10) + "\n")
+	}
+	if s.ewmaEnabled() {
+		bw.WriteString("# HELP stats_action_time_ewma Moving average of the time of the samples added for each action.\n")
+		bw.WriteString("# TYPE stats_action_time_ewma gauge\n")
+		for _, m := range metrics {
+			if m.ewma != nil {
+				bw.WriteString(`stats_action_time_ewma{action="` + escapeLabelValue(m.action) + `"} ` + strconv.FormatFloat(*m.ewma, 'g', -1, 64) + "\n")
+			}
+		}
+	}
+	if s.maxActions > 0 {
+		bw.WriteString("# HELP stats_actions Distinct actions kept, limited by WithMaxActions.\n")
+		bw.WriteString("# TYPE stats_actions gauge\n")
+		bw.WriteString("stats_actions " + strconv.Itoa(cardinality.Actions) + "\n")
+		bw.WriteString("# HELP stats_actions_evicted Actions removed to make room for new actions.\n")
+		bw.WriteString("# TYPE stats_actions_evicted counter\n")
+		bw.WriteString("stats_actions_evicted_total " + strconv.FormatUint(cardinality.Evicted, 10) + "\n")
+		bw.WriteString("# HELP stats_actions_rejected Samples for new actions added to the overflow action instead.\n")
+		bw.WriteString("# TYPE stats_actions_rejected counter\n")
+		bw.WriteString("stats_actions_rejected_total " + strconv.FormatUint(cardinality.Rejected, 10) + "\n")
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+//writes the cumulative power of two buckets of one action
+func writeHistogramBuckets(bw *bufio.Writer, label string, m actionMetrics) {
+	//bucket le=2^k-1 counts every sample with fewer than k bits
+	maxBits := bits.Len64(m.max)
+	var cumulative uint64
+	next := 0
+	for k := 0; k <= maxBits; k++ {
+		for next < len(m.buckets) {
+			_, upper := histBounds(m.buckets[next].index)
+			if bits.Len64(upper) > k {
+				break
+			}
+			cumulative += m.buckets[next].count
+			next++
+		}
+		le := uint64(1)<<uint(k) - 1
+		if k == 64 {
+			le = ^uint64(0)
+		}
+		bw.WriteString("stats_action_time_bucket{" + label + `,le="` + strconv.FormatUint(le, 10) + `"} ` + strconv.FormatUint(cumulative, 10) + "\n")
+	}
+}
+
+//serves the metrics of every action, for a Prometheus scrape config
+func (s *Stats) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+			w.Header().Set("Content-Type", openMetricsContentType)
+		} else {
+			w.Header().Set("Content-Type", prometheusContentType)
+		}
+		s.WriteOpenMetrics(w)
+	})
+}
@@ -0,0 +1,104 @@
+package stats
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//test the exposition of the hello world actions
+func TestWriteOpenMetrics(t *testing.T) {
+	st := NewStats()
+	st.AddAction("{\"action\":\"jump\", \"time\":100}")
+	st.AddAction("{\"action\":\"run\", \"time\":0}")
+	st.AddAction("{\"action\":\"jump\", \"time\":200}")
+
+	var buf bytes.Buffer
+	if err := st.WriteOpenMetrics(&buf); err != nil {
+		t.Fatal(err.Error())
+	}
+	want := `# HELP stats_action_time Time of the samples added for each action.
+# TYPE stats_action_time histogram
+stats_action_time_bucket{action="jump",le="0"} 0
+stats_action_time_bucket{action="jump",le="1"} 0
+stats_action_time_bucket{action="jump",le="3"} 0
+stats_action_time_bucket{action="jump",le="7"} 0
+stats_action_time_bucket{action="jump",le="15"} 0
+stats_action_time_bucket{action="jump",le="31"} 0
+stats_action_time_bucket{action="jump",le="63"} 0
+stats_action_time_bucket{action="jump",le="127"} 1
+stats_action_time_bucket{action="jump",le="255"} 2
+stats_action_time_bucket{action="jump",le="+Inf"} 2
+stats_action_time_sum{action="jump"} 300
+stats_action_time_count{action="jump"} 2
+stats_action_time_bucket{action="run",le="0"} 1
+stats_action_time_bucket{action="run",le="+Inf"} 1
+stats_action_time_sum{action="run"} 0
+stats_action_time_count{action="run"} 1
+# HELP stats_action_time_average Average time of the samples added for each action.
+# TYPE stats_action_time_average gauge
+stats_action_time_average{action="jump"} 150
+stats_action_time_average{action="run"} 0
+# EOF
+`
+	if buf.String() != want {
+		t.Fatalf("wrong exposition\nhave:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+//the largest times get the full set of buckets and exact sums
+func TestWriteOpenMetrics_MaxUint64(t *testing.T) {
+	st := NewStats()
+	st.addAction(Sample{Action: "jump", Time: 18446744073709551615})
+
+	var buf bytes.Buffer
+	st.WriteOpenMetrics(&buf)
+	for _, line := range []string{
+		`stats_action_time_bucket{action="jump",le="9223372036854775807"} 0`,
+		`stats_action_time_bucket{action="jump",le="18446744073709551615"} 1`,
+		`stats_action_time_sum{action="jump"} 18446744073709551615`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing line %s", line)
+		}
+	}
+}
+
+//action names can hold anything, label values must be escaped
+func TestWriteOpenMetrics_Escaping(t *testing.T) {
+	st := NewStats()
+	st.addAction(Sample{Action: "say \"hi\"\\\nbye\xff", Time: 1})
+
+	var buf bytes.Buffer
+	st.WriteOpenMetrics(&buf)
+	want := `stats_action_time_average{action="say \"hi\"\\\nbye�"} 1`
+	if !strings.Contains(buf.String(), want+"\n") {
+		t.Fatalf("label was not escaped\n%s", buf.String())
+	}
+}
+
+//the handler picks the content type from the accept header
+func TestMetricsHandler(t *testing.T) {
+	st := NewStats()
+	st.addAction(Sample{Action: "jump", Time: 1})
+	handler := st.MetricsHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Header().Get("Content-Type") != prometheusContentType {
+		t.Errorf("wrong content type %s", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), `stats_action_time_count{action="jump"} 1`) {
+		t.Errorf("missing metrics\n%s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0,text/plain;q=0.5")
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Type") != openMetricsContentType {
+		t.Errorf("wrong content type %s", rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"time"
+)
+
+//source of the current time, can be swapped out in tests to make windows deterministic
+type Clock interface {
+	Now() time.Time
+}
+
+//default clock backed by time.Now
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+//optional behavior of a Stats struct, set once by NewStats
+type options struct {
+	//sliding window settings, disabled when zero
+	windowSpan       time.Duration
+	windowResolution time.Duration
+	clock            Clock
+	//half life of the moving averages, disabled when zero
+	ewmaHalfLife time.Duration
+	//number of independently locked shards
+	numShards int
+	//how totals are kept once they no longer fit in a uint64
+	accumulation Accumulation
+	//cap on distinct actions, no cap when zero
+	maxActions     int
+	evictionPolicy EvictionPolicy
+	//encoding written by Snapshot
+	snapshotFormat SnapshotFormat
+	//rules incoming samples are checked against
+	validation validation
+	//where the averages are kept, in memory when nil
+	storage Storage
+}
+
+//configures optional behavior of a new Stats struct
+type Option func(*options)
+
+//enables sliding window averages covering up to span, kept in buckets of resolution.
+//Each action keeps span/resolution buckets so memory per action is fixed. A resolution that
+//would need more than maxWindowBuckets buckets is raised until they fit
+//e.g. WithWindow(15*time.Minute, 10*time.Second) supports GetStatsWindow for 1m, 5m and 15m
+func WithWindow(span time.Duration, resolution time.Duration) Option {
+	return func(o *options) {
+		if span > 0 && resolution > 0 && (span-1)/resolution >= maxWindowBuckets {
+			resolution = (span + maxWindowBuckets - 1) / maxWindowBuckets
+		}
+		o.windowSpan = span
+		o.windowResolution = resolution
+	}
+}
+
+//replaces the clock used to place samples into window buckets
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+//sets the number of independently locked shards the actions are spread across.
+//More shards let more goroutines add different actions at the same time
+func WithShards(numShards int) Option {
+	return func(o *options) {
+		if numShards > 0 {
+			o.numShards = numShards
+		}
+	}
+}
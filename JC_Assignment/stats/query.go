@@ -0,0 +1,173 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//field GetStats can sort by
+type SortField int
+
+const (
+	//action name, then label value when grouping. The default
+	SortByName SortField = iota
+	//average time
+	SortByAverage
+	//number of samples
+	SortByCount
+)
+
+//direction of a sort
+type SortOrder int
+
+const (
+	Ascending SortOrder = iota
+	Descending
+)
+
+//options for reading stats back out
+type query struct {
+	//label name to group each action by, empty for no grouping
+	groupBy string
+	sortBy  SortField
+	order   SortOrder
+	//rows skipped and the most rows returned after sorting, 0 for no limit
+	offset int
+	limit  int
+	//only actions starting with prefix and matching match are returned
+	prefix string
+	match  *regexp.Regexp
+	//unit set by InUnit, empty for the unit of each action
+	unit string
+	//whether to add duration strings, set by AsDuration
+	duration bool
+	//dotted name parts actions are rolled up by, 0 for no rollups
+	depth int
+}
+
+//changes what GetStats returns
+type QueryOption func(*query)
+
+func newQuery(opts []QueryOption) query {
+	var q query
+	for _, opt := range opts {
+		opt(&q)
+	}
+	return q
+}
+
+//splits every action by the value of a label, e.g. GroupBy("host").
+//Samples without the label are grouped under an empty value
+func GroupBy(label string) QueryOption {
+	return func(q *query) {
+		q.groupBy = label
+	}
+}
+
+//sorts the output, ties are broken by name, label and unit so the order is always the same.
+//Without SortBy the output is sorted by name, ascending
+func SortBy(field SortField, order SortOrder) QueryOption {
+	return func(q *query) {
+		q.sortBy = field
+		q.order = order
+	}
+}
+
+//returns at most limit rows, applied after sorting
+func Limit(limit int) QueryOption {
+	return func(q *query) {
+		q.limit = limit
+	}
+}
+
+//skips the first offset rows, applied after sorting
+func Offset(offset int) QueryOption {
+	return func(q *query) {
+		q.offset = offset
+	}
+}
+
+//only returns actions whose name starts with prefix
+func Prefix(prefix string) QueryOption {
+	return func(q *query) {
+		q.prefix = prefix
+	}
+}
+
+//only returns actions whose name matches re, e.g. Match(regexp.MustCompile(`^api\.`))
+func Match(re *regexp.Regexp) QueryOption {
+	return func(q *query) {
+		q.match = re
+	}
+}
+
+//checks the options that can be out of range
+func (q *query) validate() error {
+	if !validUnit(q.unit) {
+		return fmt.Errorf("%w-> %q", ErrInvalidUnit, q.unit)
+	}
+	if q.depth < 0 {
+		return errors.New("rollup depth must not be negative")
+	}
+	return nil
+}
+
+//one row of GetStats output along with what it is sorted by
+type statsRow struct {
+	average SampleAverage
+	count   uint64
+	//exact average in nanoseconds, so actions shown in different units sort together
+	nanos float64
+}
+
+//whether the action passes the prefix and regex filters
+func (q *query) matches(action string) bool {
+	if !strings.HasPrefix(action, q.prefix) {
+		return false
+	}
+	return q.match == nil || q.match.MatchString(action)
+}
+
+//sorts the rows and returns the requested page
+func (q *query) apply(rows []statsRow) []SampleAverage {
+	//the rows are sorted by index so the sort only moves ints
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := &rows[order[i]], &rows[order[j]]
+		if q.order == Descending {
+			a, b = b, a
+		}
+		switch {
+		case q.sortBy == SortByAverage && a.nanos != b.nanos:
+			return a.nanos < b.nanos
+		case q.sortBy == SortByCount && a.count != b.count:
+			return a.count < b.count
+		case a.average.Action != b.average.Action:
+			return a.average.Action < b.average.Action
+		case a.average.Labels[q.groupBy] != b.average.Labels[q.groupBy]:
+			return a.average.Labels[q.groupBy] < b.average.Labels[q.groupBy]
+		}
+		//a rollup of actions without a unit shares its name with the rollup of the actions with one
+		return a.average.Unit < b.average.Unit
+	})
+
+	if q.offset > len(order) {
+		order = order[:0]
+	} else if q.offset > 0 {
+		order = order[q.offset:]
+	}
+	if q.limit > 0 && q.limit < len(order) {
+		order = order[:q.limit]
+	}
+	averages := make([]SampleAverage, len(order))
+	for i, row := range order {
+		averages[i] = rows[row].average
+	}
+	return averages
+}
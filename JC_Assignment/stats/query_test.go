@@ -0,0 +1,102 @@
+package stats
+
+import (
+	"regexp"
+	"testing"
+)
+
+//helper to make stats where every action has a different average and count
+func makeQueryStats() *Stats {
+	st := NewStats()
+	st.addAction(Sample{Action: "run", Time: 75})
+	st.addAction(Sample{Action: "jump", Time: 100})
+	st.addAction(Sample{Action: "jump", Time: 200})
+	st.addAction(Sample{Action: "api.get", Time: 10})
+	st.addAction(Sample{Action: "api.get", Time: 20})
+	st.addAction(Sample{Action: "api.get", Time: 30})
+	st.addAction(Sample{Action: "api.put", Time: 500})
+	return &st
+}
+
+//the default order is by name so the json is the same every time
+func TestGetStats_StableOrder(t *testing.T) {
+	st := makeQueryStats()
+	want := `[{"action":"api.get","avg":20},{"action":"api.put","avg":500},{"action":"jump","avg":150},{"action":"run","avg":75}]`
+	for i := 0; i < 10; i++ {
+		statsJson, err := st.GetStats()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if statsJson != want {
+			t.Fatalf("wrong order\nhave %s\nwant %s", statsJson, want)
+		}
+	}
+}
+
+//each option changes the rows returned
+func TestGetStats_Query(t *testing.T) {
+	st := makeQueryStats()
+	tests := []struct {
+		name string
+		opts []QueryOption
+		want string
+	}{
+		{"name descending", []QueryOption{SortBy(SortByName, Descending)},
+			`[{"action":"run","avg":75},{"action":"jump","avg":150},{"action":"api.put","avg":500},{"action":"api.get","avg":20}]`},
+		{"average", []QueryOption{SortBy(SortByAverage, Ascending)},
+			`[{"action":"api.get","avg":20},{"action":"run","avg":75},{"action":"jump","avg":150},{"action":"api.put","avg":500}]`},
+		{"count descending ties by name", []QueryOption{SortBy(SortByCount, Descending)},
+			`[{"action":"api.get","avg":20},{"action":"jump","avg":150},{"action":"run","avg":75},{"action":"api.put","avg":500}]`},
+		{"limit", []QueryOption{SortBy(SortByAverage, Descending), Limit(2)},
+			`[{"action":"api.put","avg":500},{"action":"jump","avg":150}]`},
+		{"offset", []QueryOption{Offset(1), Limit(2)},
+			`[{"action":"api.put","avg":500},{"action":"jump","avg":150}]`},
+		{"offset past the end", []QueryOption{Offset(10)}, `[]`},
+		{"prefix", []QueryOption{Prefix("api.")},
+			`[{"action":"api.get","avg":20},{"action":"api.put","avg":500}]`},
+		{"regex", []QueryOption{Match(regexp.MustCompile(`^(run|jump)$`))},
+			`[{"action":"jump","avg":150},{"action":"run","avg":75}]`},
+		{"prefix and regex", []QueryOption{Prefix("api."), Match(regexp.MustCompile(`put`))},
+			`[{"action":"api.put","avg":500}]`},
+	}
+	for _, test := range tests {
+		statsJson, err := st.GetStats(test.opts...)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if statsJson != test.want {
+			t.Errorf("%s\nhave %s\nwant %s", test.name, statsJson, test.want)
+		}
+	}
+}
+
+//grouped rows sort by label value within an action
+func TestGetStats_GroupByOrder(t *testing.T) {
+	st := NewStats()
+	st.AddAction(`{"action":"jump", "time":100, "labels":{"host":"b"}}`)
+	st.AddAction(`{"action":"jump", "time":300, "labels":{"host":"a"}}`)
+	st.AddAction(`{"action":"jump", "time":200, "labels":{"host":"c"}}`)
+	st.AddAction(`{"action":"jump", "time":200, "labels":{"host":"c"}}`)
+
+	statsJson, _ := st.GetStats(GroupBy("host"))
+	want := `[{"action":"jump","avg":300,"labels":{"host":"a"}},{"action":"jump","avg":100,"labels":{"host":"b"}},{"action":"jump","avg":200,"labels":{"host":"c"}}]`
+	if statsJson != want {
+		t.Errorf("wrong grouped order\nhave %s\nwant %s", statsJson, want)
+	}
+	statsJson, _ = st.GetStats(GroupBy("host"), SortBy(SortByCount, Descending), Limit(1))
+	if statsJson != `[{"action":"jump","avg":200,"labels":{"host":"c"}}]` {
+		t.Errorf("wrong grouped count order %s", statsJson)
+	}
+}
+
+//GetAverages returns the same rows as GetStats without the json
+func TestGetAverages(t *testing.T) {
+	st := makeQueryStats()
+	averages, err := st.GetAverages(SortBy(SortByAverage, Descending), Limit(1))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(averages) != 1 || averages[0].Action != "api.put" || averages[0].Average != 500 {
+		t.Errorf("wrong averages %+v", averages)
+	}
+}
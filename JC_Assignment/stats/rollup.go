@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"math/big"
+	"strings"
+)
+
+//rolls actions up by the first depth parts of their dotted names, e.g. with Rollup(1)
+//checkout.payment.authorize and checkout.cart.add are returned together as checkout.*.
+//Actions with depth parts or fewer are returned as they are, and 0 turns rollups off.
+//Rollups are added up from the totals and counts of each action so they are exact,
+//and the filters apply to the actions before they are rolled up. Rollups have no moving average.
+//A time without a unit cannot be converted, so actions without a unit are rolled up apart from
+//the actions with one and the two are returned as separate rows of the same name
+func Rollup(depth int) QueryOption {
+	return func(q *query) {
+		q.depth = depth
+	}
+}
+
+//totals of the actions rolled up into one row
+type rollup struct {
+	action string
+	labels map[string]string
+	//the finest unit of the actions, empty for the actions without one
+	unit  string
+	count uint64
+	//in nanoseconds for the actions with a unit
+	total *big.Int
+}
+
+//the row an action is rolled up into: its rollup name, the value of the label the rows are
+//grouped by and whether the action has a unit
+type rollupKey struct {
+	action string
+	value  string
+	timed  bool
+}
+
+//the name an action is rolled up into at depth
+func rollupName(action string, depth int) string {
+	parts := strings.SplitN(action, ".", depth+1)
+	if len(parts) <= depth {
+		return action
+	}
+	return strings.Join(parts[:depth], ".") + ".*"
+}
+
+//adds an action to the rollup it belongs to, split by the label q groups by
+func (q *query) addRollup(rollups map[rollupKey]*rollup, action string, a *Average) {
+	name := rollupName(action, q.depth)
+	values := a.labels[q.groupBy]
+	if q.groupBy == "" || len(values) == 0 {
+		total, _ := new(big.Int).SetString(a.totalString(), 10)
+		addRollupTotals(rollups, name, q.groupBy, "", a.Unit, a.NumSamples, total)
+		return
+	}
+	for value, totals := range values {
+		addRollupTotals(rollups, name, q.groupBy, value, a.Unit, totals.NumSamples, new(big.Int).SetUint64(totals.TotalTime))
+	}
+}
+
+func addRollupTotals(rollups map[rollupKey]*rollup, name string, label string, value string, unit string, count uint64, total *big.Int) {
+	key := rollupKey{action: name, value: value, timed: unit != ""}
+	r := rollups[key]
+	if r == nil {
+		r = &rollup{action: name, total: new(big.Int)}
+		if label != "" {
+			r.labels = map[string]string{label: value}
+		}
+		rollups[key] = r
+	}
+	r.count = addSaturating(r.count, count)
+	r.total.Add(r.total, total)
+	if unit != "" && (r.unit == "" || unitNanos(unit) < unitNanos(r.unit)) {
+		r.unit = unit
+	}
+}
+
+//builds the output row of a rollup
+func (r *rollup) row(q *query) statsRow {
+	count := new(big.Int).SetUint64(r.count)
+	avg := new(big.Int).Quo(r.total, count).Uint64()
+	exact, _ := new(big.Float).Quo(new(big.Float).SetInt(r.total), new(big.Float).SetInt(count)).Float64()
+	row := q.row(r.action, r.unit, avg, exact, r.count)
+	row.average.Labels = r.labels
+	return row
+}
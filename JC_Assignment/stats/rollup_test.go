@@ -0,0 +1,121 @@
+package stats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestRollupName(t *testing.T) {
+	tests := []struct {
+		action string
+		depth  int
+		want   string
+	}{
+		{"checkout.payment.authorize", 1, "checkout.*"},
+		{"checkout.payment.authorize", 2, "checkout.payment.*"},
+		{"checkout.payment.authorize", 3, "checkout.payment.authorize"},
+		{"checkout", 1, "checkout"},
+		{"checkout.", 1, "checkout.*"},
+	}
+	for _, tc := range tests {
+		if have := rollupName(tc.action, tc.depth); have != tc.want {
+			t.Errorf("%s at depth %d rolled up into %s want %s", tc.action, tc.depth, have, tc.want)
+		}
+	}
+}
+
+//rollups weigh each action by its count, not average the averages
+func TestGetStats_Rollup(t *testing.T) {
+	st := NewStats()
+	st.AddAction(`{"action":"checkout.payment.authorize", "time":100}`)
+	st.AddAction(`{"action":"checkout.payment.authorize", "time":200}`)
+	st.AddAction(`{"action":"checkout.payment.capture", "time":600}`)
+	st.AddAction(`{"action":"checkout.cart", "time":100}`)
+	st.AddAction(`{"action":"search", "time":50}`)
+
+	tests := []struct {
+		opts []QueryOption
+		want string
+	}{
+		{[]QueryOption{Rollup(1)}, `[{"action":"checkout.*","avg":250},{"action":"search","avg":50}]`},
+		{[]QueryOption{Rollup(2)}, `[{"action":"checkout.cart","avg":100},{"action":"checkout.payment.*","avg":300},{"action":"search","avg":50}]`},
+		{[]QueryOption{Rollup(3), Prefix("checkout.payment")}, `[{"action":"checkout.payment.authorize","avg":150},{"action":"checkout.payment.capture","avg":600}]`},
+		{[]QueryOption{Rollup(1), Prefix("checkout.payment")}, `[{"action":"checkout.*","avg":300}]`},
+		{[]QueryOption{Rollup(1), SortBy(SortByCount, Descending), Limit(1)}, `[{"action":"checkout.*","avg":250}]`},
+	}
+	for _, tc := range tests {
+		statsJson, err := st.GetStats(tc.opts...)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if statsJson != tc.want {
+			t.Errorf("wrong rollup\nhave %s\nwant %s", statsJson, tc.want)
+		}
+	}
+	if _, err := st.GetStats(Rollup(-1)); err == nil {
+		t.Error("accepted a negative depth")
+	}
+}
+
+//rollups can be grouped by a label and mix units
+func TestGetStats_RollupLabelsAndUnits(t *testing.T) {
+	st := NewStats()
+	st.AddAction(`{"action":"api.get", "time":1, "unit":"ms", "labels":{"host":"a"}}`)
+	st.AddAction(`{"action":"api.put", "time":3000, "unit":"us", "labels":{"host":"a"}}`)
+	st.AddAction(`{"action":"api.put", "time":5000, "unit":"us"}`)
+	//no unit, so it cannot be converted and is rolled up on its own
+	st.AddAction(`{"action":"api.list", "time":8}`)
+
+	statsJson, _ := st.GetStats(Rollup(1))
+	if statsJson != `[{"action":"api.*","avg":8},{"action":"api.*","avg":3000,"unit":"us"}]` {
+		t.Errorf("wrong rollup across units %s", statsJson)
+	}
+	want := map[[3]string]uint64{
+		{"api.*", "a", "us"}: 2000,
+		{"api.*", "", "us"}:  5000,
+		{"api.*", "", ""}:    8,
+	}
+	have := groupedAverages(t, &st, "host")
+	if len(have) != 4 {
+		t.Errorf("wrong groups without a rollup %v", have)
+	}
+	rolled := make(map[[3]string]uint64)
+	for _, average := range unitAverages(t, &st, Rollup(1), GroupBy("host")) {
+		rolled[[3]string{average.Action, average.Labels["host"], average.Unit}] = average.Average
+	}
+	if !reflect.DeepEqual(rolled, want) {
+		t.Errorf("wrong grouped rollup %v want %v", rolled, want)
+	}
+}
+
+//a time without a unit is not taken to be in the unit of the rest of the rollup
+func TestGetStats_RollupWithoutUnit(t *testing.T) {
+	st := NewStats()
+	st.AddAction(`{"action":"checkout.a", "time":5}`)
+	st.AddAction(`{"action":"checkout.b", "time":1, "unit":"s"}`)
+	want := []SampleAverage{
+		{Action: "checkout.*", Average: 5},
+		{Action: "checkout.*", Average: 1, Unit: "s"},
+	}
+	if have := unitAverages(t, &st, Rollup(1)); !reflect.DeepEqual(have, want) {
+		t.Errorf("wrong rollup of actions with and without a unit %+v", have)
+	}
+	//the same with the rows converted into one unit
+	want[1] = SampleAverage{Action: "checkout.*", Average: 1000, Unit: "ms", Value: 1000}
+	want[0].Value = 5
+	if have := unitAverages(t, &st, Rollup(1), InUnit("ms")); !reflect.DeepEqual(have, want) {
+		t.Errorf("wrong rollup in ms %+v", have)
+	}
+}
+
+//totals of many actions can add up past uint64
+func TestGetStats_RollupLargeTotals(t *testing.T) {
+	st := NewStats()
+	st.addAction(Sample{Action: "a.x", Time: math.MaxUint64})
+	st.addAction(Sample{Action: "a.y", Time: math.MaxUint64 - 2})
+	statsJson, _ := st.GetStats(Rollup(1))
+	if statsJson != `[{"action":"a.*","avg":18446744073709551614}]` {
+		t.Errorf("wrong rollup of large totals %s", statsJson)
+	}
+}
@@ -0,0 +1,281 @@
+package stats
+
+import (
+	"math/big"
+	"sync"
+	"sync/atomic"
+)
+
+//number of shards used when WithShards is not given
+const defaultShards = 64
+
+//one independently locked slice of the actions.
+//Actions are spread across shards by the hash of their name so adds for
+//different actions rarely wait on each other
+type shard struct {
+	mu    sync.Mutex
+	store Store
+	//1 once an action has been put in the shard, so reads can skip shards that were never used.
+	//Read without the lock and only cleared when SwapAndReset empties the shard
+	populated uint32
+	//cap shared by every shard, nil for no cap. Set by WithMaxActions
+	limit *actionLimit
+	//picks the action to remove when full, nil when new actions are rejected instead
+	evictor evictor
+	//place of each action in the eviction order, only kept when WithMaxActions evicts
+	tracked  map[string]*evictEntry
+	evicted  uint64
+	rejected uint64
+	//whether each action is above each threshold by subscription id, only kept with thresholds
+	above map[string]map[uint64][]bool
+	//error tracking the actions already in the storage when the stats were created,
+	//returned by every add and read of the shard since NewStats cannot return it
+	err error
+}
+
+func newShards(numShards int, storage Storage) []*shard {
+	shards := make([]*shard, numShards)
+	for i := range shards {
+		shards[i] = &shard{store: storage.Store(i, numShards)}
+		//actions already in the storage
+		if shards[i].store.Len() > 0 {
+			shards[i].markPopulated()
+		}
+	}
+	return shards
+}
+
+func (sh *shard) markPopulated() {
+	if atomic.LoadUint32(&sh.populated) == 0 {
+		atomic.StoreUint32(&sh.populated, 1)
+	}
+}
+
+//FNV-1a hash of the action name, inlined to avoid allocating a hasher per call
+func hashAction(action string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(action); i++ {
+		hash ^= uint32(action[i])
+		hash *= 16777619
+	}
+	return hash
+}
+
+//returns the shard that owns action
+func (s *Stats) shardFor(action string) *shard {
+	return s.shards[hashAction(action)%uint32(len(s.shards))]
+}
+
+//locks every shard in order so the caller sees a consistent view of all actions
+func (s *Stats) lockAll() {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+	}
+}
+
+func (s *Stats) unlockAll() {
+	for i := len(s.shards) - 1; i >= 0; i-- {
+		s.shards[i].mu.Unlock()
+	}
+}
+
+//locks every shard that has been used in order, so the caller sees a consistent view of
+//all actions without waiting on shards that are empty. Shards that failed to set up are
+//included so their error is seen.
+//A shard is marked populated under its lock, so once the locks are held a shard that is still
+//not marked has nothing that was added before them. If one was marked while the locks were
+//being taken every shard is locked instead, since locking it out of order could deadlock
+func (s *Stats) lockPopulated() []*shard {
+	locked := make([]*shard, 0, len(s.shards))
+	for _, sh := range s.shards {
+		if sh.isPopulated() {
+			sh.mu.Lock()
+			locked = append(locked, sh)
+		}
+	}
+	populated := 0
+	for _, sh := range s.shards {
+		if sh.isPopulated() {
+			populated++
+		}
+	}
+	//the locked shards cannot be emptied while held, so a change in the count is a new shard
+	if populated == len(locked) {
+		return locked
+	}
+	for i := len(locked) - 1; i >= 0; i-- {
+		locked[i].mu.Unlock()
+	}
+	s.lockAll()
+	return s.shards
+}
+
+//whether the shard has been used or failed to set up
+func (sh *shard) isPopulated() bool {
+	return atomic.LoadUint32(&sh.populated) == 1 || sh.err != nil
+}
+
+//calls fn for every action while holding the locks of every shard with actions.
+//fn must not call back into the stats struct
+func (s *Stats) rangeAverages(fn func(action string, average *Average)) error {
+	locked := s.lockPopulated()
+	defer func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].mu.Unlock()
+		}
+	}()
+	//made once instead of once per shard
+	each := func(action string, average *Average) error {
+		fn(action, average)
+		return nil
+	}
+	for _, sh := range locked {
+		if sh.err != nil {
+			return sh.err
+		}
+		if err := sh.store.Range(each); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//returns a copy of the totals for a single action and whether the action exists.
+//An action the storage cannot read is reported as missing
+func (s *Stats) Lookup(action string) (Average, bool) {
+	sh := s.shardFor(action)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	average, err := sh.store.Get(action)
+	if err != nil || average == nil {
+		return Average{}, false
+	}
+	return average.clone(), true
+}
+
+//deep copy of an average so it can be used outside of the shard lock
+func (a *Average) clone() Average {
+	c := *a
+	if a.bigTotal != nil {
+		c.bigTotal = new(big.Int).Set(a.bigTotal)
+	}
+	c.hist.buckets = append([]histBucket(nil), a.hist.buckets...)
+	if a.window != nil {
+		c.window = &window{buckets: append([]windowBucket(nil), a.window.buckets...)}
+	}
+	if a.ewma != nil {
+		copied := *a.ewma
+		c.ewma = &copied
+	}
+	if a.labels != nil {
+		c.labels = make(map[string]map[string]*labelTotals, len(a.labels))
+		for name, values := range a.labels {
+			c.labels[name] = make(map[string]*labelTotals, len(values))
+			for value, totals := range values {
+				copied := *totals
+				c.labels[name][value] = &copied
+			}
+		}
+	}
+	return c
+}
+
+//removes an action and all of its totals, returns false if the action did not exist
+//or the storage could not remove it
+func (s *Stats) Remove(action string) bool {
+	sh := s.shardFor(action)
+	sh.mu.Lock()
+	wal := s.wal.log
+	average, err := sh.store.Get(action)
+	ok := err == nil && average != nil
+	if ok {
+		ok = sh.store.Delete(action) == nil
+	}
+	if ok {
+		sh.release(action)
+		//only logged once removed, so a replay keeps an action the storage could not remove.
+		//A failed write is kept by the log and returned by the next add
+		if wal != nil {
+			wal.append(walRemove, func(buf []byte) []byte {
+				return appendString(buf, action)
+			})
+		}
+		sh.untrack(action)
+		delete(sh.above, action)
+	}
+	sh.mu.Unlock()
+	wal.syncAdd()
+	return ok
+}
+
+//removes every action and clears the eviction and overflow counters
+func (s *Stats) Reset() {
+	s.SwapAndReset()
+}
+
+//starts a new interval and returns the stats of the one that ended, with the same options.
+//Every shard is swapped under its lock so each sample lands in exactly one interval,
+//even with concurrent adds. The interval that ended is always kept in memory,
+//with WithStorage it is copied out of the storage before the storage is cleared
+func (s *Stats) SwapAndReset() Stats {
+	all := newAveragesIndex()
+	previous := Stats{
+		Averages: all.averages,
+		shards:   make([]*shard, len(s.shards)),
+		index:    all,
+		limit:    newActionLimit(s.maxActions, s.evictionPolicy),
+		subs:     &subscribers{},
+		wal:      &walRef{},
+		options:  s.options,
+	}
+	//kept in memory whatever the storage of s
+	previous.storage = nil
+	s.lockAll()
+	wal := s.wal.log
+	if wal != nil {
+		wal.append(walReset, func(buf []byte) []byte {
+			return buf
+		})
+	}
+	for i, sh := range s.shards {
+		//the evictor moves with the averages it tracks
+		previous.shards[i] = &shard{
+			store:     sh.swapStore(all),
+			populated: sh.populated,
+			limit:     previous.limit,
+			evictor:   sh.evictor,
+			tracked:   sh.tracked,
+			evicted:   sh.evicted,
+			rejected:  sh.rejected,
+		}
+		sh.above = nil
+		atomic.StoreUint32(&sh.populated, 0)
+		sh.evicted, sh.rejected = 0, 0
+		if sh.limit != nil {
+			sh.resetEvictor(s.evictionPolicy)
+		}
+	}
+	//the count moves with the actions, the cap starts over empty
+	if s.limit != nil {
+		atomic.StoreInt64(&previous.limit.actions, atomic.SwapInt64(&s.limit.actions, 0))
+		atomic.StoreUint64(&previous.limit.clock, atomic.LoadUint64(&s.limit.clock))
+	}
+	s.index.moveTo(all)
+	s.unlockAll()
+	wal.syncAdd()
+	return previous
+}
+
+//empties the store of the shard and returns what it held in memory listed in all,
+//the shard must be locked. An action the storage cannot read is left out of what is returned
+func (sh *shard) swapStore(all *averagesIndex) Store {
+	if m, ok := sh.store.(*mapStore); ok {
+		//the averages stay listed in the index of the shard until SwapAndReset moves them
+		sh.store = &mapStore{averages: make(map[string]*Average), all: m.all}
+		m.all = all
+		return m
+	}
+	previous, _ := copyToMap(sh.store, all)
+	sh.store.Clear()
+	return previous
+}
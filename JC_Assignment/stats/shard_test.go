@@ -0,0 +1,360 @@
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//test that a copy of the totals is returned and the original is untouched
+func TestLookup(t *testing.T) {
+	st := NewStats()
+	st.addAction(Sample{Action: "jump", Time: 100})
+	st.addAction(Sample{Action: "jump", Time: 200})
+
+	jump, ok := st.Lookup("jump")
+	if !ok {
+		t.Fatal("jump was not found")
+	}
+	if jump.NumSamples != 2 || jump.TotalTime != 300 || jump.Min != 100 || jump.Max != 200 {
+		t.Fatalf("wrong totals for jump %+v", jump)
+	}
+
+	jump.hist.add(1)
+	original, _ := st.Lookup("jump")
+	if len(original.hist.buckets) != 2 {
+		t.Fatal("changing the copy changed the stats")
+	}
+
+	if _, ok := st.Lookup("run"); ok {
+		t.Fatal("found an action that was never added")
+	}
+}
+
+//a removed action is gone and the others stay
+func TestRemove(t *testing.T) {
+	st := NewStats()
+	st.addAction(Sample{Action: "jump", Time: 100})
+	st.addAction(Sample{Action: "run", Time: 75})
+
+	if !st.Remove("jump") {
+		t.Fatal("jump was not removed")
+	}
+	if st.Remove("jump") {
+		t.Fatal("jump was removed twice")
+	}
+	if _, ok := st.Lookup("jump"); ok {
+		t.Fatal("jump is still there")
+	}
+	if _, ok := st.Lookup("run"); !ok {
+		t.Fatal("run was removed with jump")
+	}
+}
+
+//the deprecated Averages map follows the actions as they are added, removed, evicted and swapped out
+func TestAverages_Map(t *testing.T) {
+	st := NewStats(WithMaxActions(3, EvictLRU), WithShards(1))
+	st.addAction(Sample{Action: "jump", Time: 100})
+	st.addAction(Sample{Action: "jump", Time: 200})
+	st.addAction(Sample{Action: "run", Time: 75, Unit: "ms"})
+	st.addAction(Sample{Action: "walk", Time: 10})
+	if jump := st.Averages["jump"]; jump == nil || jump.NumSamples != 2 || jump.TotalTime != 300 {
+		t.Fatalf("wrong totals for jump %+v", jump)
+	}
+	if run := st.Averages["run"]; run == nil || run.TotalTime != 75000000 {
+		t.Fatalf("wrong totals for run %+v", run)
+	}
+
+	st.Remove("run")
+	st.addAction(Sample{Action: "crawl", Time: 1})
+	st.addAction(Sample{Action: "swim", Time: 1})
+	if len(st.Averages) != 3 || st.Averages["run"] != nil || st.Averages["jump"] != nil || st.Averages["swim"] == nil {
+		t.Fatalf("removed and evicted actions are still listed %v", st.Averages)
+	}
+
+	//copies share the map, so SwapAndReset empties it in place
+	shared := st
+	previous := st.SwapAndReset()
+	st.addAction(Sample{Action: "jump", Time: 5})
+	if len(shared.Averages) != 1 || shared.Averages["jump"].TotalTime != 5 {
+		t.Fatalf("wrong actions after swapping %v", shared.Averages)
+	}
+	if len(previous.Averages) != 3 || previous.Averages["walk"].TotalTime != 10 {
+		t.Fatalf("wrong actions in the previous interval %v", previous.Averages)
+	}
+}
+
+//many goroutines adding many actions across all shards must not lose samples
+func TestAddAction_ConcurrentShards(t *testing.T) {
+	for _, numShards := range []int{1, 7, defaultShards} {
+		t.Run(fmt.Sprintf("shards %d", numShards), func(t *testing.T) {
+			st := NewStats(WithShards(numShards))
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 1000; i++ {
+						st.addAction(Sample{Action: fmt.Sprintf("Action%d", i%100), Time: 10})
+					}
+				}()
+			}
+			wg.Wait()
+
+			slice, err := st.getSampleAverageSlice()
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if len(slice) != 100 {
+				t.Fatalf("expected 100 actions but found %d", len(slice))
+			}
+			for i := 0; i < 100; i++ {
+				average, _ := st.Lookup(fmt.Sprintf("Action%d", i))
+				if average.NumSamples != 80 || average.TotalTime != 800 {
+					t.Fatalf("Action%d lost samples %+v", i, average)
+				}
+			}
+		})
+	}
+}
+
+//copies of the struct share the same shards
+func TestStats_CopySharesShards(t *testing.T) {
+	st := NewStats()
+	cp := st
+	cp.addAction(Sample{Action: "jump", Time: 1})
+	if _, ok := st.Lookup("jump"); !ok {
+		t.Fatal("copy of the stats did not share the shards")
+	}
+}
+
+//an action added after another one is never seen without it, even when the first one lands in
+//a shard that was empty while the locks were being taken
+func TestGetStats_ConsistentSnapshot(t *testing.T) {
+	//busy is in a shard between the first and the last
+	first, busy, second := "", "", ""
+	for i := 0; first == "" || busy == "" || second == ""; i++ {
+		action := fmt.Sprintf("Action%d", i)
+		switch hashAction(action) % defaultShards {
+		case 0:
+			first = action
+		case defaultShards / 2:
+			busy = action
+		case defaultShards - 1:
+			second = action
+		}
+	}
+	st := NewStats()
+	st.addAction(Sample{Action: busy, Time: 1})
+	//the snapshot passes the empty first shard and waits on the busy one
+	busyShard := st.shardFor(busy)
+	busyShard.mu.Lock()
+	seen := make(map[string]bool)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		st.rangeAverages(func(action string, average *Average) {
+			seen[action] = true
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	st.addAction(Sample{Action: first, Time: 1})
+	st.addAction(Sample{Action: second, Time: 1})
+	busyShard.mu.Unlock()
+	<-done
+	if seen[second] && !seen[first] {
+		t.Fatalf("snapshot has %s but not %s which was added before it", second, first)
+	}
+}
+
+//=====================Parallel Benchmark Tests====================//
+// Each benchmark runs with a single shard, which behaves like the original single mutex,
+// and with the default number of shards to show how ingestion scales with cores
+
+var shardCounts = []int{1, defaultShards}
+
+//benchmark parallel adds where every goroutine works on its own set of actions
+func BenchmarkAddAction_parallel_unique(b *testing.B) {
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		for _, numShards := range shardCounts {
+			b.Run(fmt.Sprintf("shards=%d", numShards), func(b *testing.B) {
+				st := newStats(WithShards(numShards))
+				var goroutine int64
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					id := atomic.AddInt64(&goroutine, 1)
+					actions := make([]Sample, 100)
+					for i := range actions {
+						actions[i] = Sample{Action: fmt.Sprintf("Action%d-%d", id, i), Time: 1}
+					}
+					i := 0
+					for pb.Next() {
+						st.addAction(actions[i%len(actions)])
+						i++
+					}
+				})
+			})
+		}
+	})
+}
+
+//benchmark parallel adds where every goroutine shares the same 10 actions
+func BenchmarkAddAction_parallel_shared(b *testing.B) {
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		for _, numShards := range shardCounts {
+			b.Run(fmt.Sprintf("shards=%d", numShards), func(b *testing.B) {
+				st := newStats(WithShards(numShards))
+				actions := make([]Sample, 10)
+				for i := range actions {
+					actions[i] = Sample{Action: fmt.Sprintf("Action%d", i), Time: 1}
+				}
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := 0
+					for pb.Next() {
+						st.addAction(actions[i%len(actions)])
+						i++
+					}
+				})
+			})
+		}
+	})
+}
+
+//benchmark parallel adds to one action, the same workload as BenchmarkAddAction_direct_highvolume.
+//a single action always lives in one shard, so this is the worst case for sharding
+func BenchmarkAddAction_parallel_highvolume(b *testing.B) {
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		for _, numShards := range shardCounts {
+			b.Run(fmt.Sprintf("shards=%d", numShards), func(b *testing.B) {
+				st := newStats(WithShards(numShards))
+				sample := Sample{Action: "action", Time: 1}
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						st.addAction(sample)
+					}
+				})
+			})
+		}
+	})
+}
+
+//benchmark parallel json adds mixed with a GetStats snapshot every 1000 adds
+func BenchmarkAddAction_parallel_withGetStats(b *testing.B) {
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		for _, numShards := range shardCounts {
+			b.Run(fmt.Sprintf("shards=%d", numShards), func(b *testing.B) {
+				st := newStats(WithShards(numShards))
+				var goroutine int64
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					id := atomic.AddInt64(&goroutine, 1)
+					jsonString := fmt.Sprintf("{\"action\":\"Action%d\", \"time\":10}", id)
+					i := 0
+					for pb.Next() {
+						if i%1000 == 0 {
+							st.GetStats()
+						} else {
+							st.AddAction(jsonString)
+						}
+						i++
+					}
+				})
+			})
+		}
+	})
+}
+
+//reset clears every action and the counters
+func TestReset(t *testing.T) {
+	st := NewStats(WithShards(1), WithMaxActions(1, RejectNew))
+	addUniqueActions(&st, 3)
+	st.Reset()
+	if have := st.Cardinality(); have != (CardinalityStats{MaxActions: 1}) {
+		t.Errorf("wrong cardinality after reset %+v", have)
+	}
+	statsJson, _ := st.GetStats()
+	if statsJson != "[]" {
+		t.Errorf("stats not empty after reset %s", statsJson)
+	}
+	//the cap still applies to the new interval
+	addUniqueActions(&st, 2)
+	if have := st.Cardinality(); have.Actions != 1 || have.Rejected != 1 {
+		t.Errorf("wrong cardinality after adding again %+v", have)
+	}
+}
+
+//the previous interval keeps its totals and the new one starts empty
+func TestSwapAndReset(t *testing.T) {
+	st := NewStats(WithShards(1), WithMaxActions(2, EvictLRU))
+	for _, action := range []string{"jump", "run", "jump", "walk"} {
+		st.addAction(Sample{Action: action, Time: 100})
+	}
+	previous := st.SwapAndReset()
+	statsJson, _ := previous.GetStats()
+	if statsJson != `[{"action":"jump","avg":100},{"action":"walk","avg":100}]` {
+		t.Errorf("wrong previous interval %s", statsJson)
+	}
+	if have := previous.Cardinality(); have.Evicted != 1 {
+		t.Errorf("previous interval lost its counters %+v", have)
+	}
+
+	st.addAction(Sample{Action: "swim", Time: 50})
+	statsJson, _ = st.GetStats()
+	if statsJson != `[{"action":"swim","avg":50}]` {
+		t.Errorf("wrong new interval %s", statsJson)
+	}
+	//the intervals do not share anything
+	previous.addAction(Sample{Action: "fly", Time: 1})
+	if _, ok := st.Lookup("fly"); ok {
+		t.Error("the previous interval shares actions with the new one")
+	}
+	if have := st.Cardinality(); have.Actions != 1 || have.Evicted != 0 {
+		t.Errorf("wrong cardinality in the new interval %+v", have)
+	}
+}
+
+//no sample is lost or counted twice when intervals are swapped during concurrent adds
+func TestSwapAndReset_Concurrent(t *testing.T) {
+	st := NewStats(WithShards(8))
+	numWorkers := 8
+	numSamples := 5000
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < numSamples; i++ {
+				st.addAction(Sample{Action: fmt.Sprintf("Action%d", i%20), Time: 1})
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	var total uint64
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			previous := st.SwapAndReset()
+			previous.rangeAverages(func(action string, average *Average) {
+				total += average.NumSamples
+			})
+		}
+	}()
+	wg.Wait()
+	done <- struct{}{}
+	<-done
+	st.rangeAverages(func(action string, average *Average) {
+		total += average.NumSamples
+	})
+	if total != uint64(numWorkers*numSamples) {
+		t.Errorf("counted %d samples across intervals, want %d", total, numWorkers*numSamples)
+	}
+}
@@ -0,0 +1,566 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+//version written into every snapshot, bumped whenever the layout changes
+//version 2 added units and label totals, version 3 added moving averages,
+//version 4 added totals past uint64, version 5 keeps the times of actions with a unit in nanoseconds
+const snapshotVersion = 5
+
+//whether snapshots of version can be read, older versions are upgraded as they are read
+func supportedVersion(version int) bool {
+	return version >= 1 && version <= snapshotVersion
+}
+
+//first bytes of a binary snapshot, json snapshots start with {
+var snapshotMagic = []byte("JCST")
+
+//encoding used by Snapshot
+type SnapshotFormat int
+
+const (
+	//human readable json, the default
+	SnapshotJSON SnapshotFormat = iota
+	//compact varint encoding
+	SnapshotBinary
+)
+
+//serializable state of a single action
+type ActionState struct {
+	NumSamples uint64  `json:"numSamples"`
+	TotalTime  uint64  `json:"totalTime"`
+	Min        uint64  `json:"min"`
+	Max        uint64  `json:"max"`
+	Mean       float64 `json:"mean"`
+	M2         float64 `json:"m2"`
+	//non empty histogram buckets as [index, count] pairs
+	Histogram [][2]uint64 `json:"histogram,omitempty"`
+	//live window buckets, only kept when sliding windows are enabled
+	Window []WindowState `json:"window,omitempty"`
+	Unit   string        `json:"unit,omitempty"`
+	//totals by label name and value
+	Labels map[string]map[string]LabelState `json:"labels,omitempty"`
+	//moving average, only kept when moving averages are enabled
+	EWMA *EWMAState `json:"ewma,omitempty"`
+	//exact decimal total once it no longer fits TotalTime, only with AccumulateBig
+	BigTotal string `json:"bigTotal,omitempty"`
+	//compensated sum with AccumulateKahan, Saturated is set once it has grown past TotalTime
+	KahanSum  float64 `json:"kahanSum,omitempty"`
+	KahanC    float64 `json:"kahanC,omitempty"`
+	Saturated bool    `json:"saturated,omitempty"`
+}
+
+//serializable totals for the samples of an action that share one label value
+type LabelState struct {
+	NumSamples uint64 `json:"numSamples"`
+	TotalTime  uint64 `json:"totalTime"`
+}
+
+//serializable state of one sliding window bucket
+type WindowState struct {
+	Epoch      int64  `json:"epoch"`
+	NumSamples uint64 `json:"numSamples"`
+	TotalTime  uint64 `json:"totalTime"`
+}
+
+//serializable state of a whole stats struct, keyed by action
+type State struct {
+	Version int `json:"version"`
+	//resolution the window epochs were counted in, windows are dropped on restore if it differs
+	WindowResolution time.Duration `json:"windowResolution,omitempty"`
+	//half life the moving averages decayed with, they are dropped on restore if it differs
+	EWMAHalfLife time.Duration `json:"ewmaHalfLife,omitempty"`
+	//strategy the totals were kept with, exact totals can be restored with any strategy
+	Accumulation Accumulation           `json:"accumulation,omitempty"`
+	Averages     map[string]ActionState `json:"averages"`
+}
+
+//sets the encoding written by Snapshot, Restore reads either
+func WithSnapshotFormat(format SnapshotFormat) Option {
+	return func(o *options) {
+		o.snapshotFormat = format
+	}
+}
+
+//converts an average into its serializable state
+func (a *Average) state() ActionState {
+	st := ActionState{
+		NumSamples: a.NumSamples,
+		TotalTime:  a.TotalTime,
+		Min:        a.Min,
+		Max:        a.Max,
+		Mean:       a.mean,
+		M2:         a.m2,
+		Unit:       a.Unit,
+		KahanSum:   a.kahanSum,
+		KahanC:     a.kahanC,
+		Saturated:  a.saturated,
+	}
+	if a.bigTotal != nil {
+		st.BigTotal = a.bigTotal.String()
+	}
+	for _, b := range a.hist.buckets {
+		st.Histogram = append(st.Histogram, [2]uint64{uint64(b.index), b.count})
+	}
+	if a.window != nil {
+		for _, b := range a.window.buckets {
+			if b.epoch >= 0 {
+				st.Window = append(st.Window, WindowState{Epoch: b.epoch, NumSamples: b.numSamples, TotalTime: b.totalTime})
+			}
+		}
+	}
+	if a.ewma != nil {
+		st.EWMA = &EWMAState{Sum: a.ewma.sum, Weight: a.ewma.weight, At: a.ewma.at}
+	}
+	if len(a.labels) > 0 {
+		st.Labels = make(map[string]map[string]LabelState, len(a.labels))
+		for name, values := range a.labels {
+			st.Labels[name] = make(map[string]LabelState, len(values))
+			for value, totals := range values {
+				st.Labels[name][value] = LabelState{NumSamples: totals.NumSamples, TotalTime: totals.TotalTime}
+			}
+		}
+	}
+	return st
+}
+
+//rebuilds an average from its serializable state
+func (s *Stats) averageFromState(st ActionState, windowResolution time.Duration, ewmaHalfLife time.Duration) (*Average, error) {
+	if st.NumSamples == 0 {
+		return nil, errors.New("action has no samples")
+	}
+	if !validUnit(st.Unit) {
+		return nil, fmt.Errorf("unit %q is not one of ns, us, ms or s", st.Unit)
+	}
+	a := &Average{
+		NumSamples: st.NumSamples,
+		TotalTime:  st.TotalTime,
+		Min:        st.Min,
+		Max:        st.Max,
+		Unit:       st.Unit,
+		mean:       st.Mean,
+		m2:         st.M2,
+	}
+	if err := s.restoreTotal(a, st); err != nil {
+		return nil, err
+	}
+	for name, values := range st.Labels {
+		if a.labels == nil {
+			a.labels = make(map[string]map[string]*labelTotals)
+		}
+		a.labels[name] = make(map[string]*labelTotals, len(values))
+		for value, totals := range values {
+			if totals.NumSamples == 0 {
+				return nil, fmt.Errorf("label %s=%s has no samples", name, value)
+			}
+			a.labels[name][value] = &labelTotals{NumSamples: totals.NumSamples, TotalTime: totals.TotalTime}
+		}
+	}
+	for _, b := range st.Histogram {
+		if b[0] >= 64*histSubBuckets {
+			return nil, fmt.Errorf("histogram bucket %d is out of range", b[0])
+		}
+		a.hist.addCount(uint16(b[0]), b[1])
+	}
+	//window epochs only mean something at the resolution they were counted in
+	if s.windowed() && windowResolution == s.windowResolution && len(st.Window) > 0 {
+		a.window = newWindow(s.windowBuckets())
+		for _, b := range st.Window {
+			slot := a.window.slot(b.Epoch)
+			if b.Epoch > slot.epoch {
+				*slot = windowBucket{epoch: b.Epoch, numSamples: b.NumSamples, totalTime: b.TotalTime}
+			}
+		}
+	}
+	//moving averages only mean something with the half life they decayed with
+	if s.ewmaEnabled() && ewmaHalfLife == s.ewmaHalfLife && st.EWMA != nil {
+		if !(st.EWMA.Weight >= 0) || math.IsNaN(st.EWMA.Sum) || math.IsInf(st.EWMA.Sum, 0) || math.IsInf(st.EWMA.Weight, 0) {
+			return nil, errors.New("moving average is not a number")
+		}
+		a.ewma = &ewma{sum: st.EWMA.Sum, weight: st.EWMA.Weight, at: st.EWMA.At}
+	}
+	return a, nil
+}
+
+//returns a consistent copy of the state of every action.
+//Actions WithStorage cannot read are left out, Snapshot returns the error instead
+func (s *Stats) State() State {
+	st, _ := s.readState()
+	return st
+}
+
+//the state of every action and the first error reading them from the storage
+func (s *Stats) readState() (State, error) {
+	s.lockAll()
+	defer s.unlockAll()
+	return s.stateLocked()
+}
+
+//state of every action, every shard must be locked
+func (s *Stats) stateLocked() (State, error) {
+	st := State{
+		Version:  snapshotVersion,
+		Averages: make(map[string]ActionState),
+	}
+	if s.windowed() {
+		st.WindowResolution = s.windowResolution
+	}
+	if s.ewmaEnabled() {
+		st.EWMAHalfLife = s.ewmaHalfLife
+	}
+	st.Accumulation = s.accumulation
+	for _, sh := range s.shards {
+		err := sh.store.Range(func(action string, average *Average) error {
+			st.Averages[action] = average.state()
+			return nil
+		})
+		if err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
+
+//replaces every action with the actions in the state
+func (s *Stats) SetState(st State) error {
+	if !supportedVersion(st.Version) {
+		return fmt.Errorf("unsupported snapshot version %d", st.Version)
+	}
+	if err := s.canAccumulate(st.Accumulation); err != nil {
+		return err
+	}
+	st, err := st.upgrade()
+	if err != nil {
+		return err
+	}
+	//build everything before taking the locks so a bad state leaves the stats untouched
+	restored := make([]map[string]*Average, len(s.shards))
+	for i := range restored {
+		restored[i] = make(map[string]*Average)
+	}
+	for action, actionState := range st.Averages {
+		average, err := s.averageFromState(actionState, st.WindowResolution, st.EWMAHalfLife)
+		if err != nil {
+			return fmt.Errorf("snapshot of %s is invalid-> %s", action, err.Error())
+		}
+		restored[hashAction(action)%uint32(len(s.shards))][action] = average
+	}
+	count := len(st.Averages)
+	if _, ok := st.Averages[OverflowAction]; ok {
+		count--
+	}
+	if err := s.fits(count); err != nil {
+		return fmt.Errorf("snapshot is too large-> %s", err.Error())
+	}
+
+	s.lockAll()
+	defer s.unlockAll()
+	for i, sh := range s.shards {
+		if err := sh.replace(restored[i]); err != nil {
+			return err
+		}
+	}
+	return s.retrack()
+}
+
+//replaces every action of the shard, the shard must be locked
+func (sh *shard) replace(averages map[string]*Average) error {
+	sh.above = nil
+	if len(averages) > 0 {
+		sh.markPopulated()
+	}
+	if err := sh.store.Clear(); err != nil {
+		return err
+	}
+	for action, average := range averages {
+		if err := sh.store.Put(action, average); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//writes a consistent snapshot of every action to w in the format set by WithSnapshotFormat
+func (s *Stats) Snapshot(w io.Writer) error {
+	st, err := s.readState()
+	if err != nil {
+		return err
+	}
+	if s.snapshotFormat == SnapshotBinary {
+		return encodeBinaryState(w, st)
+	}
+	return json.NewEncoder(w).Encode(st)
+}
+
+//replaces every action with the snapshot read from r, either format is accepted
+func (s *Stats) Restore(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	head, err := reader.Peek(len(snapshotMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var st State
+	if bytes.Equal(head, snapshotMagic) {
+		st, err = decodeBinaryState(reader)
+	} else {
+		err = json.NewDecoder(reader).Decode(&st)
+	}
+	if err != nil {
+		return errors.New("snapshot is invalid-> " + err.Error())
+	}
+	return s.SetState(st)
+}
+
+//=====================Binary Encoding====================//
+// magic, version, window resolution and action count, then for every action:
+// name, numSamples, totalTime, min, max, mean, m2, histogram buckets, window buckets,
+// from version 2 the unit and label totals, from version 3 the moving average
+// and from version 4 the big total, the Kahan sum and whether the total saturated.
+// Version 3 also writes the moving average half life after the window resolution
+// and version 4 the accumulation strategy after that
+// integers are varints and floats are 8 byte little endian
+
+//writes varints and floats, remembering the first error
+type binaryWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (bw *binaryWriter) uvarint(v uint64) {
+	if bw.err == nil {
+		_, bw.err = bw.w.Write(bw.buf[:binary.PutUvarint(bw.buf[:], v)])
+	}
+}
+
+func (bw *binaryWriter) varint(v int64) {
+	if bw.err == nil {
+		_, bw.err = bw.w.Write(bw.buf[:binary.PutVarint(bw.buf[:], v)])
+	}
+}
+
+func (bw *binaryWriter) float(v float64) {
+	if bw.err == nil {
+		binary.LittleEndian.PutUint64(bw.buf[:8], math.Float64bits(v))
+		_, bw.err = bw.w.Write(bw.buf[:8])
+	}
+}
+
+func (bw *binaryWriter) string(v string) {
+	bw.uvarint(uint64(len(v)))
+	if bw.err == nil {
+		_, bw.err = bw.w.WriteString(v)
+	}
+}
+
+func encodeBinaryState(w io.Writer, st State) error {
+	bw := &binaryWriter{w: bufio.NewWriter(w)}
+	_, bw.err = bw.w.Write(snapshotMagic)
+	bw.uvarint(uint64(st.Version))
+	bw.varint(int64(st.WindowResolution))
+	bw.varint(int64(st.EWMAHalfLife))
+	bw.uvarint(uint64(st.Accumulation))
+	bw.uvarint(uint64(len(st.Averages)))
+	for action, a := range st.Averages {
+		bw.string(action)
+		bw.actionState(a)
+	}
+	if bw.err != nil {
+		return bw.err
+	}
+	return bw.w.Flush()
+}
+
+//writes everything about an action but its name
+func (bw *binaryWriter) actionState(a ActionState) {
+	bw.uvarint(a.NumSamples)
+	bw.uvarint(a.TotalTime)
+	bw.uvarint(a.Min)
+	bw.uvarint(a.Max)
+	bw.float(a.Mean)
+	bw.float(a.M2)
+	bw.uvarint(uint64(len(a.Histogram)))
+	for _, b := range a.Histogram {
+		bw.uvarint(b[0])
+		bw.uvarint(b[1])
+	}
+	bw.uvarint(uint64(len(a.Window)))
+	for _, b := range a.Window {
+		bw.varint(b.Epoch)
+		bw.uvarint(b.NumSamples)
+		bw.uvarint(b.TotalTime)
+	}
+	bw.string(a.Unit)
+	bw.uvarint(uint64(len(a.Labels)))
+	for name, values := range a.Labels {
+		bw.string(name)
+		bw.uvarint(uint64(len(values)))
+		for value, totals := range values {
+			bw.string(value)
+			bw.uvarint(totals.NumSamples)
+			bw.uvarint(totals.TotalTime)
+		}
+	}
+	//a leading 0 or 1 for whether there is a moving average
+	if a.EWMA == nil {
+		bw.uvarint(0)
+	} else {
+		bw.uvarint(1)
+		bw.float(a.EWMA.Sum)
+		bw.float(a.EWMA.Weight)
+		bw.varint(a.EWMA.At)
+	}
+	bw.string(a.BigTotal)
+	bw.float(a.KahanSum)
+	bw.float(a.KahanC)
+	if a.Saturated {
+		bw.uvarint(1)
+	} else {
+		bw.uvarint(0)
+	}
+}
+
+//longest string a binary snapshot holds, action names and labels are far shorter
+const maxBinaryString = 1 << 24
+
+//reads varints and floats, remembering the first error
+type binaryReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (br *binaryReader) uvarint() uint64 {
+	if br.err != nil {
+		return 0
+	}
+	var v uint64
+	v, br.err = binary.ReadUvarint(br.r)
+	return v
+}
+
+func (br *binaryReader) varint() int64 {
+	if br.err != nil {
+		return 0
+	}
+	var v int64
+	v, br.err = binary.ReadVarint(br.r)
+	return v
+}
+
+func (br *binaryReader) float() float64 {
+	var buf [8]byte
+	if br.err == nil {
+		_, br.err = io.ReadFull(br.r, buf[:])
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+}
+
+//reads a length prefixed string, refusing lengths past max.
+//The buffer grows as the string is read, so a bad length cannot allocate more than the input holds
+func (br *binaryReader) string(max uint64) string {
+	n := br.uvarint()
+	if br.err != nil {
+		return ""
+	}
+	if n > max {
+		br.err = fmt.Errorf("length %d is too long", n)
+		return ""
+	}
+	var buf bytes.Buffer
+	_, br.err = io.CopyN(&buf, br.r, int64(n))
+	return buf.String()
+}
+
+//reads a length that is used to size a slice, refusing lengths past max
+func (br *binaryReader) length(max uint64) int {
+	n := br.uvarint()
+	if br.err == nil && n > max {
+		br.err = fmt.Errorf("length %d is too long", n)
+	}
+	return int(n)
+}
+
+func decodeBinaryState(r *bufio.Reader) (State, error) {
+	br := &binaryReader{r: r}
+	magic := make([]byte, len(snapshotMagic))
+	_, br.err = io.ReadFull(r, magic)
+	st := State{
+		Version:          int(br.uvarint()),
+		WindowResolution: time.Duration(br.varint()),
+		Averages:         make(map[string]ActionState),
+	}
+	if br.err == nil && !supportedVersion(st.Version) {
+		return st, fmt.Errorf("unsupported snapshot version %d", st.Version)
+	}
+	if st.Version >= 3 {
+		st.EWMAHalfLife = time.Duration(br.varint())
+	}
+	if st.Version >= 4 {
+		st.Accumulation = Accumulation(br.uvarint())
+	}
+	numActions := br.uvarint()
+	for i := uint64(0); i < numActions && br.err == nil; i++ {
+		action := br.string(maxBinaryString)
+		st.Averages[action] = br.actionState(st.Version)
+	}
+	if br.err == io.EOF {
+		br.err = io.ErrUnexpectedEOF
+	}
+	return st, br.err
+}
+
+//reads everything about an action but its name, as written by a snapshot of version
+func (br *binaryReader) actionState(version int) ActionState {
+	a := ActionState{
+		NumSamples: br.uvarint(),
+		TotalTime:  br.uvarint(),
+		Min:        br.uvarint(),
+		Max:        br.uvarint(),
+		Mean:       br.float(),
+		M2:         br.float(),
+	}
+	numBuckets := br.length(64 * histSubBuckets)
+	for j := 0; j < numBuckets && br.err == nil; j++ {
+		a.Histogram = append(a.Histogram, [2]uint64{br.uvarint(), br.uvarint()})
+	}
+	numWindows := br.length(maxWindowBuckets)
+	for j := 0; j < numWindows && br.err == nil; j++ {
+		a.Window = append(a.Window, WindowState{Epoch: br.varint(), NumSamples: br.uvarint(), TotalTime: br.uvarint()})
+	}
+	if version >= 2 {
+		a.Unit = br.string(maxBinaryString)
+		numNames := br.uvarint()
+		for j := uint64(0); j < numNames && br.err == nil; j++ {
+			if a.Labels == nil {
+				a.Labels = make(map[string]map[string]LabelState)
+			}
+			name := br.string(maxBinaryString)
+			values := make(map[string]LabelState)
+			numValues := br.uvarint()
+			for k := uint64(0); k < numValues && br.err == nil; k++ {
+				values[br.string(maxBinaryString)] = LabelState{NumSamples: br.uvarint(), TotalTime: br.uvarint()}
+			}
+			a.Labels[name] = values
+		}
+	}
+	if version >= 3 && br.uvarint() == 1 {
+		a.EWMA = &EWMAState{Sum: br.float(), Weight: br.float(), At: br.varint()}
+	}
+	if version >= 4 {
+		a.BigTotal = br.string(maxBinaryString)
+		a.KahanSum = br.float()
+		a.KahanC = br.float()
+		a.Saturated = br.uvarint() == 1
+	}
+	return a
+}
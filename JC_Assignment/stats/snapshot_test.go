@@ -0,0 +1,172 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+//helper to fill stats with a few actions and a spread of times
+func makeSnapshotStats(opts ...Option) *Stats {
+	st := NewStats(opts...)
+	for i := 1; i <= 100; i++ {
+		st.addAction(Sample{Action: "jump", Time: uint64(i * 10)})
+		st.addAction(Sample{Action: fmt.Sprintf("Action%d", i%7), Time: uint64(i)})
+	}
+	st.addAction(Sample{Action: "run", Time: 75})
+	return &st
+}
+
+//a snapshot restored into new stats must give back the same state in both formats
+func TestSnapshotRestore(t *testing.T) {
+	for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+		t.Run(fmt.Sprintf("format %d", format), func(t *testing.T) {
+			st := makeSnapshotStats(WithSnapshotFormat(format))
+			var buf bytes.Buffer
+			if err := st.Snapshot(&buf); err != nil {
+				t.Fatalf("error from snapshot %s", err.Error())
+			}
+
+			restored := NewStats()
+			if err := restored.Restore(&buf); err != nil {
+				t.Fatalf("error from restore %s", err.Error())
+			}
+			if !reflect.DeepEqual(st.State(), restored.State()) {
+				t.Fatalf("restored state differs\nhave %+v\nwant %+v", restored.State(), st.State())
+			}
+
+			//new samples keep accumulating on top of the restored totals
+			restored.addAction(Sample{Action: "run", Time: 25})
+			run, _ := restored.Lookup("run")
+			if run.NumSamples != 2 || run.TotalTime != 100 || run.Min != 25 {
+				t.Fatalf("wrong totals after restore %+v", run)
+			}
+		})
+	}
+}
+
+//the binary encoding is meant to be the compact one
+func TestSnapshot_BinaryIsSmaller(t *testing.T) {
+	var jsonBuf, binaryBuf bytes.Buffer
+	makeSnapshotStats().Snapshot(&jsonBuf)
+	makeSnapshotStats(WithSnapshotFormat(SnapshotBinary)).Snapshot(&binaryBuf)
+	if binaryBuf.Len() >= jsonBuf.Len() {
+		t.Fatalf("binary snapshot is %d bytes and json is %d", binaryBuf.Len(), jsonBuf.Len())
+	}
+}
+
+//restore replaces the actions that were there before
+func TestRestore_Replaces(t *testing.T) {
+	var buf bytes.Buffer
+	makeSnapshotStats().Snapshot(&buf)
+
+	st := NewStats()
+	st.addAction(Sample{Action: "swim", Time: 1})
+	st.addAction(Sample{Action: "jump", Time: 1})
+	if err := st.Restore(&buf); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, ok := st.Lookup("swim"); ok {
+		t.Error("swim should have been replaced")
+	}
+	jump, _ := st.Lookup("jump")
+	if jump.NumSamples != 100 {
+		t.Errorf("jump was not restored %+v", jump)
+	}
+}
+
+//windows come back when the resolution matches, and are dropped when it does not
+func TestSnapshotRestore_Window(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	st := NewStats(WithWindow(time.Minute, time.Second), WithClock(clock))
+	st.addAction(Sample{Action: "jump", Time: 100})
+	clock.Advance(10 * time.Second)
+	st.addAction(Sample{Action: "jump", Time: 300})
+
+	var buf bytes.Buffer
+	st.Snapshot(&buf)
+	snapshot := buf.String()
+
+	same := NewStats(WithWindow(time.Minute, time.Second), WithClock(clock))
+	if err := same.Restore(strings.NewReader(snapshot)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if have := windowAverages(t, &same, 5*time.Second); have["jump"] != 300 {
+		t.Errorf("wrong restored 5s window %v", have)
+	}
+	if have := windowAverages(t, &same, time.Minute); have["jump"] != 200 {
+		t.Errorf("wrong restored 1m window %v", have)
+	}
+
+	other := NewStats(WithWindow(time.Minute, 10*time.Second), WithClock(clock))
+	if err := other.Restore(strings.NewReader(snapshot)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if have := windowAverages(t, &other, time.Minute); len(have) != 0 {
+		t.Errorf("windows at another resolution should be dropped %v", have)
+	}
+}
+
+//bad snapshots are rejected and leave the stats untouched
+func TestRestore_Invalid(t *testing.T) {
+	var binaryBuf bytes.Buffer
+	makeSnapshotStats(WithSnapshotFormat(SnapshotBinary)).Snapshot(&binaryBuf)
+	truncated := binaryBuf.Bytes()[:binaryBuf.Len()/2]
+
+	futureVersion, _ := json.Marshal(State{Version: snapshotVersion + 1})
+	empty, _ := json.Marshal(State{Version: snapshotVersion, Averages: map[string]ActionState{"jump": {}}})
+
+	for name, snapshot := range map[string][]byte{
+		"bad json":       []byte("{wd;;;]}"),
+		"empty":          {},
+		"truncated":      truncated,
+		"future version": futureVersion,
+		"no samples":     empty,
+	} {
+		st := NewStats()
+		st.addAction(Sample{Action: "swim", Time: 1})
+		if err := st.Restore(bytes.NewReader(snapshot)); err == nil {
+			t.Errorf("%s: accepted a bad snapshot", name)
+		}
+		if _, ok := st.Lookup("swim"); !ok {
+			t.Errorf("%s: a bad snapshot changed the stats", name)
+		}
+	}
+}
+
+//a length in a small bad snapshot cannot make restoring it allocate more than the snapshot holds
+func TestRestore_HugeLengths(t *testing.T) {
+	//the header of a binary snapshot with one action, followed by the length of its name
+	header := append([]byte(nil), snapshotMagic...)
+	header = appendUvarint(header, snapshotVersion)
+	//no window resolution or moving average, a varint 0 is the same byte as a uvarint 0
+	header = appendUvarint(header, 0)
+	header = appendUvarint(header, 0)
+	header = appendUvarint(header, uint64(AccumulateExact))
+	header = appendUvarint(header, 1)
+
+	for name, length := range map[string]uint64{
+		"past the limit": math.MaxInt32,
+		"at the limit":   maxBinaryString,
+	} {
+		snapshot := appendUvarint(append([]byte(nil), header...), length)
+		snapshot = append(snapshot, "jump"...)
+		st := NewStats()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := st.Restore(bytes.NewReader(snapshot))
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Errorf("%s: accepted a name longer than the snapshot", name)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("%s: allocated %d bytes for a %d byte snapshot", name, allocated, len(snapshot))
+		}
+	}
+}
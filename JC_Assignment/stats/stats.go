@@ -4,45 +4,124 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
-	"sync"
+	"math/big"
+	"time"
 )
 
 //Model for input object, has json names included
+//only action and time are required
 type Sample struct {
 	Action string `json:"action"`
 	Time   uint64 `json:"time"`
+	//when the sample was taken, used to place it in a sliding window. Defaults to when it is added
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	//unit of time, one of ns, us, ms or s. Samples of one action can mix units,
+	//a sample without one is in the unit of the action. Once an action has samples
+	//without a unit it cannot take samples with one
+	Unit string `json:"unit,omitempty"`
+	//optional dimensions such as host or region that GetStats can group by
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 //model for output object, has json names included
 type SampleAverage struct {
 	Action  string `json:"action"`
 	Average uint64 `json:"avg"`
+	//unit of avg, value and ewma, empty for actions without a unit
+	Unit string `json:"unit,omitempty"`
+	//the average without truncating, only set with InUnit
+	Value float64 `json:"value,omitempty"`
+	//the average as a Go duration string such as 1.5ms, only set with AsDuration
+	Duration string `json:"duration,omitempty"`
+	//moving average, only set when enabled with WithEWMA
+	EWMA float64 `json:"ewma,omitempty"`
+	//the label values of the group, only set when grouping by a label
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 //internal model for calculating average
 type Average struct {
 	NumSamples uint64 `json:"numSamples"`
 	TotalTime  uint64 `json:"totalTime"`
+	Min        uint64 `json:"min"`
+	Max        uint64 `json:"max"`
+	//first unit declared by the samples, empty if none declared one.
+	//Once an action has a unit its times are kept in nanoseconds
+	Unit string `json:"unit,omitempty"`
+	//running mean and sum of squared differences for the variance
+	mean float64
+	m2   float64
+	//bounded sketch of the times for percentiles
+	hist histogram
+	//recent totals, only kept when sliding windows are enabled
+	window *window
+	//recency weighted average, only kept when moving averages are enabled
+	ewma *ewma
+	//totals by label name and value, only kept for samples with labels
+	labels map[string]map[string]*labelTotals
+	//exact total once it no longer fits in TotalTime, only with AccumulateBig
+	bigTotal *big.Int
+	//compensated sum kept alongside TotalTime with AccumulateKahan,
+	//saturated is set once TotalTime has stopped at math.MaxUint64 and the sum is the total
+	kahanSum  float64
+	kahanC    float64
+	saturated bool
 }
 
 //primary struct for use in calculating averages. SS
+//copies of the struct share the same underlying shards
 type Stats struct {
+	//every action by name while the averages are kept in memory, nil WithStorage.
+	//It is only safe to read while no samples are being added.
+	//
+	//Deprecated: use Lookup for one action or GetAverages for all of them, which are safe
+	//to call while samples are being added and work with every storage
 	Averages map[string]*Average
-	mu       sync.Mutex
+	shards   []*shard
+	//keeps Averages up to date, nil when it is not kept
+	index *averagesIndex
+	//cap on the actions of every shard, nil without WithMaxActions
+	limit *actionLimit
+	subs  *subscribers
+	wal   *walRef
+	options
 }
 
-//creates new stats struct
-func NewStats() Stats {
-	return Stats{
-		Averages: make(map[string]*Average),
+//creates new stats struct, options can enable optional features
+func NewStats(opts ...Option) Stats {
+	o := options{
+		clock:     systemClock{},
+		numShards: defaultShards,
 	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	storage := o.storage
+	var index *averagesIndex
+	if storage == nil {
+		index = newAveragesIndex()
+		storage = mapStorage{all: index}
+	}
+	s := Stats{
+		shards:  newShards(o.numShards, storage),
+		index:   index,
+		limit:   newActionLimit(o.maxActions, o.evictionPolicy),
+		subs:    &subscribers{},
+		wal:     &walRef{},
+		options: o,
+	}
+	if index != nil {
+		s.Averages = index.averages
+	}
+	s.limitShards()
+	return s
 }
 
-//returns all of the averages as a json array
-func (s *Stats) GetStats() (string, error) {
+//returns all of the averages as a json array sorted by action name.
+//Query options such as GroupBy, SortBy, Limit and Prefix change what is returned
+func (s *Stats) GetStats(opts ...QueryOption) (string, error) {
 	//get the slice from the stats struct
-	sliceAvg, err := s.getSampleAverageSlice()
+	sliceAvg, err := s.getSampleAverageSlice(opts...)
 	if err != nil {
 		return "", err
 	}
@@ -51,67 +130,174 @@ func (s *Stats) GetStats() (string, error) {
 	return string(jsonString), err
 }
 
+//same as GetStats but returns the averages without marshalling them
+func (s *Stats) GetAverages(opts ...QueryOption) ([]SampleAverage, error) {
+	return s.getSampleAverageSlice(opts...)
+}
+
 //traverses the stats map, calulates the averages and returns them as an array
-func (s *Stats) getSampleAverageSlice() (AveragesSlice []SampleAverage, errorReturn error) {
+func (s *Stats) getSampleAverageSlice(opts ...QueryOption) (AveragesSlice []SampleAverage, errorReturn error) {
 	//catch any panics
 	defer func() {
 		if r := recover(); r != nil {
 			errorReturn = errors.New("error while getting stats")
 		}
 	}()
-	//make the slice to return
-	AveragesSlice = make([]SampleAverage, 0)
-
-	//thread safety
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	q := newQuery(opts)
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	//make the slice of rows to sort
+	rows := make([]statsRow, 0)
 
 	//range Averages to calculate Real Average and add to slice for return
-	for action, average := range s.Averages {
-		sampleAverage := SampleAverage{
-			Action:  action,
-			Average: average.TotalTime / average.NumSamples,
+	//all shards are locked so the slice is a consistent snapshot
+	rollups := make(map[rollupKey]*rollup)
+	err := s.rangeAverages(func(action string, average *Average) {
+		if !q.matches(action) {
+			return
+		}
+		if q.depth > 0 {
+			q.addRollup(rollups, action, average)
+			return
+		}
+		if q.groupBy != "" {
+			rows = append(rows, average.groupedAverages(action, &q)...)
+			return
 		}
-		AveragesSlice = append(AveragesSlice, sampleAverage)
+		row := q.row(action, average.Unit, average.AverageTime(), average.averageFloat(), average.NumSamples)
+		if average.ewma != nil {
+			row.average.EWMA = average.ewma.value() / float64(unitNanos(row.average.Unit))
+		}
+		rows = append(rows, row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rollups {
+		rows = append(rows, r.row(&q))
 	}
-	return AveragesSlice, errorReturn
+	//sorting and paging happen outside of the locks
+	return q.apply(rows), errorReturn
 }
 
-//adds the json sample to the stats struct
+//adds the json sample to the stats struct.
+//A rejected sample returns an error wrapping one of the Err values such as ErrInvalidJSON
 func (s *Stats) AddAction(sampleString string) error {
 	//unmarshall the string into struct
-	var sample Sample
-	err := json.Unmarshal([]byte(sampleString), &sample)
+	sample, err := s.decodeSample([]byte(sampleString))
 	if err != nil {
-		return errors.New("JSON String is invalid-> " + err.Error())
+		return err
 	}
 	// adds to the struct
-	s.addAction(sample)
-	return err
+	return s.addAction(sample)
+}
+
+//adds a typed sample to the stats struct
+func (s *Stats) AddSample(sample Sample) error {
+	return s.addAction(sample)
 }
 
 //takes the sample and adds to the average struct of the corresponding action
 // creates new action in stats if non is available
 func (s *Stats) addAction(sample Sample) error {
-	//The entire func is thread safe
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if err := s.validate(sample); err != nil {
+		return err
+	}
+	//The entire func is thread safe, only the shard owning the action is locked
+	wal, err := s.addToShard(sample)
+	//the overflow action can be in another shard, so it is added once this one is unlocked
+	if err == errActionsFull {
+		err = s.addOverflow(sample)
+	}
+	if syncErr := wal.syncAdd(); err == nil {
+		err = syncErr
+	}
+	return err
+}
 
-	//action does not exist, make a new one
-	if s.Averages[sample.Action] == nil {
-		s.Averages[sample.Action] = &Average{
-			NumSamples: 1,
-			TotalTime:  sample.Time,
+//adds the sample to the shard that owns its action, the shard must already be locked
+//and the sample already validated
+func (s *Stats) addLocked(sh *shard, sample Sample) error {
+	if sh.err != nil {
+		return sh.err
+	}
+	average, err := sh.store.Get(sample.Action)
+	if err != nil {
+		return err
+	}
+	created := average == nil
+	//the unit of samples already added without one is unknown, so they cannot be converted
+	if !created && average.Unit == "" && sample.Unit != "" {
+		return fmt.Errorf("%w-> %s has samples without a unit, the sample is in %s", ErrUnitMismatch, sample.Action, sample.Unit)
+	}
+	//times are kept in nanoseconds, a sample without a unit is in the unit of the action
+	unit := sample.Unit
+	if unit == "" && !created {
+		unit = average.Unit
+	}
+	nanos, ok := toNanos(sample.Time, unitNanos(unit))
+	if !ok {
+		return fmt.Errorf("%w-> %d%s does not fit in nanoseconds", ErrTimeOutOfRange, sample.Time, unit)
+	}
+	if err := s.validation.timeInRange(sample.Time, unit, nanos); err != nil {
+		return err
+	}
+	//a new action takes a slot under WithMaxActions. Nothing is logged until an action is evicted
+	//to make room, but a rejected sample is, so a replay rejects it the same way
+	var full error
+	if created {
+		full = sh.reserve(sample.Action)
+		if full == errNoRoom {
+			return full
+		}
+	}
+	//logged before anything changes so replaying the log adds it the same way
+	if wal := s.wal.log; wal != nil {
+		if sample.Timestamp.IsZero() && (s.windowed() || s.ewmaEnabled()) {
+			sample.Timestamp = s.clock.Now()
+		}
+		if err := wal.appendSample(sample); err != nil {
+			if created && full == nil {
+				sh.release(sample.Action)
+			}
+			return err
 		}
+	}
+	if full != nil {
+		return full
+	}
+	sample.Time = nanos
+	//action does not exist, make a new one
+	if created {
+		average = &Average{Unit: sample.Unit}
+		//a new action starts from zero so its total cannot overflow
+		average.accumulate(sample.Time, s.accumulation)
 	} else {
-		//check uint64 overflow
-		if math.MaxUint64-s.Averages[sample.Action].TotalTime < sample.Time {
-			return fmt.Errorf("adding Sample with time %d will overflow unint64 with current time total for %s as %d", sample.Time, sample.Action, s.Averages[sample.Action].TotalTime)
+		//increment time and samples, only refused on uint64 overflow with AccumulateExact
+		if !average.accumulate(sample.Time, s.accumulation) {
+			err := fmt.Errorf("%w-> adding Sample with time %d will overflow unint64 with current time total for %s as %d", ErrOverflow, sample.Time, sample.Action, average.TotalTime)
+			s.publish(Event{Kind: EventOverflow, Action: sample.Action, Err: err})
+			return err
 		}
-
-		//increment time and samples
-		s.Averages[sample.Action].TotalTime += sample.Time
-		s.Averages[sample.Action].NumSamples += 1
 	}
+	average.observe(sample.Time)
+	average.observeLabels(sample)
+	s.observeWindow(average, sample)
+	s.observeEWMA(average, sample)
+	if err := sh.store.Put(sample.Action, average); err != nil {
+		if created {
+			sh.release(sample.Action)
+		}
+		return err
+	}
+	if created {
+		sh.markPopulated()
+		sh.track(sample.Action, average)
+		s.publish(Event{Kind: EventNewAction, Action: sample.Action})
+	} else {
+		sh.touch(sample.Action, average)
+	}
+	s.checkThresholds(sh, sample.Action, average)
 	return nil
 }
@@ -4,180 +4,243 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"path/filepath"
 	"testing"
 )
 
-//test basic functionality from strings
-func TestAddAction(t *testing.T) {
-	//Arrange
-	st := NewStats()
-	call1 := "{\"action\":\"jump\",\"time\":100}"
-	call2 := "{\"action\":\"run\", \"time\":75}"
-	call3 := "{\"action\":\"jump\", \"time\":200}"
-	//Act
-	err1 := st.AddAction(call1)
-	err2 := st.AddAction(call2)
-	err3 := st.AddAction(call3)
-	//Assert
-
-	if err1 != nil {
-		t.Error(err1.Error())
-	}
-	if err2 != nil {
-		t.Error(err2.Error())
+//every storage the stats can keep their averages in, the tests and benchmarks run against each.
+//A nil storage keeps them in memory
+var testStorages = []struct {
+	name       string
+	newStorage func(tb testing.TB) Storage
+}{
+	{"memory", func(tb testing.TB) Storage { return nil }},
+	{"bolt", func(tb testing.TB) Storage { return openBolt(tb, filepath.Join(tb.TempDir(), "stats.db")) }},
+}
+
+//runs test once for every storage, newStats creates stats kept in that storage
+func forEachStorage(t *testing.T, test func(t *testing.T, newStats func(opts ...Option) Stats)) {
+	for _, storage := range testStorages {
+		storage := storage
+		t.Run(storage.name, func(t *testing.T) {
+			test(t, func(opts ...Option) Stats {
+				return NewStats(append([]Option{WithStorage(storage.newStorage(t))}, opts...)...)
+			})
+		})
 	}
-	if err3 != nil {
-		t.Error(err3.Error())
+}
+
+//runs bench once for every storage, newStats creates stats kept in that storage
+func benchEachStorage(b *testing.B, bench func(b *testing.B, newStats func(opts ...Option) Stats)) {
+	for _, storage := range testStorages {
+		storage := storage
+		b.Run(storage.name, func(b *testing.B) {
+			bench(b, func(opts ...Option) Stats {
+				return NewStats(append([]Option{WithStorage(storage.newStorage(b))}, opts...)...)
+			})
+		})
 	}
+}
 
-	//since the ordering of the items is unimportant, we should marshal
-	//to ensure we are validating the values not the exact string.
-	want := "[{\"action\":\"jump\",\"avg\":150},{\"action\":\"run\",\"avg\":75}]"
+//test basic functionality from strings
+func TestAddAction(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, newStats func(opts ...Option) Stats) {
+		//Arrange
+		st := newStats()
+		call1 := "{\"action\":\"jump\",\"time\":100}"
+		call2 := "{\"action\":\"run\", \"time\":75}"
+		call3 := "{\"action\":\"jump\", \"time\":200}"
+		//Act
+		err1 := st.AddAction(call1)
+		err2 := st.AddAction(call2)
+		err3 := st.AddAction(call3)
+		//Assert
+
+		if err1 != nil {
+			t.Error(err1.Error())
+		}
+		if err2 != nil {
+			t.Error(err2.Error())
+		}
+		if err3 != nil {
+			t.Error(err3.Error())
+		}
 
-	wantStruct := make([]SampleAverage, 2)
-	json.Unmarshal([]byte(want), &wantStruct)
+		//since the ordering of the items is unimportant, we should marshal
+		//to ensure we are validating the values not the exact string.
+		want := "[{\"action\":\"jump\",\"avg\":150},{\"action\":\"run\",\"avg\":75}]"
 
-	statsJson, err := st.GetStats()
-	if err != nil {
-		t.Errorf("error from get stats %s", err.Error())
-	}
-	haveStruct := make([]SampleAverage, 2)
-	json.Unmarshal([]byte(statsJson), &haveStruct)
-
-	//Terribly inneficient loop, but only for 4 passes
-	for _, w := range wantStruct {
-		for _, h := range haveStruct {
-			if w.Action == h.Action {
-				if w.Average != h.Average {
-					t.Errorf("action of %s has wrong average %d want %d", w.Action, h.Average, w.Average)
+		wantStruct := make([]SampleAverage, 2)
+		json.Unmarshal([]byte(want), &wantStruct)
+
+		statsJson, err := st.GetStats()
+		if err != nil {
+			t.Errorf("error from get stats %s", err.Error())
+		}
+		haveStruct := make([]SampleAverage, 2)
+		json.Unmarshal([]byte(statsJson), &haveStruct)
+
+		//Terribly inneficient loop, but only for 4 passes
+		for _, w := range wantStruct {
+			for _, h := range haveStruct {
+				if w.Action == h.Action {
+					if w.Average != h.Average {
+						t.Errorf("action of %s has wrong average %d want %d", w.Action, h.Average, w.Average)
+					}
 				}
 			}
 		}
-	}
-
+	})
 }
 
 //test basic funcitonality from the core code
 func TestAddAction_Sample(t *testing.T) {
-	//Arrange
-	st := NewStats()
-	call1 := Sample{
-		Action: "jump",
-		Time:   1,
-	}
-	call2 := Sample{
-		Action: "run",
-		Time:   0,
-	}
-	call3 := Sample{
-		Action: "jump",
-		Time:   3,
-	}
+	forEachStorage(t, func(t *testing.T, newStats func(opts ...Option) Stats) {
+		//Arrange
+		st := newStats()
+		call1 := Sample{
+			Action: "jump",
+			Time:   1,
+		}
+		call2 := Sample{
+			Action: "run",
+			Time:   0,
+		}
+		call3 := Sample{
+			Action: "jump",
+			Time:   3,
+		}
 
-	//Act
-	st.addAction(call1)
-	st.addAction(call2)
-	st.addAction(call3)
+		//Act
+		st.addAction(call1)
+		st.addAction(call2)
+		st.addAction(call3)
 
-	//Assert
-	expectedTotalJumpTime := uint64(4)
-	foundTotalJumpTime := st.Averages["jump"].TotalTime
-	if foundTotalJumpTime != expectedTotalJumpTime {
-		t.Fatalf("TotalTime calculation incorrect, expected %d but found %d", foundTotalJumpTime, expectedTotalJumpTime)
-	}
+		//Assert
+		//Averages is only kept in memory, Lookup works with every storage
+		jump, _ := st.Lookup("jump")
+		run, _ := st.Lookup("run")
 
-	expectedTotalRunTime := uint64(0)
-	fountTotalRunTime := st.Averages["run"].TotalTime
-	if fountTotalRunTime != expectedTotalRunTime {
-		t.Fatalf("TotalTime calculation incorrect, expected %d but found %d", fountTotalRunTime, expectedTotalRunTime)
-	}
+		expectedTotalJumpTime := uint64(4)
+		foundTotalJumpTime := jump.TotalTime
+		if foundTotalJumpTime != expectedTotalJumpTime {
+			t.Fatalf("TotalTime calculation incorrect, expected %d but found %d", foundTotalJumpTime, expectedTotalJumpTime)
+		}
 
-	expectedJumpCount := uint64(2)
-	foundJumpCount := st.Averages["jump"].NumSamples
-	if foundJumpCount != expectedJumpCount {
-		t.Fatalf("Number of Samples calculation is incorrect, expected %d but found %d", expectedJumpCount, foundJumpCount)
-	}
+		expectedTotalRunTime := uint64(0)
+		fountTotalRunTime := run.TotalTime
+		if fountTotalRunTime != expectedTotalRunTime {
+			t.Fatalf("TotalTime calculation incorrect, expected %d but found %d", fountTotalRunTime, expectedTotalRunTime)
+		}
 
-	expectedRunCount := uint64(2)
-	foundRunCount := st.Averages["jump"].NumSamples
-	if foundRunCount != expectedRunCount {
-		t.Fatalf("Number of Samples calculation is incorrect, expected %d but found %d", expectedRunCount, foundRunCount)
-	}
+		expectedJumpCount := uint64(2)
+		foundJumpCount := jump.NumSamples
+		if foundJumpCount != expectedJumpCount {
+			t.Fatalf("Number of Samples calculation is incorrect, expected %d but found %d", expectedJumpCount, foundJumpCount)
+		}
+
+		expectedRunCount := uint64(2)
+		foundRunCount := jump.NumSamples
+		if foundRunCount != expectedRunCount {
+			t.Fatalf("Number of Samples calculation is incorrect, expected %d but found %d", expectedRunCount, foundRunCount)
+		}
+	})
 }
 
 //test concurrecny
 func TestAddAction_Concurrent(t *testing.T) {
-	st := NewStats()
+	forEachStorage(t, func(t *testing.T, newStats func(opts ...Option) Stats) {
+		st := newStats()
 
-	for i := 0; i < 10; i++ {
+		for i := 0; i < 10; i++ {
 
-		call1 := Sample{
-			Action: "jump",
-			Time:   10,
-		}
-		jsonString, _ := json.Marshal(call1)
-		t.Run(fmt.Sprintf("Concurrent Test %d", i), func(t *testing.T) {
-			t.Parallel()
-			addErr := st.AddAction(string(jsonString))
-			if addErr != nil {
-				t.Error(addErr.Error())
-			}
-			statsJson, geterr := st.GetStats()
-			if geterr != nil {
-				t.Error(geterr.Error())
+			call1 := Sample{
+				Action: "jump",
+				Time:   10,
 			}
-			haveStruct := make([]SampleAverage, 1)
-			json.Unmarshal([]byte(statsJson), &haveStruct)
+			jsonString, _ := json.Marshal(call1)
+			t.Run(fmt.Sprintf("Concurrent Test %d", i), func(t *testing.T) {
+				t.Parallel()
+				addErr := st.AddAction(string(jsonString))
+				if addErr != nil {
+					t.Error(addErr.Error())
+				}
+				statsJson, geterr := st.GetStats()
+				if geterr != nil {
+					t.Error(geterr.Error())
+				}
+				haveStruct := make([]SampleAverage, 1)
+				json.Unmarshal([]byte(statsJson), &haveStruct)
 
-			if haveStruct[0].Average != 10 {
-				t.Fatalf("Concurrency error!")
-			}
+				if haveStruct[0].Average != 10 {
+					t.Fatalf("Concurrency error!")
+				}
 
-		})
-	}
+			})
+		}
+	})
 }
 
 //test some edge cases, confirm error is thrown after bad json is passed
 func TestAddAction_BadJson(t *testing.T) {
-	badJson := "{wd;;;]}"
-	st := NewStats()
+	forEachStorage(t, func(t *testing.T, newStats func(opts ...Option) Stats) {
+		badJson := "{wd;;;]}"
+		st := newStats()
 
-	err := st.AddAction(badJson)
+		err := st.AddAction(badJson)
 
-	if err == nil {
-		t.Fatal("Accepted Bad JSON!")
-	}
+		if err == nil {
+			t.Fatal("Accepted Bad JSON!")
+		}
+	})
 }
 
 //verify we get an error when the numbers get too big
 func TestAddAction_IntOverflow(t *testing.T) {
-	st := NewStats()
+	forEachStorage(t, func(t *testing.T, newStats func(opts ...Option) Stats) {
+		st := newStats()
 
-	call1 := Sample{
-		Action: "jump",
-		Time:   math.MaxUint64,
-	}
-	call2 := Sample{
-		Action: "jump",
-		Time:   uint64(1),
-	}
-	err1 := st.addAction(call1)
-	err2 := st.addAction(call2)
+		call1 := Sample{
+			Action: "jump",
+			Time:   math.MaxUint64,
+		}
+		call2 := Sample{
+			Action: "jump",
+			Time:   uint64(1),
+		}
+		err1 := st.addAction(call1)
+		err2 := st.addAction(call2)
 
-	if err1 != nil {
-		t.Fatalf("Cannot Add uint64 max as a time")
-	}
-	if err2 == nil {
-		t.Fatalf("TotalTime for jump exceeded maxuint64")
-	}
+		if err1 != nil {
+			t.Fatalf("Cannot Add uint64 max as a time")
+		}
+		if err2 == nil {
+			t.Fatalf("TotalTime for jump exceeded maxuint64")
+		}
+
+		//the other accumulation strategies keep going and keep the average
+		for _, accumulation := range []Accumulation{AccumulateBig, AccumulateKahan, AccumulateRescale} {
+			st := newStats(WithAccumulation(accumulation))
+			for i := 0; i < 4; i++ {
+				if err := st.addAction(Sample{Action: "jump", Time: math.MaxUint64 - 1}); err != nil {
+					t.Fatalf("accumulation %d refused a sample %s", accumulation, err.Error())
+				}
+			}
+			//a float64 near 2^64 is only accurate to 4096
+			jump, _ := st.Lookup("jump")
+			if have := jump.AverageTime(); have != math.MaxUint64-1 && (accumulation != AccumulateKahan || have < math.MaxUint64-4096) {
+				t.Errorf("accumulation %d has average %d", accumulation, have)
+			}
+		}
+	})
 }
 
 //=====================Benchmark Tests====================//
 
 //helper to make new stats with numActions number of unique actions
 //used by most tests to show complexity increase for unique actions
-func makeStatsWithUniqueActions(numActions int) (st Stats) {
-	st = NewStats()
+func makeStatsWithUniqueActions(newStats func(opts ...Option) Stats, numActions int) *Stats {
+	st := newStats()
 	for i := 0; i < numActions; i++ {
 		actionName := fmt.Sprintf("Action%d", i)
 		sample := Sample{
@@ -186,13 +249,13 @@ func makeStatsWithUniqueActions(numActions int) (st Stats) {
 		}
 		st.addAction(sample)
 	}
-	return st
+	return &st
 }
 
 //helper to make stats with numActions entries into a single action
 //used by XX_highvolume and XX_lowvolume tests to show complexity in terms of unique actions
-func makeStatsOneAction(numActions int) (st Stats) {
-	st = NewStats()
+func makeStatsOneAction(newStats func(opts ...Option) Stats, numActions int) *Stats {
+	st := newStats()
 	actionName := "action"
 	for i := 0; i < numActions; i++ {
 		sample := Sample{
@@ -201,161 +264,183 @@ func makeStatsOneAction(numActions int) (st Stats) {
 		}
 		st.addAction(sample)
 	}
-	return st
+	return &st
 }
 
 //get benchmarks from get stats with only 10 unique actions
 func BenchmarkGetStatsSmall(b *testing.B) {
-	numActions := 10
-	st := makeStatsWithUniqueActions(numActions)
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		st.GetStats()
-	}
-
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 10
+		st := makeStatsWithUniqueActions(newStats, numActions)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.GetStats()
+		}
+	})
 }
 
 //benchmark get stats with 1,000,000 unique actions
 func BenchmarkGetStatsMega(b *testing.B) {
-	numActions := 1000000
-	st := makeStatsWithUniqueActions(numActions)
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		st.GetStats()
-	}
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 1000000
+		st := makeStatsWithUniqueActions(newStats, numActions)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.GetStats()
+		}
+	})
 }
 
 //benchmark get stats with 10 unique records to underlying call, not JSON
 func BenchmarkGetStatsSmall_direct(b *testing.B) {
-	st := makeStatsWithUniqueActions(10)
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		st.getSampleAverageSlice()
-	}
-
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		st := makeStatsWithUniqueActions(newStats, 10)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.getSampleAverageSlice()
+		}
+	})
 }
 
 //benchmark get stats with 1,000,000 unique records to underlying call, not JSON
 func BenchmarkGetStatsMega_direct(b *testing.B) {
-	numActions := 1000000
-	st := makeStatsWithUniqueActions(numActions)
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		st.getSampleAverageSlice()
-	}
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 1000000
+		st := makeStatsWithUniqueActions(newStats, numActions)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.getSampleAverageSlice()
+		}
+	})
 }
 
 //benchmark add action with 1,000,000 unique actions
 func BenchmarkAddActionMega(b *testing.B) {
-	numActions := 1000000
-	st := makeStatsWithUniqueActions(numActions)
-	actionName := "action"
-	sample := Sample{
-		Action: actionName,
-		Time:   math.MaxUint64,
-	}
-	var jsonString []byte
-	jsonString, _ = json.Marshal(sample)
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 1000000
+		st := makeStatsWithUniqueActions(newStats, numActions)
+		actionName := "action"
+		sample := Sample{
+			Action: actionName,
+			Time:   math.MaxUint64,
+		}
+		var jsonString []byte
+		jsonString, _ = json.Marshal(sample)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
 
-		st.AddAction(string(jsonString))
-	}
+			st.AddAction(string(jsonString))
+		}
+	})
 }
 
 //benchmark add action with 10 unique actions
 func BenchmarkAddActionSmall(b *testing.B) {
-	numActions := 10
-	st := makeStatsWithUniqueActions(numActions)
-	actionName := "action"
-	sample := Sample{
-		Action: actionName,
-		Time:   math.MaxUint64,
-	}
-	var jsonString []byte
-	jsonString, _ = json.Marshal(sample)
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 10
+		st := makeStatsWithUniqueActions(newStats, numActions)
+		actionName := "action"
+		sample := Sample{
+			Action: actionName,
+			Time:   math.MaxUint64,
+		}
+		var jsonString []byte
+		jsonString, _ = json.Marshal(sample)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
 
-		st.AddAction(string(jsonString))
-	}
+			st.AddAction(string(jsonString))
+		}
+	})
 }
 
 // benchmark add action underlying call with 1,000,000 unique actions
 func BenchmarkAddActionMega_direct(b *testing.B) {
-	numActions := 1000000
-	st := makeStatsWithUniqueActions(numActions)
-	sample := Sample{
-		Action: "actionName",
-		Time:   math.MaxUint64,
-	}
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		st.addAction(sample)
-	}
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 1000000
+		st := makeStatsWithUniqueActions(newStats, numActions)
+		sample := Sample{
+			Action: "actionName",
+			Time:   math.MaxUint64,
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.addAction(sample)
+		}
+	})
 }
 
 //benchmark add action underlying call with 10 unique actions
 func BenchmarkAddActionSmall_direct(b *testing.B) {
-	numActions := 10
-	st := makeStatsWithUniqueActions(numActions)
-	sample := Sample{
-		Action: "actionName",
-		Time:   math.MaxUint64,
-	}
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 10
+		st := makeStatsWithUniqueActions(newStats, numActions)
+		sample := Sample{
+			Action: "actionName",
+			Time:   math.MaxUint64,
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
 
-		st.addAction(sample)
-	}
+			st.addAction(sample)
+		}
+	})
 }
 
 // The following tests demonstrate the constant complexity of operations on a sigle action
 
 //benchmark underlying getstats call with one unique action updated 1,000,000 times
 func BenchmarkGetStats_direct_highvolume(b *testing.B) {
-	numActions := 1000000
-	st := makeStatsOneAction(numActions)
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		st.getSampleAverageSlice()
-	}
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 1000000
+		st := makeStatsOneAction(newStats, numActions)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.getSampleAverageSlice()
+		}
+	})
 }
 
 //benchmark underlying getstats call with one unique action updated 10 times
 func BenchmarkGetStats_direct_lowvolume(b *testing.B) {
-	numActions := 10
-	st := makeStatsOneAction(numActions)
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		st.getSampleAverageSlice()
-	}
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 10
+		st := makeStatsOneAction(newStats, numActions)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.getSampleAverageSlice()
+		}
+	})
 }
 
 //benchmark underlying addAction call with one unique action updated 1,000,000 times
 func BenchmarkAddAction_direct_highvolume(b *testing.B) {
-	numActions := 1000000
-	st := makeStatsOneAction(numActions)
-	sample := Sample{
-		Action: "action",
-		Time:   uint64(1),
-	}
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		st.addAction(sample)
-	}
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 1000000
+		st := makeStatsOneAction(newStats, numActions)
+		sample := Sample{
+			Action: "action",
+			Time:   uint64(1),
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.addAction(sample)
+		}
+	})
 }
 
 //benchmark underlying addAction call with one unique action updated 10 times
 func BenchmarkAddAction_direct_lowvolume(b *testing.B) {
-	numActions := 10
-	st := makeStatsOneAction(numActions)
-	sample := Sample{
-		Action: "action",
-		Time:   uint64(1),
-	}
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		st.addAction(sample)
-	}
+	benchEachStorage(b, func(b *testing.B, newStats func(opts ...Option) Stats) {
+		numActions := 10
+		st := makeStatsOneAction(newStats, numActions)
+		sample := Sample{
+			Action: "action",
+			Time:   uint64(1),
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st.addAction(sample)
+		}
+	})
 }
@@ -0,0 +1,213 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//keeps the averages of the actions in one shard. Every call is made with the shard locked,
+//so a store does not need its own locking for calls about one shard
+type Store interface {
+	//the average of action, nil if the action does not exist.
+	//Changes to the average are only kept once it is passed to Put
+	Get(action string) (*Average, error)
+	//adds or replaces the average of action
+	Put(action string, average *Average) error
+	//removes action, removing an action that does not exist is not an error
+	Delete(action string) error
+	//calls fn for every action, stopping at the first error fn returns
+	Range(fn func(action string, average *Average) error) error
+	//number of actions kept
+	Len() int
+	//removes every action
+	Clear() error
+}
+
+//creates the store of each shard, shard is the index of the shard out of shards
+type Storage interface {
+	Store(shard int, shards int) Store
+}
+
+//keeps the averages in a storage other than memory, e.g. WithStorage(OpenBoltStorage(path)).
+//Actions already in the storage are picked up as they are, so reopen it with the same options.
+//The storage keeps the samples itself, so OpenWAL cannot be used with it
+func WithStorage(storage Storage) Option {
+	return func(o *options) {
+		o.storage = storage
+	}
+}
+
+//keeps the averages in memory, the default.
+//Each shard has a map of its own, and every average is also listed in all for Stats.Averages
+type mapStorage struct {
+	all *averagesIndex
+}
+
+func (m mapStorage) Store(shard int, shards int) Store {
+	return &mapStore{averages: make(map[string]*Average), all: m.all}
+}
+
+//averages in a map. Get returns the average kept in the map, so changes are seen before Put
+type mapStore struct {
+	averages map[string]*Average
+	//where the averages are also listed, nil when they are not
+	all *averagesIndex
+}
+
+func (m *mapStore) Get(action string) (*Average, error) {
+	return m.averages[action], nil
+}
+
+func (m *mapStore) Put(action string, average *Average) error {
+	//the index only changes when the average is new, not on every add
+	if m.averages[action] != average {
+		m.averages[action] = average
+		m.all.set(action, average)
+	}
+	return nil
+}
+
+func (m *mapStore) Delete(action string) error {
+	if _, ok := m.averages[action]; ok {
+		delete(m.averages, action)
+		m.all.remove(action)
+	}
+	return nil
+}
+
+func (m *mapStore) Range(fn func(action string, average *Average) error) error {
+	for action, average := range m.averages {
+		if err := fn(action, average); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mapStore) Len() int {
+	return len(m.averages)
+}
+
+func (m *mapStore) Clear() error {
+	for action := range m.averages {
+		m.all.remove(action)
+	}
+	m.averages = make(map[string]*Average)
+	return nil
+}
+
+//copies every action of a store into a new map store listed in all
+func copyToMap(store Store, all *averagesIndex) (*mapStore, error) {
+	copied := &mapStore{averages: make(map[string]*Average, store.Len()), all: all}
+	err := store.Range(func(action string, average *Average) error {
+		c := average.clone()
+		return copied.Put(action, &c)
+	})
+	return copied, err
+}
+
+//the averages of every shard in one map, the map behind Stats.Averages.
+//Shards only lock it to add or remove an action, adding to an existing one does not touch it
+type averagesIndex struct {
+	mu       sync.Mutex
+	averages map[string]*Average
+}
+
+func newAveragesIndex() *averagesIndex {
+	return &averagesIndex{averages: make(map[string]*Average)}
+}
+
+func (i *averagesIndex) set(action string, average *Average) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	i.averages[action] = average
+	i.mu.Unlock()
+}
+
+func (i *averagesIndex) remove(action string) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	delete(i.averages, action)
+	i.mu.Unlock()
+}
+
+//moves every average into to, the map stays the same so copies of the stats see it empty
+func (i *averagesIndex) moveTo(to *averagesIndex) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for action, average := range i.averages {
+		to.averages[action] = average
+		delete(i.averages, action)
+	}
+}
+
+//=====================Binary Encoding====================//
+// version and the length of the window ring, then the action the same way a binary snapshot writes it
+
+//encodes the average so a store can keep it outside of memory
+func (a *Average) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	bw := &binaryWriter{w: bufio.NewWriter(&buf)}
+	bw.uvarint(snapshotVersion)
+	if a.window != nil {
+		bw.uvarint(uint64(len(a.window.buckets)))
+	} else {
+		bw.uvarint(0)
+	}
+	bw.actionState(a.state())
+	if bw.err != nil {
+		return nil, bw.err
+	}
+	if err := bw.w.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//decodes an average written by MarshalBinary
+func (a *Average) UnmarshalBinary(data []byte) error {
+	br := &binaryReader{r: bufio.NewReader(bytes.NewReader(data))}
+	version := int(br.uvarint())
+	if br.err == nil && !supportedVersion(version) {
+		return fmt.Errorf("unsupported average version %d", version)
+	}
+	ring := br.length(maxWindowBuckets)
+	st := br.actionState(version)
+	if br.err != nil {
+		return errors.New("average is invalid-> " + br.err.Error())
+	}
+	//the totals are read back the way they were kept
+	o := options{accumulation: AccumulateExact, ewmaHalfLife: time.Nanosecond}
+	switch {
+	case st.BigTotal != "":
+		o.accumulation = AccumulateBig
+	case st.KahanSum != 0 || st.KahanC != 0 || st.Saturated:
+		o.accumulation = AccumulateKahan
+	}
+	//the slot of a window bucket only depends on the length of the ring
+	if ring > 0 {
+		o.windowSpan, o.windowResolution = time.Duration(ring), time.Nanosecond
+	}
+	upgraded, err := State{Version: version, Accumulation: o.accumulation, Averages: map[string]ActionState{"": st}}.upgrade()
+	if err != nil {
+		return err
+	}
+	s := Stats{options: o}
+	decoded, err := s.averageFromState(upgraded.Averages[""], o.windowResolution, o.ewmaHalfLife)
+	if err != nil {
+		return errors.New("average is invalid-> " + err.Error())
+	}
+	*a = *decoded
+	return nil
+}
@@ -0,0 +1,311 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//opens a bbolt storage in a temp dir that is closed when the test ends
+func openBolt(t testing.TB, path string) *BoltStorage {
+	t.Helper()
+	storage, err := OpenBoltStorage(path, WithBoltNoSync())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+//a scenario run against every storage, returns what it saw so the storages can be compared
+type storageCase func(newStats func(opts ...Option) Stats) string
+
+//what the stats return, written out so two runs can be compared
+func transcript(values ...interface{}) string {
+	return fmt.Sprintln(values...)
+}
+
+var storageCases = map[string]storageCase{
+	"add": func(newStats func(opts ...Option) Stats) string {
+		st := newStats()
+		st.AddSample(Sample{Action: "jump", Time: 100, Unit: "ms", Labels: map[string]string{"host": "a"}})
+		st.AddSample(Sample{Action: "jump", Time: 300, Unit: "ms", Labels: map[string]string{"host": "b"}})
+		st.AddAction(`{"action":"run","time":75}`)
+		st.AddAction(`{"action":"run","time":25}`)
+		all, err := st.GetStats()
+		grouped, groupedErr := st.GetStats(GroupBy("host"))
+		distribution, distributionErr := st.GetDistribution()
+		jump, ok := st.Lookup("jump")
+		return transcript(all, err, grouped, groupedErr, distribution, distributionErr, jump.NumSamples, jump.TotalTime, ok)
+	},
+	"remove": func(newStats func(opts ...Option) Stats) string {
+		st := newStats()
+		st.AddSample(Sample{Action: "jump", Time: 100})
+		st.AddSample(Sample{Action: "run", Time: 50})
+		removed, missing := st.Remove("jump"), st.Remove("jump")
+		have, err := st.GetStats()
+		return transcript(removed, missing, have, err, st.Cardinality())
+	},
+	"evict": func(newStats func(opts ...Option) Stats) string {
+		st := newStats(WithShards(1), WithMaxActions(3, EvictLRU))
+		for i := 0; i < 5; i++ {
+			st.AddSample(Sample{Action: fmt.Sprintf("action%d", i), Time: uint64(i)})
+			st.AddSample(Sample{Action: "action0", Time: 10})
+		}
+		have, err := st.GetStats()
+		return transcript(have, err, st.Cardinality())
+	},
+	"reject": func(newStats func(opts ...Option) Stats) string {
+		st := newStats(WithShards(1), WithMaxActions(2, RejectNew))
+		for i := 0; i < 4; i++ {
+			st.AddSample(Sample{Action: fmt.Sprintf("action%d", i), Time: 10})
+		}
+		have, err := st.GetStats()
+		return transcript(have, err, st.Cardinality())
+	},
+	"swap": func(newStats func(opts ...Option) Stats) string {
+		st := newStats()
+		st.AddSample(Sample{Action: "jump", Time: 100})
+		previous := st.SwapAndReset()
+		st.AddSample(Sample{Action: "run", Time: 50})
+		before, beforeErr := previous.GetStats()
+		after, afterErr := st.GetStats()
+		return transcript(before, beforeErr, after, afterErr)
+	},
+	"snapshot": func(newStats func(opts ...Option) Stats) string {
+		st := newStats()
+		st.AddSample(Sample{Action: "jump", Time: 100, Labels: map[string]string{"host": "a"}})
+		st.AddSample(Sample{Action: "run", Time: 50})
+		var buf bytes.Buffer
+		err := st.Snapshot(&buf)
+		restored := newStats()
+		restored.AddSample(Sample{Action: "walk", Time: 1})
+		restoreErr := restored.Restore(&buf)
+		have, haveErr := restored.GetStats(GroupBy("host"))
+		return transcript(err, restoreErr, have, haveErr)
+	},
+	"merge": func(newStats func(opts ...Option) Stats) string {
+		st, other := newStats(), newStats()
+		st.AddSample(Sample{Action: "jump", Time: 100})
+		other.AddSample(Sample{Action: "jump", Time: 300})
+		other.AddSample(Sample{Action: "run", Time: 50})
+		err := st.Merge(&other)
+		have, haveErr := st.GetStats()
+		return transcript(err, have, haveErr)
+	},
+	"window": func(newStats func(opts ...Option) Stats) string {
+		clock := &fakeClock{now: time.Unix(1600000000, 0)}
+		st := newStats(WithWindow(time.Minute, time.Second), WithEWMA(time.Minute), WithClock(clock))
+		st.AddSample(Sample{Action: "jump", Time: 100})
+		clock.Advance(30 * time.Second)
+		st.AddSample(Sample{Action: "jump", Time: 300})
+		clock.Advance(45 * time.Second)
+		st.AddSample(Sample{Action: "jump", Time: 500})
+		window, err := st.GetStatsWindow(time.Minute)
+		ewma, ok := st.EWMA("jump")
+		return transcript(window, err, ewma, ok)
+	},
+	"thresholds": func(newStats func(opts ...Option) Stats) string {
+		st := newStats()
+		sub := st.Subscribe(10, Threshold{Action: "jump", Value: 150})
+		defer sub.Close()
+		for _, time := range []uint64{100, 300, 10, 10, 10} {
+			st.AddSample(Sample{Action: "jump", Time: time})
+		}
+		events := make([]Event, 0)
+		for len(sub.Events()) > 0 {
+			events = append(events, <-sub.Events())
+		}
+		return transcript(events)
+	},
+}
+
+//every storage keeps the same totals as the map for the scenarios the other tests do not cover
+func TestStorage_Conformance(t *testing.T) {
+	for _, storage := range testStorages {
+		for caseName, run := range storageCases {
+			storage, run := storage, run
+			t.Run(storage.name+"/"+caseName, func(t *testing.T) {
+				want := run(func(opts ...Option) Stats {
+					return NewStats(opts...)
+				})
+				have := run(func(opts ...Option) Stats {
+					return NewStats(append([]Option{WithStorage(storage.newStorage(t))}, opts...)...)
+				})
+				if have != want {
+					t.Errorf("storage differs from memory\nhave %s\nwant %s", have, want)
+				}
+			})
+		}
+	}
+}
+
+//a map store that cannot be read
+type unreadableStorage struct{}
+
+func (unreadableStorage) Store(shard int, shards int) Store {
+	return unreadableStore{&mapStore{averages: make(map[string]*Average)}}
+}
+
+type unreadableStore struct {
+	*mapStore
+}
+
+func (unreadableStore) Range(fn func(action string, average *Average) error) error {
+	return errors.New("unreadable")
+}
+
+//an error tracking the actions in the storage when the stats are created is returned later on
+func TestStore_TrackError(t *testing.T) {
+	st := NewStats(WithStorage(unreadableStorage{}), WithMaxActions(10, EvictLRU))
+	if err := st.AddSample(Sample{Action: "jump", Time: 100}); err == nil || !strings.Contains(err.Error(), "unreadable") {
+		t.Errorf("added to a storage that could not be tracked %v", err)
+	}
+	if _, err := st.GetStats(); err == nil {
+		t.Errorf("read a storage that could not be tracked")
+	}
+}
+
+//an average survives being written and read back
+func TestStore_MarshalBinary(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	st := NewStats(WithWindow(time.Minute, time.Second), WithEWMA(time.Minute), WithClock(clock), WithAccumulation(AccumulateBig))
+	st.AddSample(Sample{Action: "jump", Time: 100, Unit: "ms", Labels: map[string]string{"host": "a"}})
+	st.AddSample(Sample{Action: "jump", Time: 1 << 63, Unit: "ns"})
+	st.AddSample(Sample{Action: "jump", Time: 1 << 63, Unit: "ns"})
+	jump, _ := st.Lookup("jump")
+
+	data, err := jump.MarshalBinary()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var decoded Average
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err.Error())
+	}
+	if decoded.NumSamples != 3 || decoded.Unit != "ms" || decoded.totalString() != jump.totalString() || decoded.Max != 1<<63 {
+		t.Errorf("wrong totals\nhave %+v\nwant %+v", decoded, jump)
+	}
+	if decoded.window == nil || len(decoded.window.buckets) != 60 || decoded.ewma == nil || decoded.labels["host"]["a"].NumSamples != 1 {
+		t.Errorf("window, moving average or labels were lost %+v", decoded)
+	}
+	if decoded.quantile(0.5) != jump.quantile(0.5) {
+		t.Errorf("histogram was lost, p50 is %d, want %d", decoded.quantile(0.5), jump.quantile(0.5))
+	}
+
+	if err := decoded.UnmarshalBinary(data[:len(data)/2]); err == nil {
+		t.Errorf("decoded a truncated average")
+	}
+	//the length of the window ring is the first thing after the version, and is capped
+	huge := append(appendUvarint([]byte{data[0]}, 1<<32), data[2:]...)
+	if err := decoded.UnmarshalBinary(huge); err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Errorf("decoded a window ring of 2^32 buckets %v", err)
+	}
+}
+
+//actions kept in a bbolt file are still there after it is reopened, with a different number of shards
+func TestStore_BoltReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	storage := openBolt(t, path)
+	st := NewStats(WithStorage(storage), WithShards(8))
+	for i := 0; i < 100; i++ {
+		st.AddSample(Sample{Action: fmt.Sprintf("action%d", i), Time: uint64(i)})
+		st.AddSample(Sample{Action: fmt.Sprintf("action%d", i), Time: uint64(i) + 2})
+	}
+	st.Remove("action0")
+	want, _ := st.GetStats()
+	storage.Close()
+
+	storage = openBolt(t, path)
+	reopened := NewStats(WithStorage(storage), WithShards(3))
+	if have, _ := reopened.GetStats(); have != want {
+		t.Errorf("wrong stats after reopening\nhave %s\nwant %s", have, want)
+	}
+	if c := reopened.Cardinality(); c.Actions != 99 {
+		t.Errorf("wrong number of actions %d", c.Actions)
+	}
+	reopened.AddSample(Sample{Action: "action1", Time: 7})
+	if action1, _ := reopened.Lookup("action1"); action1.NumSamples != 3 || action1.TotalTime != 11 {
+		t.Errorf("wrong totals after adding to a reopened action %+v", action1)
+	}
+}
+
+//reopening with a lower cap evicts down to it
+func TestStore_BoltReopenMaxActions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	storage := openBolt(t, path)
+	st := NewStats(WithStorage(storage), WithShards(1))
+	for i := 0; i < 10; i++ {
+		for j := 0; j <= i; j++ {
+			st.AddSample(Sample{Action: fmt.Sprintf("action%d", i), Time: 1})
+		}
+	}
+	storage.Close()
+
+	storage = openBolt(t, path)
+	reopened := NewStats(WithStorage(storage), WithMaxActions(4, EvictLeastFrequent))
+	have, _ := reopened.GetStats()
+	want := `[{"action":"action6","avg":1},{"action":"action7","avg":1},{"action":"action8","avg":1},{"action":"action9","avg":1}]`
+	if have != want {
+		t.Errorf("wrong actions kept\nhave %s\nwant %s", have, want)
+	}
+	if c := reopened.Cardinality(); c.Actions != 4 || c.Evicted != 6 {
+		t.Errorf("wrong cardinality %+v", c)
+	}
+}
+
+//the interval SwapAndReset returns is copied into memory, and the storage starts over
+func TestStore_BoltSwapAndReset(t *testing.T) {
+	storage := openBolt(t, filepath.Join(t.TempDir(), "stats.db"))
+	st := NewStats(WithStorage(storage))
+	st.AddSample(Sample{Action: "jump", Time: 100})
+	previous := st.SwapAndReset()
+	st.AddSample(Sample{Action: "run", Time: 50})
+
+	if have, _ := previous.GetStats(); have != `[{"action":"jump","avg":100}]` {
+		t.Errorf("wrong previous interval %s", have)
+	}
+	if have, _ := st.GetStats(); have != `[{"action":"run","avg":50}]` {
+		t.Errorf("wrong current interval %s", have)
+	}
+}
+
+//a file that is already open cannot be opened a second time, it returns an error instead of waiting forever
+func TestStore_BoltOpenTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	openBolt(t, path)
+	started := time.Now()
+	storage, err := OpenBoltStorage(path, WithBoltTimeout(100*time.Millisecond))
+	if err == nil {
+		storage.Close()
+		t.Fatal("opened a file that is already open")
+	}
+	if !strings.Contains(err.Error(), "open in another process") {
+		t.Errorf("wrong error %s", err.Error())
+	}
+	if waited := time.Since(started); waited > 5*time.Second {
+		t.Errorf("waited %s for the file", waited)
+	}
+}
+
+//errors from the storage are returned instead of losing samples
+func TestStore_BoltClosed(t *testing.T) {
+	storage := openBolt(t, filepath.Join(t.TempDir(), "stats.db"))
+	st := NewStats(WithStorage(storage))
+	st.AddSample(Sample{Action: "jump", Time: 100})
+	storage.Close()
+
+	if err := st.AddSample(Sample{Action: "jump", Time: 100}); err == nil || !strings.Contains(err.Error(), "jump") {
+		t.Errorf("added to a closed storage %v", err)
+	}
+	if _, err := st.GetStats(); err == nil {
+		t.Errorf("read a closed storage")
+	}
+	if err := st.Snapshot(&strings.Builder{}); err == nil {
+		t.Errorf("snapshot of a closed storage")
+	}
+}
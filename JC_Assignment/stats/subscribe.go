@@ -0,0 +1,173 @@
+package stats
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//kind of change a subscription is told about
+type EventKind int
+
+const (
+	//a sample created an action that did not exist
+	EventNewAction EventKind = iota
+	//the average or a percentile of an action moved above a threshold or back down to it
+	EventThreshold
+	//a new action was not kept because of WithMaxActions, its sample went to OverflowAction
+	EventRejected
+	//a sample was refused because the total time of its action would overflow
+	EventOverflow
+)
+
+//a value to watch for an action, e.g. Threshold{Action: "jump", Quantile: 0.99, Value: 500}
+type Threshold struct {
+	//action to watch, empty for every action
+	Action string
+	//0 to watch the average, otherwise the percentile to watch from 0 to 1
+	Quantile float64
+	//the action is above the threshold while the watched value is greater than Value,
+	//in the unit of the action
+	Value uint64
+}
+
+//a change in the stats sent to subscribers
+type Event struct {
+	Kind   EventKind
+	Action string
+	//for EventThreshold, the threshold crossed, the watched value and whether it is now above
+	Threshold Threshold
+	Value     uint64
+	Above     bool
+	//for EventOverflow, the error returned for the sample
+	Err error
+}
+
+//receives events from a stats struct until it is closed.
+//Events are sent without ever blocking the caller adding samples. When the buffer is full
+//the new event is dropped and counted by Dropped, so a slow subscriber misses the latest
+//events instead of slowing down the stats
+type Subscription struct {
+	id         uint64
+	events     chan Event
+	thresholds []Threshold
+	dropped    uint64
+	subs       *subscribers
+}
+
+//every subscription of a stats struct, shared by copies of the struct
+type subscribers struct {
+	mu     sync.RWMutex
+	list   []*Subscription
+	nextID uint64
+	//number of subscriptions, read without the lock so adding samples stays cheap without any
+	count int32
+}
+
+//starts receiving events for every new action, overflow and rejected sample, plus crossings
+//of the thresholds given. buffer is how many events can wait before new ones are dropped
+func (s *Stats) Subscribe(buffer int, thresholds ...Threshold) *Subscription {
+	s.subs.mu.Lock()
+	defer s.subs.mu.Unlock()
+	s.subs.nextID++
+	sub := &Subscription{
+		id:         s.subs.nextID,
+		events:     make(chan Event, buffer),
+		thresholds: append([]Threshold(nil), thresholds...),
+		subs:       s.subs,
+	}
+	s.subs.list = append(s.subs.list, sub)
+	atomic.AddInt32(&s.subs.count, 1)
+	return sub
+}
+
+//channel the events are sent on, closed by Close
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+//number of events dropped because the buffer was full
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+//stops the subscription and closes its channel, safe to call more than once
+func (sub *Subscription) Close() {
+	sub.subs.mu.Lock()
+	defer sub.subs.mu.Unlock()
+	for i, other := range sub.subs.list {
+		if other == sub {
+			sub.subs.list = append(sub.subs.list[:i], sub.subs.list[i+1:]...)
+			atomic.AddInt32(&sub.subs.count, -1)
+			close(sub.events)
+			return
+		}
+	}
+}
+
+//sends without blocking, the subscribers must be locked so the channel is not closed meanwhile
+func (sub *Subscription) send(event Event) {
+	select {
+	case sub.events <- event:
+	default:
+		atomic.AddUint64(&sub.dropped, 1)
+	}
+}
+
+//sends an event to every subscription
+func (s *Stats) publish(event Event) {
+	if atomic.LoadInt32(&s.subs.count) == 0 {
+		return
+	}
+	s.subs.mu.RLock()
+	defer s.subs.mu.RUnlock()
+	for _, sub := range s.subs.list {
+		sub.send(event)
+	}
+}
+
+//sends an event for every threshold the action moved across with its latest sample.
+//Whether the action is above each threshold is kept on the shard, so the shard must be locked
+func (s *Stats) checkThresholds(sh *shard, action string, average *Average) {
+	if atomic.LoadInt32(&s.subs.count) == 0 {
+		return
+	}
+	s.subs.mu.RLock()
+	defer s.subs.mu.RUnlock()
+	for _, sub := range s.subs.list {
+		for i, threshold := range sub.thresholds {
+			if threshold.Action != "" && threshold.Action != action {
+				continue
+			}
+			value := average.AverageTime()
+			if threshold.Quantile > 0 {
+				value = average.quantile(threshold.Quantile)
+			}
+			value = average.inUnit(value)
+			above := value > threshold.Value
+			if above == sh.isAbove(action, sub, i) {
+				continue
+			}
+			sh.setAbove(action, sub, i, above)
+			sub.send(Event{Kind: EventThreshold, Action: action, Threshold: threshold, Value: value, Above: above})
+		}
+	}
+}
+
+//whether the action was last above threshold i of sub
+func (sh *shard) isAbove(action string, sub *Subscription, i int) bool {
+	above := sh.above[action][sub.id]
+	return above != nil && above[i]
+}
+
+func (sh *shard) setAbove(action string, sub *Subscription, i int, above bool) {
+	if sh.above == nil {
+		sh.above = make(map[string]map[uint64][]bool)
+	}
+	if sh.above[action] == nil {
+		sh.above[action] = make(map[uint64][]bool)
+	}
+	if sh.above[action][sub.id] == nil {
+		sh.above[action][sub.id] = make([]bool, len(sub.thresholds))
+	}
+	sh.above[action][sub.id][i] = above
+}
@@ -0,0 +1,122 @@
+package stats
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+//helper to read every event waiting on a subscription, stops early if it is closed
+func drain(sub *Subscription) []Event {
+	events := make([]Event, 0)
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+//new actions, rejected samples and overflows are sent
+func TestSubscribe_Events(t *testing.T) {
+	st := NewStats(WithShards(1), WithMaxActions(1, RejectNew))
+	sub := st.Subscribe(10)
+	st.addAction(Sample{Action: "jump", Time: math.MaxUint64})
+	st.addAction(Sample{Action: "jump", Time: 1})
+	st.addAction(Sample{Action: "run", Time: 1})
+
+	events := drain(sub)
+	if len(events) != 4 {
+		t.Fatalf("wrong number of events %+v", events)
+	}
+	if events[0].Kind != EventNewAction || events[0].Action != "jump" {
+		t.Errorf("wrong new action event %+v", events[0])
+	}
+	if events[1].Kind != EventOverflow || events[1].Action != "jump" || !errors.Is(events[1].Err, ErrOverflow) {
+		t.Errorf("wrong overflow event %+v", events[1])
+	}
+	if events[2].Kind != EventRejected || events[2].Action != "run" {
+		t.Errorf("wrong rejected event %+v", events[2])
+	}
+	//the first sample of the overflow action makes it a new action
+	if events[3].Kind != EventNewAction || events[3].Action != OverflowAction {
+		t.Errorf("wrong overflow action event %+v", events[3])
+	}
+}
+
+//an event is sent each time the value moves across the threshold, not for every sample
+func TestSubscribe_Threshold(t *testing.T) {
+	st := NewStats()
+	sub := st.Subscribe(10,
+		Threshold{Action: "jump", Value: 150},
+		Threshold{Quantile: 0.99, Value: 1000},
+	)
+	for _, time := range []uint64{100, 200, 300, 400, 10, 10, 10, 10, 10} {
+		st.addAction(Sample{Action: "jump", Time: time})
+	}
+	st.addAction(Sample{Action: "run", Time: 5000})
+
+	events := drain(sub)
+	want := []Event{
+		{Kind: EventNewAction, Action: "jump"},
+		{Kind: EventThreshold, Action: "jump", Threshold: Threshold{Action: "jump", Value: 150}, Value: 200, Above: true},
+		{Kind: EventThreshold, Action: "jump", Threshold: Threshold{Action: "jump", Value: 150}, Value: 147, Above: false},
+		{Kind: EventNewAction, Action: "run"},
+		{Kind: EventThreshold, Action: "run", Threshold: Threshold{Quantile: 0.99, Value: 1000}, Value: 5000, Above: true},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("wrong events\nhave %+v\nwant %+v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("wrong event %d\nhave %+v\nwant %+v", i, events[i], want[i])
+		}
+	}
+}
+
+//a full buffer drops new events instead of blocking the add
+func TestSubscribe_SlowSubscriber(t *testing.T) {
+	st := NewStats()
+	sub := st.Subscribe(2)
+	addUniqueActions(&st, 5)
+	if events := drain(sub); len(events) != 2 || events[0].Action != "Action0" || events[1].Action != "Action1" {
+		t.Errorf("the oldest events were not kept %+v", events)
+	}
+	if sub.Dropped() != 3 {
+		t.Errorf("wrong dropped count %d", sub.Dropped())
+	}
+}
+
+//closing stops events and closes the channel while samples are being added
+func TestSubscribe_Close(t *testing.T) {
+	st := NewStats()
+	sub := st.Subscribe(100, Threshold{Value: 0})
+	other := st.Subscribe(1)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addUniqueActions(&st, 1000)
+		}()
+	}
+	sub.Close()
+	sub.Close()
+	wg.Wait()
+	drain(sub)
+	if _, ok := <-sub.Events(); ok {
+		t.Error("the channel was not closed")
+	}
+	//the other subscription is still open
+	st.addAction(Sample{Action: "jump", Time: 1})
+	other.Close()
+	if _, ok := <-other.Events(); !ok {
+		t.Error("the other subscription stopped early")
+	}
+}
@@ -0,0 +1,205 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"time"
+)
+
+//Times of an action with a unit are kept in nanoseconds, so samples in any unit add up correctly.
+//The first unit an action sees is the unit it is shown in, and samples without a unit are in the unit
+//of the action. Actions that never see a unit keep their times as they were added. Their unit is unknown,
+//so a sample with a unit for an action that already has samples without one is rejected with ErrUnitMismatch
+
+//nanoseconds in one of unit, times without a unit are not converted
+func unitNanos(unit string) uint64 {
+	switch unit {
+	case "us":
+		return uint64(time.Microsecond)
+	case "ms":
+		return uint64(time.Millisecond)
+	case "s":
+		return uint64(time.Second)
+	}
+	return 1
+}
+
+//multiplies a time by f nanoseconds, false if it does not fit in a uint64
+func toNanos(t uint64, f uint64) (uint64, bool) {
+	hi, lo := bits.Mul64(t, f)
+	return lo, hi == 0
+}
+
+//multiplies two totals, stopping at math.MaxUint64 like addSaturating
+func mulSaturating(a uint64, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	if hi != 0 {
+		return math.MaxUint64
+	}
+	return lo
+}
+
+//shows averages as floats in unit, one of ns, us, ms or s, instead of the unit of each action.
+//Value is set to the exact average and avg is truncated to unit.
+//Actions without a unit cannot be converted and are left as they are
+func InUnit(unit string) QueryOption {
+	return func(q *query) {
+		q.unit = unit
+	}
+}
+
+//adds the average as a Go duration string such as 1.5ms to actions that have a unit
+func AsDuration() QueryOption {
+	return func(q *query) {
+		q.duration = true
+	}
+}
+
+//builds the output row of count samples of an action in unit.
+//avg and exact are their average in nanoseconds, or as added for an action without a unit
+func (q *query) row(action string, unit string, avg uint64, exact float64, count uint64) statsRow {
+	out := unit
+	if q.unit != "" && unit != "" {
+		out = q.unit
+	}
+	f := unitNanos(out)
+	sampleAverage := SampleAverage{
+		Action:  action,
+		Average: avg / f,
+		Unit:    out,
+	}
+	if q.unit != "" {
+		sampleAverage.Value = exact / float64(f)
+	}
+	if q.duration && unit != "" {
+		sampleAverage.Duration = formatDuration(exact)
+	}
+	return statsRow{average: sampleAverage, count: count, nanos: exact}
+}
+
+//formats nanoseconds the way time.Duration does, durations past about 292 years do not fit and are capped
+func formatDuration(nanos float64) string {
+	if nanos >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64).String()
+	}
+	return time.Duration(math.Round(nanos)).String()
+}
+
+//the average in the unit of the action, the avg GetStats shows for it
+func (a *Average) UnitAverage() uint64 {
+	return a.inUnit(a.AverageTime())
+}
+
+//converts a time in nanoseconds to the unit of the action
+func (a *Average) inUnit(t uint64) uint64 {
+	return t / unitNanos(a.Unit)
+}
+
+//converts a float time in nanoseconds to the unit of the action
+func (a *Average) inUnitFloat(t float64) float64 {
+	return t / float64(unitNanos(a.Unit))
+}
+
+//converts the times of a state counted in a unit of f nanoseconds into nanoseconds.
+//Totals that no longer fit are kept with accumulation the same way accumulate keeps them,
+//and the histogram buckets are moved to the buckets of their middle value
+func (st ActionState) toNanos(f uint64, accumulation Accumulation) (ActionState, error) {
+	if f == 1 || st.NumSamples == 0 {
+		return st, nil
+	}
+	max, ok := toNanos(st.Max, f)
+	if !ok {
+		return ActionState{}, fmt.Errorf("%w-> a time of %d does not fit in nanoseconds", ErrOverflow, st.Max)
+	}
+	converted := st
+	converted.Max = max
+	converted.Min, _ = toNanos(st.Min, f)
+	converted.Mean = st.Mean * float64(f)
+	converted.M2 = st.M2 * float64(f) * float64(f)
+
+	if accumulation == AccumulateKahan {
+		sum, c := kahanOf(st)
+		converted.KahanSum, converted.KahanC = sum*float64(f), c*float64(f)
+	}
+	total, ok := toNanos(st.TotalTime, f)
+	switch {
+	case st.Saturated:
+	case st.BigTotal != "":
+		converted.BigTotal = new(big.Int).Mul(bigOf(st), new(big.Int).SetUint64(f)).String()
+	case ok:
+		converted.TotalTime = total
+	case accumulation == AccumulateBig:
+		converted.BigTotal = new(big.Int).Mul(bigOf(st), new(big.Int).SetUint64(f)).String()
+		converted.TotalTime = math.MaxUint64
+	case accumulation == AccumulateKahan:
+		converted.TotalTime = math.MaxUint64
+		converted.Saturated = true
+	default:
+		return ActionState{}, fmt.Errorf("%w-> a total of %d does not fit in nanoseconds", ErrOverflow, st.TotalTime)
+	}
+
+	var hist histogram
+	for _, b := range st.Histogram {
+		if b[0] >= 64*histSubBuckets {
+			return ActionState{}, fmt.Errorf("histogram bucket %d is out of range", b[0])
+		}
+		lower, upper := histBounds(uint16(b[0]))
+		mid, ok := toNanos(lower+(upper-lower)/2, f)
+		if !ok || mid > converted.Max {
+			mid = converted.Max
+		}
+		if mid < converted.Min {
+			mid = converted.Min
+		}
+		hist.addCount(histIndex(mid), b[1])
+	}
+	converted.Histogram = make([][2]uint64, len(hist.buckets))
+	for i, b := range hist.buckets {
+		converted.Histogram[i] = [2]uint64{uint64(b.index), b.count}
+	}
+
+	if st.Window != nil {
+		converted.Window = make([]WindowState, len(st.Window))
+		for i, b := range st.Window {
+			b.TotalTime = mulSaturating(b.TotalTime, f)
+			converted.Window[i] = b
+		}
+	}
+	if st.Labels != nil {
+		converted.Labels = make(map[string]map[string]LabelState, len(st.Labels))
+		for name, values := range st.Labels {
+			converted.Labels[name] = make(map[string]LabelState, len(values))
+			for value, totals := range values {
+				totals.TotalTime = mulSaturating(totals.TotalTime, f)
+				converted.Labels[name][value] = totals
+			}
+		}
+	}
+	if st.EWMA != nil {
+		converted.EWMA = &EWMAState{Sum: st.EWMA.Sum * float64(f), Weight: st.EWMA.Weight, At: st.EWMA.At}
+	}
+	return converted, nil
+}
+
+//converts the times of a state from before version 5, which were kept in the unit of each action, into nanoseconds
+func (st State) upgrade() (State, error) {
+	if st.Version >= 5 {
+		return st, nil
+	}
+	upgraded := st
+	upgraded.Version = snapshotVersion
+	upgraded.Averages = make(map[string]ActionState, len(st.Averages))
+	for action, a := range st.Averages {
+		if a.Unit != "" && validUnit(a.Unit) {
+			var err error
+			a, err = a.toNanos(unitNanos(a.Unit), st.Accumulation)
+			if err != nil {
+				return State{}, fmt.Errorf("cannot convert %s to nanoseconds-> %s", action, err.Error())
+			}
+		}
+		upgraded.Averages[action] = a
+	}
+	return upgraded, nil
+}
@@ -0,0 +1,166 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+//helper to read GetStats into a slice
+func unitAverages(t *testing.T, st *Stats, opts ...QueryOption) []SampleAverage {
+	t.Helper()
+	statsJson, err := st.GetStats(opts...)
+	if err != nil {
+		t.Fatalf("error from get stats %s", err.Error())
+	}
+	have := make([]SampleAverage, 0)
+	json.Unmarshal([]byte(statsJson), &have)
+	return have
+}
+
+//samples in different units add up and are shown in the first unit of the action
+func TestUnits_MixedSamples(t *testing.T) {
+	st := NewStats()
+	st.AddAction(`{"action":"jump", "time":1500, "unit":"us"}`)
+	st.AddAction(`{"action":"jump", "time":1, "unit":"ms"}`)
+	st.AddAction(`{"action":"jump", "time":1000000, "unit":"ns"}`)
+	st.AddAction(`{"action":"jump", "time":2}`)
+	st.AddAction(`{"action":"run", "time":1, "unit":"s"}`)
+	st.AddAction(`{"action":"walk", "time":75}`)
+
+	statsJson, _ := st.GetStats()
+	want := `[{"action":"jump","avg":875,"unit":"us"},{"action":"run","avg":1,"unit":"s"},{"action":"walk","avg":75}]`
+	if statsJson != want {
+		t.Errorf("wrong stats\nhave %s\nwant %s", statsJson, want)
+	}
+
+	have := unitAverages(t, &st, InUnit("ms"), AsDuration(), SortBy(SortByAverage, Ascending))
+	if len(have) != 3 {
+		t.Fatalf("wrong number of actions %+v", have)
+	}
+	//walk has no unit so it is left as it is and sorts by its own number
+	if have[0].Action != "walk" || have[0].Value != 75 || have[0].Unit != "" || have[0].Duration != "" {
+		t.Errorf("wrong walk %+v", have[0])
+	}
+	if have[1].Action != "jump" || have[1].Value != 0.8755 || have[1].Average != 0 || have[1].Unit != "ms" || have[1].Duration != "875.5µs" {
+		t.Errorf("wrong jump %+v", have[1])
+	}
+	if have[2].Action != "run" || have[2].Value != 1000 || have[2].Duration != "1s" {
+		t.Errorf("wrong run %+v", have[2])
+	}
+
+	if _, err := st.GetStats(InUnit("minutes")); !errors.Is(err, ErrInvalidUnit) {
+		t.Errorf("accepted an unknown unit %v", err)
+	}
+}
+
+//an action with samples without a unit never takes one, their times would be read in the wrong unit
+func TestUnits_Mismatch(t *testing.T) {
+	st := NewStats()
+	st.AddAction(`{"action":"jump","time":100}`)
+	if err := st.AddAction(`{"action":"jump","time":100,"unit":"s"}`); !errors.Is(err, ErrUnitMismatch) {
+		t.Fatalf("accepted seconds after a sample without a unit %v", err)
+	}
+	if statsJson, _ := st.GetStats(); statsJson != `[{"action":"jump","avg":100}]` {
+		t.Errorf("wrong stats after a mismatched unit %s", statsJson)
+	}
+
+	//a sample without a unit is in the unit of an action that has one
+	st.AddSample(Sample{Action: "run", Time: 2, Unit: "ms"})
+	if err := st.AddSample(Sample{Action: "run", Time: 4}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if run, _ := st.Lookup("run"); run.TotalTime != uint64(6*time.Millisecond) || run.Unit != "ms" {
+		t.Errorf("wrong totals for run %+v", run)
+	}
+	if err := st.AddSample(Sample{Action: "walk", Time: math.MaxUint64, Unit: "us"}); !errors.Is(err, ErrTimeOutOfRange) {
+		t.Errorf("accepted a time that does not fit in nanoseconds %v", err)
+	}
+
+	seconds := NewStats()
+	seconds.AddSample(Sample{Action: "jump", Time: 1, Unit: "s"})
+	if err := seconds.Merge(&st); !errors.Is(err, ErrUnitMismatch) {
+		t.Errorf("merged seconds with samples without a unit %v", err)
+	}
+}
+
+//the time range is checked in nanoseconds once the unit of the sample is known
+func TestUnits_TimeRange(t *testing.T) {
+	st := NewStats(WithTimeRange(1, 1000))
+	tests := []struct {
+		sample string
+		want   error
+	}{
+		{`{"action":"a","time":1,"unit":"s"}`, ErrTimeOutOfRange},
+		{`{"action":"a","time":1,"unit":"us"}`, nil},
+		{`{"action":"a","time":2}`, ErrTimeOutOfRange},
+		{`{"action":"a","time":1000,"unit":"ns"}`, nil},
+		{`{"action":"b","time":1000}`, nil},
+		{`{"action":"b","time":1001}`, ErrTimeOutOfRange},
+	}
+	for _, test := range tests {
+		err := st.AddAction(test.sample)
+		if test.want == nil && err != nil {
+			t.Errorf("%s was rejected %s", test.sample, err.Error())
+		}
+		if test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("%s returned %v, want %v", test.sample, err, test.want)
+		}
+	}
+	if a, _ := st.Lookup("a"); a.NumSamples != 2 || a.TotalTime != 2000 {
+		t.Errorf("wrong totals for a %+v", a)
+	}
+}
+
+//snapshots from before version 5 kept times in the unit of each action
+func TestUnits_UpgradeSnapshot(t *testing.T) {
+	snapshot := `{"version":4,"averages":{
+		"jump":{"numSamples":2,"totalTime":300,"min":100,"max":200,"mean":150,"m2":5000,"histogram":[[67,1],[83,1]],"unit":"ms","labels":{"host":{"a":{"numSamples":2,"totalTime":300}}}},
+		"walk":{"numSamples":1,"totalTime":75,"min":75,"max":75,"mean":75,"histogram":[[66,1]]}}}`
+	st := NewStats()
+	if err := st.Restore(strings.NewReader(snapshot)); err != nil {
+		t.Fatal(err.Error())
+	}
+	st.AddSample(Sample{Action: "jump", Time: 450000, Unit: "us"})
+	statsJson, _ := st.GetStats()
+	if statsJson != `[{"action":"jump","avg":250,"unit":"ms"},{"action":"walk","avg":75}]` {
+		t.Errorf("wrong stats after upgrading %s", statsJson)
+	}
+	if jump, _ := st.Lookup("jump"); jump.Max != uint64(450*time.Millisecond) || jump.Min != uint64(100*time.Millisecond) {
+		t.Errorf("wrong range after upgrading %+v", jump)
+	}
+	if have := groupedAverages(t, &st, "host"); have[[2]string{"jump", "a"}] != 150 {
+		t.Errorf("label totals were not upgraded %v", have)
+	}
+
+	var old State
+	json.Unmarshal([]byte(snapshot), &old)
+	merged, err := old.Merge(st.State())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if jump := merged.Averages["jump"]; merged.Version != snapshotVersion || jump.TotalTime != uint64(1050*time.Millisecond) {
+		t.Errorf("old state was not upgraded before merging %+v", jump)
+	}
+}
+
+//thresholds are in the unit of the action
+func TestUnits_Threshold(t *testing.T) {
+	st := NewStats()
+	sub := st.Subscribe(10, Threshold{Action: "jump", Value: 150})
+	defer sub.Close()
+	st.AddSample(Sample{Action: "jump", Time: 100, Unit: "ms"})
+	st.AddSample(Sample{Action: "jump", Time: 400000, Unit: "us"})
+	crossed := make([]Event, 0)
+	for _, event := range drain(sub) {
+		if event.Kind == EventThreshold {
+			crossed = append(crossed, event)
+		}
+	}
+	if len(crossed) != 1 || crossed[0].Value != 250 || !crossed[0].Above {
+		t.Errorf("wrong threshold events %+v", crossed)
+	}
+}
@@ -0,0 +1,166 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+//every reason a sample can be rejected, returned errors wrap one of these so callers can check with errors.Is
+var (
+	//the sample is not valid json or a field has the wrong type
+	ErrInvalidJSON = errors.New("JSON String is invalid")
+	//the sample has a field that Sample does not, only checked with DisallowUnknownFields
+	ErrUnknownField = errors.New("sample has an unknown field")
+	//the action name is empty, only checked with WithActionNames
+	ErrEmptyAction = errors.New("action is empty")
+	//the action name is longer than the WithActionNames limit
+	ErrActionTooLong = errors.New("action is too long")
+	//the action name has a character outside of the WithActionNames charset
+	ErrActionCharset = errors.New("action has a character that is not allowed")
+	//the action is not in the WithAllowedActions allowlist
+	ErrActionNotAllowed = errors.New("action is not allowed")
+	//the time is negative or outside of the WithTimeRange range
+	ErrTimeOutOfRange = errors.New("time is out of range")
+	//the unit is not one of ns, us, ms or s
+	ErrInvalidUnit = errors.New("unit is not one of ns, us, ms or s")
+	//the sample has a unit but the action already has samples without one, whose unit is unknown
+	ErrUnitMismatch = errors.New("unit does not match the action")
+	//adding the time would overflow the uint64 total of the action
+	ErrOverflow = errors.New("total time would overflow uint64")
+)
+
+//rules every incoming sample is checked against, nothing is checked by default
+type validation struct {
+	disallowUnknownFields bool
+	//names must not be empty when set
+	checkNames bool
+	//longest action name in bytes, 0 for no limit
+	maxActionLength int
+	//characters allowed in an action name, empty for any
+	actionCharset string
+	//inclusive range of times, only checked when set
+	checkTime bool
+	minTime   uint64
+	maxTime   uint64
+	//the only actions accepted, nil for any
+	allowedActions map[string]struct{}
+}
+
+//rejects samples with json fields that Sample does not have, such as a misspelled "tim"
+func DisallowUnknownFields() Option {
+	return func(o *options) {
+		o.validation.disallowUnknownFields = true
+	}
+}
+
+//rejects empty action names, names longer than maxLength bytes and names with a character
+//not in charset. A maxLength of 0 or an empty charset leaves that rule off
+//e.g. WithActionNames(64, "abcdefghijklmnopqrstuvwxyz0123456789_-.")
+func WithActionNames(maxLength int, charset string) Option {
+	return func(o *options) {
+		o.validation.checkNames = true
+		o.validation.maxActionLength = maxLength
+		o.validation.actionCharset = charset
+	}
+}
+
+//rejects samples with a time below min or above max, both inclusive.
+//Times with a unit, or for an action with one, are compared in nanoseconds
+//e.g. WithTimeRange(1, math.MaxUint64) rejects zero times,
+//WithTimeRange(0, uint64(time.Minute)) rejects samples over a minute in any unit
+func WithTimeRange(min uint64, max uint64) Option {
+	return func(o *options) {
+		o.validation.checkTime = true
+		o.validation.minTime = min
+		o.validation.maxTime = max
+	}
+}
+
+//rejects samples for any action that is not listed, can be given more than once
+func WithAllowedActions(actions ...string) Option {
+	return func(o *options) {
+		if o.validation.allowedActions == nil {
+			o.validation.allowedActions = make(map[string]struct{}, len(actions))
+		}
+		for _, action := range actions {
+			o.validation.allowedActions[action] = struct{}{}
+		}
+	}
+}
+
+//decodes one json sample, the error wraps ErrInvalidJSON, ErrUnknownField or ErrTimeOutOfRange
+func (s *Stats) decodeSample(raw []byte) (Sample, error) {
+	var sample Sample
+	if err := json.Unmarshal(raw, &sample); err != nil {
+		return sample, decodeError(err)
+	}
+	if s.validation.disallowUnknownFields {
+		//the sample is already known to be valid json so the only error left is an unknown field
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&Sample{}); err != nil {
+			return sample, decodeError(err)
+		}
+	}
+	return sample, nil
+}
+
+//wraps an error from encoding/json with the reason the sample was rejected
+func decodeError(err error) error {
+	//negative times only fail because time is a uint64, report them as out of range
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field == "time" && strings.HasPrefix(typeErr.Value, "number -") {
+		return fmt.Errorf("%w-> %s is negative", ErrTimeOutOfRange, strings.TrimPrefix(typeErr.Value, "number "))
+	}
+	//encoding/json has no typed error for unknown fields
+	if strings.HasPrefix(err.Error(), "json: unknown field") {
+		return fmt.Errorf("%w-> %s", ErrUnknownField, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return fmt.Errorf("%w-> %s", ErrInvalidJSON, err.Error())
+}
+
+//checks a decoded sample against the configured rules, nothing needs to be locked.
+//The time range depends on the unit of the action, so it is checked by timeInRange when the sample is added
+func (s *Stats) validate(sample Sample) error {
+	v := &s.validation
+	if v.checkNames {
+		if sample.Action == "" {
+			return ErrEmptyAction
+		}
+		if v.maxActionLength > 0 && len(sample.Action) > v.maxActionLength {
+			return fmt.Errorf("%w-> %d bytes is over the limit of %d", ErrActionTooLong, len(sample.Action), v.maxActionLength)
+		}
+		if v.actionCharset != "" {
+			for _, r := range sample.Action {
+				if r == utf8.RuneError || !strings.ContainsRune(v.actionCharset, r) {
+					return fmt.Errorf("%w-> %q in %q", ErrActionCharset, r, sample.Action)
+				}
+			}
+		}
+	}
+	if v.allowedActions != nil {
+		if _, ok := v.allowedActions[sample.Action]; !ok {
+			return fmt.Errorf("%w-> %q", ErrActionNotAllowed, sample.Action)
+		}
+	}
+	if !validUnit(sample.Unit) {
+		return fmt.Errorf("%w-> %q", ErrInvalidUnit, sample.Unit)
+	}
+	return nil
+}
+
+//checks a time against WithTimeRange once it is known to be in unit, nanos is the time in nanoseconds
+func (v *validation) timeInRange(time uint64, unit string, nanos uint64) error {
+	if !v.checkTime || (nanos >= v.minTime && nanos <= v.maxTime) {
+		return nil
+	}
+	bound := ""
+	if unit != "" {
+		bound = "ns"
+	}
+	return fmt.Errorf("%w-> %d%s is not between %d%s and %d%s", ErrTimeOutOfRange, time, unit, v.minTime, bound, v.maxTime, bound)
+}
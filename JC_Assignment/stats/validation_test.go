@@ -0,0 +1,110 @@
+package stats
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+//each rule rejects with its own error
+func TestAddAction_Validation(t *testing.T) {
+	st := NewStats(
+		DisallowUnknownFields(),
+		WithActionNames(8, "abcdefghijklmnopqrstuvwxyz_"),
+		WithTimeRange(1, 1000),
+		WithAllowedActions("jump", "run", "long_jump"),
+	)
+	tests := []struct {
+		sample string
+		want   error
+	}{
+		{"{\"action\":\"jump\", \"time\":100}", nil},
+		{"{\"action\":\"jump\", \"time\":1000}", nil},
+		{"{\"action\":\"jump\", \"time\":100", ErrInvalidJSON},
+		{"{\"action\":\"jump\", \"time\":\"100\"}", ErrInvalidJSON},
+		{"{\"action\":\"jump\", \"time\":-1}", ErrTimeOutOfRange},
+		{"{\"action\":\"jump\", \"time\":0}", ErrTimeOutOfRange},
+		{"{\"action\":\"jump\", \"time\":1001}", ErrTimeOutOfRange},
+		{"{\"action\":\"jump\", \"tim\":100}", ErrUnknownField},
+		{"{\"time\":100}", ErrEmptyAction},
+		{"{\"action\":\"long_jump\", \"time\":100}", ErrActionTooLong},
+		{"{\"action\":\"Jump\", \"time\":100}", ErrActionCharset},
+		{"{\"action\":\"jum\xffp\", \"time\":100}", ErrActionCharset},
+		{"{\"action\":\"walk\", \"time\":100}", ErrActionNotAllowed},
+		{"{\"action\":\"run\", \"time\":100, \"unit\":\"minutes\"}", ErrInvalidUnit},
+		{"{\"action\":\"jump\", \"time\":100, \"unit\":\"ms\"}", ErrUnitMismatch},
+		{"{\"action\":\"jump\", \"time\":100, \"unit\":\"s\"}", ErrUnitMismatch},
+	}
+	for _, test := range tests {
+		err := st.AddAction(test.sample)
+		if test.want == nil && err != nil {
+			t.Errorf("%s was rejected %s", test.sample, err.Error())
+		}
+		if test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("%s returned %v, want %v", test.sample, err, test.want)
+		}
+	}
+	if _, ok := st.Lookup("run"); ok {
+		t.Error("a rejected sample created an action")
+	}
+	jump, _ := st.Lookup("jump")
+	if jump.NumSamples != 2 {
+		t.Errorf("wrong samples for jump %+v", jump)
+	}
+}
+
+//without options only malformed samples are rejected, as before
+func TestAddAction_ValidationDefaults(t *testing.T) {
+	st := NewStats()
+	for _, sample := range []string{
+		"{\"action\":\"\", \"time\":0}",
+		"{\"action\":\"Any Name\", \"time\":100, \"extra\":true}",
+	} {
+		if err := st.AddAction(sample); err != nil {
+			t.Errorf("%s was rejected %s", sample, err.Error())
+		}
+	}
+	err := st.AddAction("{\"action\":\"jump\", \"time\":-1}")
+	if !errors.Is(err, ErrTimeOutOfRange) {
+		t.Errorf("negative time returned %v", err)
+	}
+	err = st.AddAction("not json")
+	if !errors.Is(err, ErrInvalidJSON) || !strings.HasPrefix(err.Error(), "JSON String is invalid-> ") {
+		t.Errorf("invalid json returned %v", err)
+	}
+	st.addAction(Sample{Action: "jump", Time: math.MaxUint64})
+	if err := st.AddAction("{\"action\":\"jump\", \"time\":1}"); !errors.Is(err, ErrOverflow) {
+		t.Errorf("overflow returned %v", err)
+	}
+}
+
+//batches report the same typed errors for each rejected line
+func TestAddActions_Validation(t *testing.T) {
+	st := NewStats(DisallowUnknownFields(), WithAllowedActions("jump"))
+	report, err := st.AddActions(`[
+		{"action":"jump", "time":100},
+		{"action":"jump", "tim":100},
+		{"action":"run", "time":100},
+		{"action":"jump", "time":-5},
+		{"action":"jump", "time":200}
+	]`)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want := map[int]error{2: ErrUnknownField, 3: ErrActionNotAllowed, 4: ErrTimeOutOfRange}
+	if report.Accepted != 2 || len(report.Rejected) != len(want) {
+		t.Fatalf("wrong report %+v", report)
+	}
+	for _, rejected := range report.Rejected {
+		if !errors.Is(rejected, want[rejected.Line]) {
+			t.Errorf("line %d returned %v, want %v", rejected.Line, rejected.Err, want[rejected.Line])
+		}
+	}
+
+	report, _ = st.IngestNDJSON(strings.NewReader("{\"action\":\"jump\", \"time\":1, \"extra\":1}\n{\"action\":\"run\", \"time\":1}\n{\"action\":\"jump\", \"time\":1}\n"))
+	if report.Accepted != 1 || len(report.Rejected) != 2 ||
+		!errors.Is(report.Rejected[0], ErrUnknownField) || !errors.Is(report.Rejected[1], ErrActionNotAllowed) {
+		t.Fatalf("wrong ndjson report %+v", report)
+	}
+}
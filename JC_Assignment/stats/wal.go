@@ -0,0 +1,553 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//when records of the write ahead log are synced to disk
+type SyncPolicy int
+
+const (
+	//every add is synced before it returns, so nothing that was accepted is lost. The default and the slowest
+	SyncAlways SyncPolicy = iota
+	//synced in the background every interval, a crash can lose the samples of the last interval
+	SyncInterval
+	//left to the operating system, a crash of the process loses nothing but a crash of the machine can
+	SyncNever
+)
+
+//configures a write ahead log opened with OpenWAL
+type WALOption func(*walOptions)
+
+type walOptions struct {
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+	//a new segment is started once the current one is larger than this
+	segmentSize int64
+}
+
+//sets when the log is synced to disk, interval is only used by SyncInterval
+func WithSync(policy SyncPolicy, interval time.Duration) WALOption {
+	return func(o *walOptions) {
+		o.syncPolicy = policy
+		o.syncInterval = interval
+	}
+}
+
+//sets the size in bytes a segment grows to before the log moves on to a new one
+func WithSegmentSize(size int64) WALOption {
+	return func(o *walOptions) {
+		if size > 0 {
+			o.segmentSize = size
+		}
+	}
+}
+
+//type of each record in the log
+const (
+	walSample byte = iota + 1
+	walRemove
+	walReset
+)
+
+//length and CRC-32C of the payload in front of every record, then a CRC-32C of those two
+//so a damaged length is caught before it is used
+const walHeaderSize = 12
+
+//largest record read back, far past any real sample
+const maxWALRecord = 1 << 24
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+//the log every accepted sample is written to before it is added.
+//Records are written while the shard of the action is locked, so replaying them in order adds
+//the samples of every shard in the same order and rebuilds the same totals. Removes and resets
+//are logged too. SetState, Restore and Merge are not, call Checkpoint after them.
+//
+//The log is a directory of numbered segment files. Checkpoint writes a snapshot into the directory
+//and deletes the segments it covers, a snapshot numbered n covers every segment before n
+type WAL struct {
+	dir   string
+	opts  walOptions
+	stats *Stats
+
+	mu      sync.Mutex
+	file    *os.File
+	segment uint64
+	size    int64
+	//set when records were written since the last sync
+	dirty bool
+	//the first failed write, every append after it fails because the end of the log is unknown
+	err    error
+	closed bool
+	//reused to build each record
+	buf []byte
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+//the open log of a stats struct, shared by copies of the struct.
+//Set and cleared while every shard is locked, so it can be read while holding any one shard lock
+type walRef struct {
+	log *WAL
+}
+
+//opens the write ahead log in dir, creating it if needed, and rebuilds the stats from it:
+//the latest snapshot is restored and every record after it is replayed. A record cut off by a
+//crash at the end of the log is dropped. From then on every accepted sample is logged.
+//Call it on new stats before adding anything, with the same options the log was written with.
+//Stats kept WithStorage already keep their samples, replaying the log would add them a second time,
+//so an error is returned for them
+func (s *Stats) OpenWAL(dir string, opts ...WALOption) (*WAL, error) {
+	o := walOptions{syncInterval: time.Second, segmentSize: 64 << 20}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.syncPolicy == SyncInterval && o.syncInterval <= 0 {
+		return nil, errors.New("sync interval must be positive")
+	}
+	if s.storage != nil {
+		return nil, errors.New("cannot log stats kept in a storage, it already keeps their samples")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	segments, snapshots, err := listWAL(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	//segments before the latest snapshot are already in it
+	first := uint64(1)
+	if len(snapshots) > 0 {
+		first = snapshots[len(snapshots)-1]
+		if err := s.restoreWALSnapshot(walPath(dir, first, "snapshot")); err != nil {
+			return nil, err
+		}
+	}
+	live := make([]uint64, 0, len(segments))
+	for _, n := range segments {
+		if n >= first {
+			live = append(live, n)
+		}
+	}
+	for i, n := range live {
+		if err := s.replaySegment(walPath(dir, n, "wal"), i == len(live)-1); err != nil {
+			return nil, err
+		}
+	}
+	removeCovered(dir, first, segments, snapshots)
+
+	w := &WAL{dir: dir, opts: o, stats: s, segment: first, done: make(chan struct{})}
+	if len(live) > 0 {
+		w.segment = live[len(live)-1]
+	}
+	if err := w.openSegment(); err != nil {
+		return nil, err
+	}
+
+	s.lockAll()
+	if s.wal.log != nil {
+		s.unlockAll()
+		w.file.Close()
+		return nil, errors.New("a write ahead log is already open for these stats")
+	}
+	s.wal.log = w
+	s.unlockAll()
+
+	if o.syncPolicy == SyncInterval {
+		w.wg.Add(1)
+		go w.syncEvery(o.syncInterval)
+	}
+	return w, nil
+}
+
+//opens the current segment for appending
+func (w *WAL) openSegment() error {
+	f, err := os.OpenFile(walPath(w.dir, w.segment, "wal"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+//path of segment or snapshot n
+func walPath(dir string, n uint64, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("%016d.%s", n, ext))
+}
+
+//the numbers of the segments and snapshots in dir, in order
+func listWAL(dir string) (segments []uint64, snapshots []uint64, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		n, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch ext {
+		case ".wal":
+			segments = append(segments, n)
+		case ".snapshot":
+			snapshots = append(snapshots, n)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i] < snapshots[j] })
+	return segments, snapshots, nil
+}
+
+//deletes the segments and snapshots that the snapshot numbered first replaces.
+//Failures are left for the next open to clean up
+func removeCovered(dir string, first uint64, segments []uint64, snapshots []uint64) {
+	for _, n := range segments {
+		if n < first {
+			os.Remove(walPath(dir, n, "wal"))
+		}
+	}
+	for _, n := range snapshots {
+		if n < first {
+			os.Remove(walPath(dir, n, "snapshot"))
+		}
+	}
+}
+
+func (s *Stats) restoreWALSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := decodeBinaryState(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("write ahead log snapshot %s is invalid-> %s", path, err.Error())
+	}
+	return s.SetState(st)
+}
+
+//applies every record of a segment. A record cut off at the end of the last segment is
+//left over from a crash, it is dropped and the segment is truncated so appends follow the last good record
+func (s *Stats) replaySegment(path string, last bool) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var offset int
+	for offset < len(data) {
+		payload, n, err := readWALRecord(data[offset:])
+		if err == errTornRecord && last {
+			return os.Truncate(path, int64(offset))
+		}
+		if err != nil {
+			return fmt.Errorf("write ahead log %s is corrupt at offset %d-> %s", path, offset, err.Error())
+		}
+		if err := s.replayRecord(payload); err != nil {
+			return fmt.Errorf("write ahead log %s has a bad record at offset %d-> %s", path, offset, err.Error())
+		}
+		offset += n
+	}
+	return nil
+}
+
+//a record that runs past the end of the log, the write was cut off
+var errTornRecord = errors.New("record is incomplete")
+
+//returns the payload of the record at the start of data and the length of the whole record.
+//A record is only torn when data is too short to hold it, anything else that does not check out is corruption
+func readWALRecord(data []byte) ([]byte, int, error) {
+	//every record has at least its type after the header
+	if len(data) <= walHeaderSize {
+		return nil, 0, errTornRecord
+	}
+	if crc32.Checksum(data[0:8], walTable) != binary.LittleEndian.Uint32(data[8:12]) {
+		return nil, 0, errors.New("header checksum does not match")
+	}
+	length := binary.LittleEndian.Uint32(data[0:4])
+	sum := binary.LittleEndian.Uint32(data[4:8])
+	if length > maxWALRecord {
+		return nil, 0, fmt.Errorf("record length %d is too long", length)
+	}
+	end := walHeaderSize + int(length)
+	if len(data) < end {
+		return nil, 0, errTornRecord
+	}
+	if crc32.Checksum(data[walHeaderSize:end], walTable) != sum {
+		//only the last record can be half written, anything before it is corruption
+		if len(data) == end {
+			return nil, 0, errTornRecord
+		}
+		return nil, 0, errors.New("checksum does not match")
+	}
+	return data[walHeaderSize:end], end, nil
+}
+
+//applies one record to the stats. Samples were validated when they were logged, and a sample
+//that was refused then is refused again since the shard is in the same state
+func (s *Stats) replayRecord(payload []byte) error {
+	if len(payload) == 0 {
+		return errors.New("record is empty")
+	}
+	br := &binaryReader{r: bufio.NewReaderSize(bytes.NewReader(payload[1:]), 16)}
+	switch payload[0] {
+	case walSample:
+		sample := Sample{Action: br.string(maxWALRecord), Time: br.uvarint()}
+		if at := br.varint(); at != 0 {
+			sample.Timestamp = time.Unix(0, at)
+		}
+		sample.Unit = br.string(maxWALRecord)
+		numLabels := br.length(maxWALRecord)
+		for i := 0; i < numLabels && br.err == nil; i++ {
+			if sample.Labels == nil {
+				sample.Labels = make(map[string]string, numLabels)
+			}
+			name := br.string(maxWALRecord)
+			sample.Labels[name] = br.string(maxWALRecord)
+		}
+		if br.err != nil {
+			return br.err
+		}
+		s.addToShard(sample)
+	case walRemove:
+		action := br.string(maxWALRecord)
+		if br.err != nil {
+			return br.err
+		}
+		s.Remove(action)
+	case walReset:
+		s.Reset()
+	default:
+		return fmt.Errorf("unknown record type %d", payload[0])
+	}
+	return nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	return append(buf, scratch[:binary.PutUvarint(scratch[:], v)]...)
+}
+
+func appendString(buf []byte, v string) []byte {
+	return append(appendUvarint(buf, uint64(len(v))), v...)
+}
+
+//writes a record to the end of the log, the shard the record belongs to must be locked.
+//fill appends the payload after the record type
+func (w *WAL) append(kind byte, fill func(buf []byte) []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errors.New("write ahead log is closed")
+	}
+	if w.size >= w.opts.segmentSize {
+		if err := w.rotateLocked(); err != nil {
+			w.err = fmt.Errorf("write ahead log could not start a new segment-> %s", err.Error())
+			return w.err
+		}
+	}
+	buf := append(w.buf[:0], make([]byte, walHeaderSize)...)
+	buf = fill(append(buf, kind))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)-walHeaderSize))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(buf[walHeaderSize:], walTable))
+	binary.LittleEndian.PutUint32(buf[8:12], crc32.Checksum(buf[0:8], walTable))
+	w.buf = buf
+	n, err := w.file.Write(buf)
+	w.size += int64(n)
+	w.dirty = true
+	if err != nil {
+		w.err = fmt.Errorf("write ahead log write failed-> %s", err.Error())
+		return w.err
+	}
+	return nil
+}
+
+func (w *WAL) appendSample(sample Sample) error {
+	return w.append(walSample, func(buf []byte) []byte {
+		buf = appendString(buf, sample.Action)
+		buf = appendUvarint(buf, sample.Time)
+		var at int64
+		if !sample.Timestamp.IsZero() {
+			at = sample.Timestamp.UnixNano()
+		}
+		var scratch [binary.MaxVarintLen64]byte
+		buf = append(buf, scratch[:binary.PutVarint(scratch[:], at)]...)
+		buf = appendString(buf, sample.Unit)
+		buf = appendUvarint(buf, uint64(len(sample.Labels)))
+		//labels in order so the same sample always makes the same record
+		names := make([]string, 0, len(sample.Labels))
+		for name := range sample.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			buf = appendString(appendString(buf, name), sample.Labels[name])
+		}
+		return buf
+	})
+}
+
+//syncs the current segment and starts the next one, w.mu must be held
+func (w *WAL) rotateLocked() error {
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.dirty = false
+	w.segment++
+	if err := w.openSegment(); err != nil {
+		return err
+	}
+	syncDir(w.dir)
+	return nil
+}
+
+//syncs a directory so new and renamed files in it survive a crash, not supported everywhere
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+//syncs everything written so far to disk. Adds waiting on the same sync share it
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncLocked()
+}
+
+func (w *WAL) syncLocked() error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.dirty || w.closed {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		w.err = fmt.Errorf("write ahead log sync failed-> %s", err.Error())
+		return w.err
+	}
+	w.dirty = false
+	return nil
+}
+
+//syncs after an add when every add must be on disk before it returns.
+//Safe to call on a nil log
+func (w *WAL) syncAdd() error {
+	if w == nil || w.opts.syncPolicy != SyncAlways {
+		return nil
+	}
+	return w.Sync()
+}
+
+func (w *WAL) syncEvery(interval time.Duration) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.Sync()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+//writes a snapshot of the stats into the log directory and deletes the segments it replaces,
+//so the log does not grow forever and opening it only replays what came after
+func (w *WAL) Checkpoint() error {
+	s := w.stats
+	//the state and the start of the new segment must line up exactly, so nothing is added in between
+	s.lockAll()
+	w.mu.Lock()
+	err := w.err
+	if err == nil && w.closed {
+		err = errors.New("write ahead log is closed")
+	}
+	if err == nil {
+		if err = w.rotateLocked(); err != nil {
+			w.err = fmt.Errorf("write ahead log could not start a new segment-> %s", err.Error())
+		}
+	}
+	segment := w.segment
+	w.mu.Unlock()
+	var st State
+	if err == nil {
+		st, err = s.stateLocked()
+	}
+	s.unlockAll()
+	if err != nil {
+		return err
+	}
+
+	path := walPath(w.dir, segment, "snapshot")
+	if err := writeFileAtomic(path, func(f io.Writer) error {
+		return encodeBinaryState(f, st)
+	}); err != nil {
+		return err
+	}
+	segments, snapshots, err := listWAL(w.dir)
+	if err != nil {
+		return err
+	}
+	removeCovered(w.dir, segment, segments, snapshots)
+	return nil
+}
+
+//stops logging, syncs the log and closes it. The stats keep working without a log
+func (w *WAL) Close() error {
+	s := w.stats
+	s.lockAll()
+	if s.wal.log == w {
+		s.wal.log = nil
+	}
+	s.unlockAll()
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	err := w.syncLocked()
+	w.closed = true
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.mu.Unlock()
+
+	if w.opts.syncPolicy == SyncInterval {
+		close(w.done)
+		w.wg.Wait()
+	}
+	return err
+}
@@ -0,0 +1,321 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+//helper to make stats with every optional total turned on, so a replay has to rebuild all of them
+func newWALStats(clock Clock) Stats {
+	return NewStats(
+		WithShards(4),
+		WithWindow(time.Minute, time.Second),
+		WithEWMA(time.Minute),
+		WithClock(clock),
+		WithMaxActions(8, EvictLRU),
+	)
+}
+
+//helper to open a log, failing the test on an error
+func openWAL(t *testing.T, st *Stats, dir string, opts ...WALOption) *WAL {
+	t.Helper()
+	wal, err := st.OpenWAL(dir, opts...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return wal
+}
+
+//helper to add a mix of samples, batches, removes and evictions
+func addWALSamples(t *testing.T, st *Stats, clock *fakeClock) {
+	t.Helper()
+	for i := 0; i < 20; i++ {
+		clock.now = clock.now.Add(time.Second)
+		if err := st.Record("jump", time.Duration(100+i)*time.Millisecond, map[string]string{"host": "a"}); err != nil {
+			t.Fatal(err.Error())
+		}
+		st.AddAction(`{"action":"run", "time":75, "labels":{"host":"b"}}`)
+	}
+	addUniqueActions(st, 12)
+	st.AddActions(`[{"action":"walk", "time":10, "unit":"ms"}, {"action":"walk", "time":-1}, {"action":"swim", "time":30, "timestamp":"2020-09-13T12:26:30Z"}]`)
+	st.Remove("Action11")
+	st.AddAction(`{"action":"jump", "time":5, "unit":"s"}`)
+}
+
+//a replayed log rebuilds exactly the same state
+func TestWAL_Replay(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	st := newWALStats(clock)
+	wal := openWAL(t, &st, dir)
+	addWALSamples(t, &st, clock)
+	want := st.State()
+	wantCardinality := st.Cardinality()
+	if err := wal.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	//the stats keep working without the log
+	st.addAction(Sample{Action: "jump", Time: 1})
+
+	restored := newWALStats(clock)
+	wal = openWAL(t, &restored, dir)
+	defer wal.Close()
+	if have := restored.State(); !reflect.DeepEqual(have, want) {
+		t.Errorf("replay did not rebuild the state\nhave %+v\nwant %+v", have, want)
+	}
+	if have := restored.Cardinality(); have != wantCardinality || have.Evicted == 0 {
+		t.Errorf("wrong cardinality after replay %+v want %+v", have, wantCardinality)
+	}
+}
+
+//stats kept in a storage already keep their samples and cannot be logged,
+//the interval SwapAndReset returns is kept in memory and can
+func TestWAL_Storage(t *testing.T) {
+	st := NewStats(WithStorage(openBolt(t, filepath.Join(t.TempDir(), "stats.db"))))
+	if wal, err := st.OpenWAL(t.TempDir()); err == nil {
+		wal.Close()
+		t.Fatal("opened a log for stats kept in a storage")
+	}
+	st.AddSample(Sample{Action: "jump", Time: 100})
+	previous := st.SwapAndReset()
+	wal := openWAL(t, &previous, t.TempDir())
+	defer wal.Close()
+}
+
+//samples added at the same time are logged in the order each shard adds them
+func TestWAL_Concurrent(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStats(WithShards(4), WithAccumulation(AccumulateKahan))
+	wal := openWAL(t, &st, dir, WithSync(SyncNever, 0))
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				st.addAction(Sample{Action: fmt.Sprintf("Action%d", i%10), Time: uint64(w*1000 + i)})
+			}
+		}(w)
+	}
+	wg.Wait()
+	want := st.State()
+	wal.Close()
+
+	restored := NewStats(WithShards(4), WithAccumulation(AccumulateKahan))
+	openWAL(t, &restored, dir).Close()
+	if !reflect.DeepEqual(restored.State(), want) {
+		t.Error("replaying concurrent adds did not rebuild the state")
+	}
+}
+
+//a reset is replayed and only what came after it is kept
+func TestWAL_Reset(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStats()
+	wal := openWAL(t, &st, dir)
+	st.addAction(Sample{Action: "jump", Time: 100})
+	st.SwapAndReset()
+	st.addAction(Sample{Action: "run", Time: 75})
+	wal.Close()
+
+	restored := NewStats()
+	openWAL(t, &restored, dir).Close()
+	statsJson, _ := restored.GetStats()
+	if statsJson != `[{"action":"run","avg":75}]` {
+		t.Errorf("wrong stats after replaying a reset %s", statsJson)
+	}
+}
+
+//helper to return the newest segment in dir
+func lastSegment(t *testing.T, dir string) string {
+	t.Helper()
+	segments, _, err := listWAL(dir)
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("no segments %v", err)
+	}
+	return walPath(dir, segments[len(segments)-1], "wal")
+}
+
+//a record cut off anywhere by a crash is dropped and the log carries on after the last good one
+func TestWAL_TruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStats()
+	wal := openWAL(t, &st, dir)
+	st.addAction(Sample{Action: "jump", Time: 100})
+	info, _ := os.Stat(lastSegment(t, dir))
+	good := info.Size()
+	st.addAction(Sample{Action: "jump", Time: 200, Labels: map[string]string{"host": "a"}})
+	wal.Close()
+	data, _ := ioutil.ReadFile(lastSegment(t, dir))
+
+	for cut := good; cut < int64(len(data)); cut++ {
+		ioutil.WriteFile(lastSegment(t, dir), data[:cut], 0644)
+		restored := NewStats()
+		wal := openWAL(t, &restored, dir)
+		if jump, _ := restored.Lookup("jump"); jump.NumSamples != 1 || jump.TotalTime != 100 {
+			t.Fatalf("cut at %d restored %+v", cut, jump)
+		}
+		//new records follow the last good one
+		restored.addAction(Sample{Action: "jump", Time: 300})
+		wal.Close()
+		again := NewStats()
+		openWAL(t, &again, dir).Close()
+		if jump, _ := again.Lookup("jump"); jump.NumSamples != 2 || jump.TotalTime != 400 {
+			t.Fatalf("cut at %d then added restored %+v", cut, jump)
+		}
+	}
+
+	//a last record that was not fully written to disk fails its checksum
+	garbled := append([]byte(nil), data...)
+	garbled[len(garbled)-1] ^= 0xff
+	ioutil.WriteFile(lastSegment(t, dir), garbled, 0644)
+	restored := NewStats()
+	openWAL(t, &restored, dir).Close()
+	if jump, _ := restored.Lookup("jump"); jump.NumSamples != 1 {
+		t.Errorf("garbled last record was replayed %+v", jump)
+	}
+}
+
+//a damaged length in the middle of the log is corruption, the records after it are not cut off
+func TestWAL_CorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStats()
+	wal := openWAL(t, &st, dir)
+	offsets := make([]int64, 0)
+	for i := 0; i < 5; i++ {
+		info, _ := os.Stat(lastSegment(t, dir))
+		offsets = append(offsets, info.Size())
+		st.addAction(Sample{Action: fmt.Sprint("a", i), Time: 1})
+	}
+	wal.Close()
+	data, _ := ioutil.ReadFile(lastSegment(t, dir))
+
+	for _, change := range []func(length uint32) uint32{
+		func(length uint32) uint32 { return length + 1 },
+		func(length uint32) uint32 { return length - 1 },
+		func(length uint32) uint32 { return 1 << 30 },
+	} {
+		corrupt := append([]byte(nil), data...)
+		header := corrupt[offsets[1]:]
+		binary.LittleEndian.PutUint32(header, change(binary.LittleEndian.Uint32(header)))
+		ioutil.WriteFile(lastSegment(t, dir), corrupt, 0644)
+
+		restored := NewStats()
+		if _, err := restored.OpenWAL(dir); err == nil {
+			t.Error("opened a log with a corrupt length")
+		}
+		if after, _ := ioutil.ReadFile(lastSegment(t, dir)); !bytes.Equal(after, corrupt) {
+			t.Errorf("the corrupt log was changed from %d to %d bytes", len(corrupt), len(after))
+		}
+	}
+}
+
+//a bad record before the end is corruption, not a crash, and the log refuses to open
+func TestWAL_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStats()
+	wal := openWAL(t, &st, dir)
+	st.addAction(Sample{Action: "jump", Time: 100})
+	st.addAction(Sample{Action: "jump", Time: 200})
+	wal.Close()
+	data, _ := ioutil.ReadFile(lastSegment(t, dir))
+	data[walHeaderSize+2] ^= 0xff
+	ioutil.WriteFile(lastSegment(t, dir), data, 0644)
+
+	restored := NewStats()
+	if _, err := restored.OpenWAL(dir); err == nil {
+		t.Error("opened a corrupt log")
+	}
+}
+
+//segments rotate once they are full and are replayed in order
+func TestWAL_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	st := newWALStats(clock)
+	wal := openWAL(t, &st, dir, WithSegmentSize(256), WithSync(SyncNever, 0))
+	addWALSamples(t, &st, clock)
+	want := st.State()
+	wal.Close()
+	if segments, _, _ := listWAL(dir); len(segments) < 3 {
+		t.Fatalf("the log did not rotate %v", segments)
+	}
+
+	restored := newWALStats(clock)
+	openWAL(t, &restored, dir).Close()
+	if !reflect.DeepEqual(restored.State(), want) {
+		t.Error("replaying several segments did not rebuild the state")
+	}
+}
+
+//a checkpoint replaces the segments before it with a snapshot
+func TestWAL_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStats()
+	wal := openWAL(t, &st, dir, WithSegmentSize(64))
+	addUniqueActions(&st, 10)
+	before, _, _ := listWAL(dir)
+	if err := wal.Checkpoint(); err != nil {
+		t.Fatal(err.Error())
+	}
+	st.addAction(Sample{Action: "jump", Time: 100})
+	want := st.State()
+	wal.Close()
+
+	segments, snapshots, _ := listWAL(dir)
+	if len(segments) != 1 || len(snapshots) != 1 || segments[0] != snapshots[0] {
+		t.Fatalf("covered segments were not removed %v %v", segments, snapshots)
+	}
+	restored := NewStats()
+	openWAL(t, &restored, dir).Close()
+	if !reflect.DeepEqual(restored.State(), want) {
+		t.Error("snapshot and log did not rebuild the state")
+	}
+
+	//a crash after the snapshot but before the old segments were removed must not count them twice
+	old := walPath(dir, before[0], "wal")
+	ioutil.WriteFile(old, []byte("left over"), 0644)
+	restored = NewStats()
+	openWAL(t, &restored, dir).Close()
+	if !reflect.DeepEqual(restored.State(), want) {
+		t.Error("a covered segment was replayed")
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("the covered segment was not cleaned up")
+	}
+}
+
+//interval syncing runs in the background and stops on close
+func TestWAL_SyncInterval(t *testing.T) {
+	dir := t.TempDir()
+	st := NewStats()
+	wal := openWAL(t, &st, dir, WithSync(SyncInterval, time.Millisecond))
+	st.addAction(Sample{Action: "jump", Time: 100})
+	time.Sleep(10 * time.Millisecond)
+	if err := wal.Sync(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := wal.Close(); err != nil {
+		t.Error("closing twice failed")
+	}
+
+	if _, err := st.OpenWAL(filepath.Join(dir, "other"), WithSync(SyncInterval, 0)); err == nil {
+		t.Error("opened with no sync interval")
+	}
+	wal = openWAL(t, &st, filepath.Join(dir, "other"))
+	defer wal.Close()
+	if _, err := st.OpenWAL(dir); err == nil {
+		t.Error("opened a second log for the same stats")
+	}
+}
@@ -0,0 +1,162 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+//totals for all samples that arrived during one resolution sized slice of time
+type windowBucket struct {
+	//bucket number since the unix epoch, used to detect stale buckets in the ring
+	epoch      int64
+	numSamples uint64
+	totalTime  uint64
+}
+
+//ring buffer of time buckets for a single action
+type window struct {
+	buckets []windowBucket
+}
+
+func newWindow(numBuckets int) *window {
+	w := &window{buckets: make([]windowBucket, numBuckets)}
+	//mark every bucket stale so bucket 0 is not mistaken as current
+	for i := range w.buckets {
+		w.buckets[i].epoch = -1
+	}
+	return w
+}
+
+//returns the ring slot used by epoch
+func (w *window) slot(epoch int64) *windowBucket {
+	n := int64(len(w.buckets))
+	return &w.buckets[(epoch%n+n)%n]
+}
+
+//adds a time to the bucket for epoch, recycling the slot if it holds an older bucket.
+//A time for an epoch older than the slot already holds has fallen out of the window and is dropped
+func (w *window) add(epoch int64, time uint64) {
+	b := w.slot(epoch)
+	if b.epoch > epoch {
+		return
+	}
+	if b.epoch != epoch {
+		*b = windowBucket{epoch: epoch}
+	}
+	b.numSamples++
+	b.totalTime = addSaturating(b.totalTime, time)
+}
+
+//sums the buckets from the last numBuckets epochs up to and including epoch
+//the window totals stop at math.MaxUint64 rather than overflow when the lifetime totals have grown past it
+func (w *window) sum(epoch int64, numBuckets int) (numSamples uint64, totalTime uint64) {
+	for _, b := range w.buckets {
+		if b.epoch <= epoch && b.epoch > epoch-int64(numBuckets) {
+			numSamples += b.numSamples
+			totalTime = addSaturating(totalTime, b.totalTime)
+		}
+	}
+	return numSamples, totalTime
+}
+
+//whether sliding windows were enabled with WithWindow
+func (s *Stats) windowed() bool {
+	return s.windowSpan > 0 && s.windowResolution > 0
+}
+
+//most buckets each action keeps in its ring, about 24MiB per action
+const maxWindowBuckets = 1 << 20
+
+//number of buckets each action keeps in its ring
+func (s *Stats) windowBuckets() int {
+	return int((s.windowSpan + s.windowResolution - 1) / s.windowResolution)
+}
+
+//the bucket number for the current time
+func (s *Stats) windowEpoch() int64 {
+	return s.epochOf(s.clock.Now())
+}
+
+//the bucket number for a point in time
+func (s *Stats) epochOf(t time.Time) int64 {
+	return t.UnixNano() / int64(s.windowResolution)
+}
+
+//records an accepted sample in the window of the average, if windows are enabled.
+//Samples are placed by their timestamp, timestamps in the future count as now
+//and timestamps older than the span are left out of the window
+func (s *Stats) observeWindow(average *Average, sample Sample) {
+	if !s.windowed() {
+		return
+	}
+	now := s.windowEpoch()
+	epoch := now
+	if !sample.Timestamp.IsZero() && s.epochOf(sample.Timestamp) < now {
+		epoch = s.epochOf(sample.Timestamp)
+	}
+	if epoch <= now-int64(s.windowBuckets()) {
+		return
+	}
+	if average.window == nil {
+		average.window = newWindow(s.windowBuckets())
+	}
+	average.window.add(epoch, sample.Time)
+}
+
+//returns the averages of the samples added within the last d as a json array.
+//d is rounded up to the window resolution and cannot be longer than the configured span
+//actions with no samples in the window are left out, the rest are sorted by name
+func (s *Stats) GetStatsWindow(d time.Duration) (string, error) {
+	sliceAvg, err := s.getSampleAverageWindowSlice(d)
+	if err != nil {
+		return "", err
+	}
+	jsonString, err := json.Marshal(sliceAvg)
+	return string(jsonString), err
+}
+
+//traverses the stats map and averages the buckets of each action that fall within d
+func (s *Stats) getSampleAverageWindowSlice(d time.Duration) (AveragesSlice []SampleAverage, errorReturn error) {
+	if !s.windowed() {
+		return nil, errors.New("sliding windows are not enabled, create the stats with WithWindow")
+	}
+	if d <= 0 || d > s.windowSpan {
+		return nil, fmt.Errorf("window of %s is outside of the configured span of %s", d, s.windowSpan)
+	}
+	//catch any panics
+	defer func() {
+		if r := recover(); r != nil {
+			errorReturn = errors.New("error while getting window stats")
+		}
+	}()
+	AveragesSlice = make([]SampleAverage, 0)
+	numBuckets := int((d + s.windowResolution - 1) / s.windowResolution)
+
+	//thread safety
+	epoch := s.windowEpoch()
+	err := s.rangeAverages(func(action string, average *Average) {
+		if average.window == nil {
+			return
+		}
+		numSamples, totalTime := average.window.sum(epoch, numBuckets)
+		if numSamples == 0 {
+			return
+		}
+		AveragesSlice = append(AveragesSlice, SampleAverage{
+			Action:  action,
+			Average: average.inUnit(totalTime / numSamples),
+			Unit:    average.Unit,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	//same stable order as GetStats
+	sort.Slice(AveragesSlice, func(i, j int) bool {
+		return AveragesSlice[i].Action < AveragesSlice[j].Action
+	})
+	return AveragesSlice, errorReturn
+}
@@ -0,0 +1,132 @@
+package stats
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+//clock that only moves when the test says so
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+//helper to read the window averages into a map of action to average
+func windowAverages(t *testing.T, st *Stats, d time.Duration) map[string]uint64 {
+	t.Helper()
+	statsJson, err := st.GetStatsWindow(d)
+	if err != nil {
+		t.Fatalf("error from get stats window %s", err.Error())
+	}
+	haveStruct := make([]SampleAverage, 0)
+	json.Unmarshal([]byte(statsJson), &haveStruct)
+	have := make(map[string]uint64)
+	for _, h := range haveStruct {
+		have[h.Action] = h.Average
+	}
+	return have
+}
+
+//a recent regression must show in the short windows but not hide the lifetime average
+func TestGetStatsWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	st := NewStats(WithWindow(15*time.Minute, 10*time.Second), WithClock(clock))
+
+	//ten minutes of fast jumps, one per minute
+	for i := 0; i < 10; i++ {
+		st.AddAction("{\"action\":\"jump\", \"time\":100}")
+		clock.Advance(time.Minute)
+	}
+	//then a slow jump and a run
+	st.AddAction("{\"action\":\"jump\", \"time\":1200}")
+	st.AddAction("{\"action\":\"run\", \"time\":75}")
+
+	oneMinute := windowAverages(t, &st, time.Minute)
+	if oneMinute["jump"] != 1200 || oneMinute["run"] != 75 {
+		t.Errorf("wrong 1m averages %v", oneMinute)
+	}
+	//the last 5 minutes hold 4 fast jumps and the slow one
+	fiveMinutes := windowAverages(t, &st, 5*time.Minute)
+	if fiveMinutes["jump"] != 320 {
+		t.Errorf("wrong 5m average for jump %d", fiveMinutes["jump"])
+	}
+	fifteenMinutes := windowAverages(t, &st, 15*time.Minute)
+	if fifteenMinutes["jump"] != 200 {
+		t.Errorf("wrong 15m average for jump %d", fifteenMinutes["jump"])
+	}
+
+	//after the span has passed every bucket is stale
+	clock.Advance(16 * time.Minute)
+	if empty := windowAverages(t, &st, 15*time.Minute); len(empty) != 0 {
+		t.Errorf("expected no actions in the window but found %v", empty)
+	}
+
+	//the lifetime average is unaffected by the windows
+	statsJson, _ := st.GetStats()
+	haveStruct := make([]SampleAverage, 0)
+	json.Unmarshal([]byte(statsJson), &haveStruct)
+	for _, h := range haveStruct {
+		if h.Action == "jump" && h.Average != 200 {
+			t.Errorf("lifetime average for jump is %d", h.Average)
+		}
+	}
+}
+
+//ring slots are reused once the clock wraps past the span
+func TestGetStatsWindow_RingReuse(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	st := NewStats(WithWindow(time.Minute, 10*time.Second), WithClock(clock))
+	for i := 0; i < 100; i++ {
+		st.addAction(Sample{Action: "jump", Time: uint64(i)})
+		clock.Advance(10 * time.Second)
+	}
+	clock.Advance(-10 * time.Second)
+	//only the last 6 samples, 94..99, are in the minute
+	have := windowAverages(t, &st, time.Minute)
+	if have["jump"] != (94+95+96+97+98+99)/6 {
+		t.Errorf("wrong average after ring reuse %d", have["jump"])
+	}
+	have = windowAverages(t, &st, 10*time.Second)
+	if have["jump"] != 99 {
+		t.Errorf("wrong average for the current bucket %d", have["jump"])
+	}
+}
+
+//bad window requests are errors
+func TestGetStatsWindow_Errors(t *testing.T) {
+	st := NewStats()
+	if _, err := st.GetStatsWindow(time.Minute); err == nil {
+		t.Error("expected an error when windows are not enabled")
+	}
+
+	st = NewStats(WithWindow(time.Minute, time.Second))
+	if _, err := st.GetStatsWindow(2 * time.Minute); err == nil {
+		t.Error("expected an error for a window longer than the span")
+	}
+	if _, err := st.GetStatsWindow(0); err == nil {
+		t.Error("expected an error for an empty window")
+	}
+}
+
+//a window that would need more buckets than the limit gets a coarser resolution instead
+func TestWithWindow_MaxBuckets(t *testing.T) {
+	st := NewStats(WithWindow(24*time.Hour, time.Microsecond))
+	if buckets := st.windowBuckets(); buckets > maxWindowBuckets || buckets < maxWindowBuckets/2 {
+		t.Errorf("wrong number of buckets %d", buckets)
+	}
+	if st.windowSpan != 24*time.Hour {
+		t.Errorf("span changed to %s", st.windowSpan)
+	}
+	st = NewStats(WithWindow(time.Minute, time.Second))
+	if st.windowResolution != time.Second {
+		t.Errorf("resolution of a small window changed to %s", st.windowResolution)
+	}
+}
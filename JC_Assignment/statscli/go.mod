@@ -0,0 +1,7 @@
+module example.com/statscli
+
+go 1.16
+
+replace github.com/qwex23/JC_Assignment/stats => ../stats
+
+require github.com/qwex23/JC_Assignment/stats v0.0.0-00010101000000-000000000000
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qwex23/JC_Assignment/stats"
+)
+
+//reads samples from r and adds them to st one at a time, so inputs of any size use the same memory.
+//Rejected samples are passed to reject and do not stop the read. Returns the number of samples added
+type sampleReader func(st *stats.Stats, r io.Reader, reject func(line int, err error)) (int, error)
+
+//picks the reader for a format, or from the file name when format is empty
+func readerFor(format string, name string) (sampleReader, error) {
+	if format == "" {
+		format = "ndjson"
+		if strings.HasSuffix(strings.ToLower(name), ".csv") {
+			format = "csv"
+		}
+	}
+	switch format {
+	case "ndjson":
+		return readNDJSON, nil
+	case "csv":
+		return readCSV, nil
+	}
+	return nil, fmt.Errorf("unknown input format %q, must be ndjson or csv", format)
+}
+
+//one json sample per line, the same as the body of POST /actions
+func readNDJSON(st *stats.Stats, r io.Reader, reject func(line int, err error)) (int, error) {
+	return st.IngestNDJSONFunc(r, reject)
+}
+
+//where each field of a sample is in a csv row
+type csvColumns struct {
+	action    int
+	time      int
+	unit      int
+	timestamp int
+	//label name by column
+	labels map[int]string
+}
+
+//reads the header row. action and time are required, unit and timestamp are optional
+//and every other column is a label
+func parseHeader(header []string) (csvColumns, error) {
+	cols := csvColumns{action: -1, time: -1, unit: -1, timestamp: -1, labels: make(map[int]string)}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		switch strings.ToLower(name) {
+		case "action":
+			cols.action = i
+		case "time":
+			cols.time = i
+		case "unit":
+			cols.unit = i
+		case "timestamp":
+			cols.timestamp = i
+		default:
+			cols.labels[i] = name
+		}
+	}
+	if cols.action < 0 || cols.time < 0 {
+		return cols, errors.New("CSV header must have action and time columns")
+	}
+	return cols, nil
+}
+
+//builds a sample from a row, empty label cells are left out
+func (cols csvColumns) sample(record []string) (stats.Sample, error) {
+	sample := stats.Sample{Action: record[cols.action]}
+	timeField := strings.TrimSpace(record[cols.time])
+	t, err := strconv.ParseUint(timeField, 10, 64)
+	if err != nil {
+		if strings.HasPrefix(timeField, "-") {
+			return sample, fmt.Errorf("%w-> %s is negative", stats.ErrTimeOutOfRange, timeField)
+		}
+		return sample, errors.New("time is not a number-> " + err.Error())
+	}
+	sample.Time = t
+	if cols.unit >= 0 {
+		sample.Unit = strings.TrimSpace(record[cols.unit])
+	}
+	if cols.timestamp >= 0 && strings.TrimSpace(record[cols.timestamp]) != "" {
+		sample.Timestamp, err = time.Parse(time.RFC3339Nano, strings.TrimSpace(record[cols.timestamp]))
+		if err != nil {
+			return sample, errors.New("timestamp is not RFC 3339-> " + err.Error())
+		}
+	}
+	for i, name := range cols.labels {
+		if record[i] == "" {
+			continue
+		}
+		if sample.Labels == nil {
+			sample.Labels = make(map[string]string, len(cols.labels))
+		}
+		sample.Labels[name] = record[i]
+	}
+	return sample, nil
+}
+
+//a header row then one sample per row, e.g.
+//
+//	action,time,unit,host
+//	jump,100,ms,a
+//
+//Rows are numbered from 1 for the header, a quoted field can span lines
+func readCSV(st *stats.Stats, r io.Reader, reject func(line int, err error)) (int, error) {
+	reader := csv.NewReader(bufio.NewReaderSize(r, 1<<16))
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.New("CSV header is invalid-> " + err.Error())
+	}
+	cols, err := parseHeader(header)
+	if err != nil {
+		return 0, err
+	}
+
+	accepted := 0
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return accepted, nil
+		}
+		//a malformed row is skipped, the reader carries on with the next one
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			reject(row, err)
+			continue
+		}
+		if err != nil {
+			return accepted, err
+		}
+		sample, err := cols.sample(record)
+		if err == nil {
+			err = st.AddSample(sample)
+		}
+		if err != nil {
+			reject(row, err)
+			continue
+		}
+		accepted++
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/qwex23/JC_Assignment/stats"
+)
+
+//helper to read input with reader and collect the rejected lines
+func readAll(t *testing.T, read sampleReader, input io.Reader) (*stats.Stats, int, map[int]error) {
+	t.Helper()
+	st := stats.NewStats()
+	rejected := make(map[int]error)
+	accepted, err := read(&st, input, func(line int, err error) {
+		rejected[line] = err
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return &st, accepted, rejected
+}
+
+//bad lines are skipped and the rest are added
+func TestReadNDJSON(t *testing.T) {
+	input := "{\"action\":\"jump\", \"time\":100}\n\nnot json\n{\"action\":\"jump\", \"time\":-1}\n{\"action\":\"jump\", \"time\":200}"
+	st, accepted, rejected := readAll(t, readNDJSON, strings.NewReader(input))
+	if accepted != 2 || len(rejected) != 2 {
+		t.Fatalf("wrong counts %d %v", accepted, rejected)
+	}
+	if !errors.Is(rejected[3], stats.ErrInvalidJSON) || !errors.Is(rejected[4], stats.ErrTimeOutOfRange) {
+		t.Errorf("wrong rejected lines %v", rejected)
+	}
+	if jump, _ := st.Lookup("jump"); jump.AverageTime() != 150 {
+		t.Errorf("wrong average %+v", jump)
+	}
+}
+
+//extra columns are labels and malformed rows do not stop the read
+func TestReadCSV(t *testing.T) {
+	input := "action,time,unit,timestamp,host\n" +
+		"jump,100,ms,,a\n" +
+		"jump,200,ms,2021-07-01T12:00:00Z,b\n" +
+		"run,\"7\"5,ms,,a\n" +
+		"run,75\n" +
+		"run,-1,ms,,a\n" +
+		"run,x,ms,,a\n" +
+		"run,75,ms,yesterday,a\n" +
+		"run,75,days,,a\n" +
+		"run,75,ms,,\n"
+	st, accepted, rejected := readAll(t, readCSV, strings.NewReader(input))
+	if accepted != 3 {
+		t.Errorf("wrong accepted count %d", accepted)
+	}
+	for _, row := range []int{4, 5, 6, 7, 8, 9} {
+		if rejected[row] == nil {
+			t.Errorf("row %d was not rejected %v", row, rejected)
+		}
+	}
+	if !errors.Is(rejected[6], stats.ErrTimeOutOfRange) || !errors.Is(rejected[9], stats.ErrInvalidUnit) {
+		t.Errorf("wrong errors %v", rejected)
+	}
+	averages, _ := st.GetAverages(stats.GroupBy("host"))
+	if len(averages) != 3 || averages[1].Labels["host"] != "b" || averages[2].Labels["host"] != "" {
+		t.Errorf("wrong grouped averages %+v", averages)
+	}
+}
+
+func TestReadCSV_Header(t *testing.T) {
+	st := stats.NewStats()
+	for _, header := range []string{"action,host\n", "\"action\n"} {
+		if _, err := readCSV(&st, strings.NewReader(header), func(int, error) {}); err == nil {
+			t.Errorf("header %q was accepted", header)
+		}
+	}
+	if accepted, err := readCSV(&st, strings.NewReader(""), func(int, error) {}); accepted != 0 || err != nil {
+		t.Errorf("empty input returned %d %v", accepted, err)
+	}
+}
+
+//generates lines on demand so a large input is never held in memory
+type sampleGenerator struct {
+	lines int
+	next  int
+	buf   []byte
+}
+
+func (g *sampleGenerator) Read(p []byte) (int, error) {
+	for len(g.buf) < len(p) && g.next < g.lines {
+		g.buf = append(g.buf, fmt.Sprintf("{\"action\":\"Action%d\", \"time\":%d}\n", g.next%100, g.next%100)...)
+		g.next++
+	}
+	if len(g.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, g.buf)
+	g.buf = g.buf[n:]
+	return n, nil
+}
+
+//a large stream is read line by line
+func TestReadNDJSON_Stream(t *testing.T) {
+	st, accepted, rejected := readAll(t, readNDJSON, &sampleGenerator{lines: 500000})
+	if accepted != 500000 || len(rejected) != 0 {
+		t.Fatalf("wrong counts %d %d", accepted, len(rejected))
+	}
+	if action, _ := st.Lookup("Action42"); action.NumSamples != 5000 || action.AverageTime() != 42 {
+		t.Errorf("wrong totals %+v", action)
+	}
+}
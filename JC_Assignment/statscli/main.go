@@ -0,0 +1,157 @@
+//computes action averages offline from files of captured samples
+//
+//	statscli [flags] [file ...]
+//
+//reads NDJSON or CSV samples from each file, or stdin when there are none or the file is -,
+//and prints the averages as a table, JSON or CSV
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/qwex23/JC_Assignment/stats"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+//runs the tool and returns the exit code, 1 when an input could not be read and 2 for bad flags
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("statscli", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	input := flags.String("input", "", "format of the samples, ndjson or csv. Defaults to csv for .csv files and ndjson otherwise")
+	output := flags.String("output", "table", "format of the results, table, json or csv")
+	groupBy := flags.String("group", "", "label to group each action by")
+	sortBy := flags.String("sort", "name", "sort by name, avg or count")
+	desc := flags.Bool("desc", false, "sort in descending order")
+	top := flags.Int("top", 0, "only print the first n results, 0 for all")
+	rollup := flags.Int("rollup", 0, "roll actions up by the first n parts of their dotted names, 0 for no rollups")
+	prefix := flags.String("prefix", "", "only print actions starting with the prefix")
+	match := flags.String("match", "", "only print actions matching the regex")
+	unit := flags.String("unit", "", "show every average in ns, us, ms or s instead of the unit of each action")
+	duration := flags.Bool("duration", false, "add the averages as durations such as 1.5ms")
+	maxErrors := flags.Int("max-errors", 10, "rejected samples printed to stderr, the rest are only counted")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: statscli [flags] [file ...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	opts, err := queryOptions(*sortBy, *desc, *top, *rollup, *prefix, *match, *groupBy, *unit, *duration)
+	if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return 2
+	}
+	write, err := writerFor(*output)
+	if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return 2
+	}
+
+	st := stats.NewStats()
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	accepted, rejected := 0, 0
+	for _, name := range files {
+		read, err := readerFor(*input, name)
+		if err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			return 2
+		}
+		reject := func(line int, err error) {
+			rejected++
+			if rejected <= *maxErrors {
+				fmt.Fprintf(stderr, "%s:%d: %s\n", name, line, err.Error())
+			}
+		}
+		n, err := readFile(&st, name, stdin, read, reject)
+		accepted += n
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", name, err.Error())
+			return 1
+		}
+	}
+	if rejected > 0 {
+		fmt.Fprintf(stderr, "%d samples added, %d rejected\n", accepted, rejected)
+	}
+
+	averages, err := st.GetAverages(opts...)
+	if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return 1
+	}
+	if err := write(stdout, averages, *groupBy); err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return 1
+	}
+	return 0
+}
+
+//reads one file, - is stdin
+func readFile(st *stats.Stats, name string, stdin io.Reader, read sampleReader, reject func(line int, err error)) (int, error) {
+	if name == "-" {
+		return read(st, stdin, reject)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return read(st, f, reject)
+}
+
+func queryOptions(sortBy string, desc bool, top int, rollup int, prefix string, match string, groupBy string, unit string, duration bool) ([]stats.QueryOption, error) {
+	var field stats.SortField
+	switch sortBy {
+	case "name":
+		field = stats.SortByName
+	case "avg":
+		field = stats.SortByAverage
+	case "count":
+		field = stats.SortByCount
+	default:
+		return nil, errors.New("sort must be name, avg or count")
+	}
+	order := stats.Ascending
+	if desc {
+		order = stats.Descending
+	}
+	if top < 0 {
+		return nil, errors.New("top must not be negative")
+	}
+	if rollup < 0 {
+		return nil, errors.New("rollup must not be negative")
+	}
+	opts := []stats.QueryOption{stats.SortBy(field, order), stats.Limit(top), stats.Rollup(rollup), stats.Prefix(prefix)}
+	if match != "" {
+		re, err := regexp.Compile(match)
+		if err != nil {
+			return nil, errors.New("match is not a valid regex-> " + err.Error())
+		}
+		opts = append(opts, stats.Match(re))
+	}
+	if groupBy != "" {
+		opts = append(opts, stats.GroupBy(groupBy))
+	}
+	switch unit {
+	case "":
+	case "ns", "us", "ms", "s":
+		opts = append(opts, stats.InUnit(unit))
+	default:
+		return nil, errors.New("unit must be ns, us, ms or s")
+	}
+	if duration {
+		opts = append(opts, stats.AsDuration())
+	}
+	return opts, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//files and stdin are read together and the results are sorted and cut to the top n
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "samples.csv")
+	ioutil.WriteFile(csvPath, []byte("action,time\njump,100\nrun,75\nwalk,10\n"), 0644)
+	stdin := strings.NewReader("{\"action\":\"jump\", \"time\":200}\nbad\n")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-sort", "avg", "-desc", "-top", "2", "-output", "csv", csvPath, "-"}, stdin, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "action,avg\njump,150\nrun,75\n" {
+		t.Errorf("wrong output %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "-:2: ") || !strings.Contains(stderr.String(), "4 samples added, 1 rejected") {
+		t.Errorf("wrong stderr %q", stderr.String())
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"unknown flag", []string{"-nope"}, 2},
+		{"bad sort", []string{"-sort", "speed"}, 2},
+		{"bad output", []string{"-output", "xml"}, 2},
+		{"bad input", []string{"-input", "xml"}, 2},
+		{"bad regex", []string{"-match", "["}, 2},
+		{"bad unit", []string{"-unit", "days"}, 2},
+		{"bad rollup", []string{"-rollup", "-1"}, 2},
+		{"missing file", []string{filepath.Join(os.TempDir(), "does-not-exist.ndjson")}, 1},
+	}
+	for _, tc := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := run(tc.args, strings.NewReader(""), &stdout, &stderr); code != tc.want {
+			t.Errorf("%s exited with %d want %d: %s", tc.name, code, tc.want, stderr.String())
+		}
+	}
+}
+
+//samples in different units are averaged together and shown in the unit asked for
+func TestRun_Units(t *testing.T) {
+	stdin := strings.NewReader("action,time,unit\njump,1,ms\njump,500,us\njump,2,\n")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-input", "csv", "-output", "csv", "-unit", "ms", "-duration"}, stdin, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	if want := "action,avg,unit,duration\njump,1.1666666666666667,ms,1.166667ms\n"; stdout.String() != want {
+		t.Errorf("wrong output\nhave %q\nwant %q", stdout.String(), want)
+	}
+}
+
+//dotted action names roll up into their prefix
+func TestRun_Rollup(t *testing.T) {
+	stdin := strings.NewReader("{\"action\":\"api.get\", \"time\":100}\n{\"action\":\"api.put\", \"time\":300}\n{\"action\":\"web\", \"time\":5}\n")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-rollup", "1", "-output", "csv"}, stdin, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	if want := "action,avg\napi.*,200\nweb,5\n"; stdout.String() != want {
+		t.Errorf("wrong output\nhave %q\nwant %q", stdout.String(), want)
+	}
+}
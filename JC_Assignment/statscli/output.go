@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/qwex23/JC_Assignment/stats"
+)
+
+//writes the averages in a format, groupBy is the label column when grouping
+type resultWriter func(w io.Writer, averages []stats.SampleAverage, groupBy string) error
+
+func writerFor(format string) (resultWriter, error) {
+	switch format {
+	case "table":
+		return writeTable, nil
+	case "json":
+		return writeJSON, nil
+	case "csv":
+		return writeCSV, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, must be table, json or csv", format)
+}
+
+//the header and values of each row, with the label column only when grouping.
+//The unit and duration columns are only added when some average has one,
+//and avg is the exact value once the averages were converted to a unit
+func rows(averages []stats.SampleAverage, groupBy string) [][]string {
+	var units, durations, exact bool
+	for _, average := range averages {
+		units = units || average.Unit != ""
+		durations = durations || average.Duration != ""
+		exact = exact || average.Value != 0
+	}
+	header := []string{"action"}
+	if groupBy != "" {
+		header = append(header, groupBy)
+	}
+	header = append(header, "avg")
+	if units {
+		header = append(header, "unit")
+	}
+	if durations {
+		header = append(header, "duration")
+	}
+	out := [][]string{header}
+	for _, average := range averages {
+		row := []string{average.Action}
+		if groupBy != "" {
+			row = append(row, average.Labels[groupBy])
+		}
+		if exact {
+			row = append(row, strconv.FormatFloat(average.Value, 'f', -1, 64))
+		} else {
+			row = append(row, strconv.FormatUint(average.Average, 10))
+		}
+		if units {
+			row = append(row, average.Unit)
+		}
+		if durations {
+			row = append(row, average.Duration)
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+//aligned columns for reading in a terminal
+func writeTable(w io.Writer, averages []stats.SampleAverage, groupBy string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, row := range rows(averages, groupBy) {
+		for i, field := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, field)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+//the same json array as GetStats
+func writeJSON(w io.Writer, averages []stats.SampleAverage, groupBy string) error {
+	return json.NewEncoder(w).Encode(averages)
+}
+
+func writeCSV(w io.Writer, averages []stats.SampleAverage, groupBy string) error {
+	cw := csv.NewWriter(w)
+	cw.WriteAll(rows(averages, groupBy))
+	return cw.Error()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/qwex23/JC_Assignment/stats"
+)
+
+func TestWriters(t *testing.T) {
+	averages := []stats.SampleAverage{
+		{Action: "jump", Average: 150, Labels: map[string]string{"host": "a"}},
+		{Action: "run", Average: 75, Labels: map[string]string{"host": "b,c"}},
+	}
+	tests := []struct {
+		format  string
+		groupBy string
+		want    string
+	}{
+		{"table", "", "action  avg\njump    150\nrun     75\n"},
+		{"table", "host", "action  host  avg\njump    a     150\nrun     b,c   75\n"},
+		{"csv", "host", "action,host,avg\njump,a,150\nrun,\"b,c\",75\n"},
+		{"json", "host", `[{"action":"jump","avg":150,"labels":{"host":"a"}},{"action":"run","avg":75,"labels":{"host":"b,c"}}]` + "\n"},
+	}
+	for _, tc := range tests {
+		write, err := writerFor(tc.format)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		var buf bytes.Buffer
+		if err := write(&buf, averages, tc.groupBy); err != nil {
+			t.Fatal(err.Error())
+		}
+		if buf.String() != tc.want {
+			t.Errorf("%s grouped by %q\nhave %q\nwant %q", tc.format, tc.groupBy, buf.String(), tc.want)
+		}
+	}
+
+	//converted averages are written exactly, with their unit and duration
+	var buf bytes.Buffer
+	writeCSV(&buf, []stats.SampleAverage{
+		{Action: "jump", Average: 1, Unit: "ms", Value: 1.5, Duration: "1.5ms"},
+		{Action: "run", Average: 75, Value: 75},
+	}, "")
+	if want := "action,avg,unit,duration\njump,1.5,ms,1.5ms\nrun,75,,\n"; buf.String() != want {
+		t.Errorf("wrong converted csv\nhave %q\nwant %q", buf.String(), want)
+	}
+	if _, err := writerFor("xml"); err == nil {
+		t.Error("unknown format was accepted")
+	}
+}
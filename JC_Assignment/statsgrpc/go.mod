@@ -0,0 +1,19 @@
+module github.com/qwex23/JC_Assignment/statsgrpc
+
+go 1.24.0
+
+replace github.com/qwex23/JC_Assignment/stats => ../stats
+
+require (
+	github.com/qwex23/JC_Assignment/stats v0.0.0-00010101000000-000000000000
+	google.golang.org/grpc v1.79.0
+	google.golang.org/protobuf v1.36.11
+)
+
+require (
+	go.etcd.io/bbolt v1.3.6 // indirect
+	golang.org/x/net v0.48.0 // indirect
+	golang.org/x/sys v0.39.0 // indirect
+	golang.org/x/text v0.32.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20251202230838-ff82c1b0f217 // indirect
+)
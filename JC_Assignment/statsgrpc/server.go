@@ -0,0 +1,169 @@
+//gRPC service in front of a stats struct, for services that do not speak JSON
+package statsgrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative statspb/stats.proto
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+
+	"github.com/qwex23/JC_Assignment/stats"
+	"github.com/qwex23/JC_Assignment/statsgrpc/statspb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//implements statspb.StatsServer on top of a stats struct
+type Server struct {
+	statspb.UnimplementedStatsServer
+	st *stats.Stats
+}
+
+//creates a server that adds to and reads from st.
+//Register it with statspb.RegisterStatsServer(grpcServer, statsgrpc.NewServer(&st))
+func NewServer(st *stats.Stats) *Server {
+	return &Server{st: st}
+}
+
+//adds a single sample
+func (srv *Server) Record(ctx context.Context, sample *statspb.Sample) (*statspb.RecordResponse, error) {
+	if err := srv.st.AddSample(toSample(sample)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &statspb.RecordResponse{}, nil
+}
+
+//most rejected samples listed in a RecordStream response, the rest are only counted
+//so a stream of bad samples cannot grow the response without bound
+const maxRejected = 100
+
+//adds every sample on the stream, rejected samples are reported once the client closes it
+func (srv *Server) RecordStream(stream statspb.Stats_RecordStreamServer) error {
+	resp := &statspb.RecordStreamResponse{}
+	for index := uint64(1); ; index++ {
+		sample, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(resp)
+		}
+		if err != nil {
+			return err
+		}
+		if err := srv.st.AddSample(toSample(sample)); err != nil {
+			resp.RejectedCount++
+			if len(resp.Rejected) < maxRejected {
+				resp.Rejected = append(resp.Rejected, &statspb.SampleError{Index: index, Error: err.Error()})
+			}
+			continue
+		}
+		resp.Accepted++
+	}
+}
+
+//returns the averages with the sorting, paging and filters of the request
+func (srv *Server) GetStats(ctx context.Context, req *statspb.GetStatsRequest) (*statspb.GetStatsResponse, error) {
+	opts, err := queryOptions(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	averages, err := srv.st.GetAverages(opts...)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &statspb.GetStatsResponse{Averages: make([]*statspb.ActionAverage, len(averages))}
+	for i, average := range averages {
+		resp.Averages[i] = &statspb.ActionAverage{
+			Action:   average.Action,
+			Avg:      average.Average,
+			Ewma:     average.EWMA,
+			Labels:   average.Labels,
+			Unit:     average.Unit,
+			Value:    average.Value,
+			Duration: average.Duration,
+		}
+	}
+	return resp, nil
+}
+
+func toSample(sample *statspb.Sample) stats.Sample {
+	s := stats.Sample{
+		Action: sample.GetAction(),
+		Time:   sample.GetTime(),
+		Unit:   sample.GetUnit(),
+		Labels: sample.GetLabels(),
+	}
+	if sample.GetTimestamp() != nil {
+		s.Timestamp = sample.GetTimestamp().AsTime()
+	}
+	return s
+}
+
+//errors the stats return for a sample or query that is not valid
+var invalidArguments = []error{
+	stats.ErrInvalidJSON,
+	stats.ErrUnknownField,
+	stats.ErrEmptyAction,
+	stats.ErrActionTooLong,
+	stats.ErrActionCharset,
+	stats.ErrActionNotAllowed,
+	stats.ErrTimeOutOfRange,
+	stats.ErrInvalidUnit,
+	stats.ErrUnitMismatch,
+}
+
+//validation errors are a problem with the request and a total that is full is out of room,
+//anything else such as a failing storage is a problem with the server
+func toStatus(err error) error {
+	if errors.Is(err, stats.ErrOverflow) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	for _, invalid := range invalidArguments {
+		if errors.Is(err, invalid) {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func queryOptions(req *statspb.GetStatsRequest) ([]stats.QueryOption, error) {
+	var field stats.SortField
+	switch req.GetSort() {
+	case statspb.GetStatsRequest_NAME:
+		field = stats.SortByName
+	case statspb.GetStatsRequest_AVERAGE:
+		field = stats.SortByAverage
+	case statspb.GetStatsRequest_COUNT:
+		field = stats.SortByCount
+	default:
+		return nil, errors.New("unknown sort field " + req.GetSort().String())
+	}
+	order := stats.Ascending
+	if req.GetDescending() {
+		order = stats.Descending
+	}
+	opts := []stats.QueryOption{
+		stats.SortBy(field, order),
+		stats.Limit(int(req.GetLimit())),
+		stats.Offset(int(req.GetOffset())),
+		stats.Prefix(req.GetPrefix()),
+		stats.Rollup(int(req.GetRollup())),
+	}
+	if req.GetGroupBy() != "" {
+		opts = append(opts, stats.GroupBy(req.GetGroupBy()))
+	}
+	if req.GetMatch() != "" {
+		re, err := regexp.Compile(req.GetMatch())
+		if err != nil {
+			return nil, errors.New("match is not a valid regex-> " + err.Error())
+		}
+		opts = append(opts, stats.Match(re))
+	}
+	if req.GetUnit() != "" {
+		opts = append(opts, stats.InUnit(req.GetUnit()))
+	}
+	if req.GetDuration() {
+		opts = append(opts, stats.AsDuration())
+	}
+	return opts, nil
+}
@@ -0,0 +1,241 @@
+package statsgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/qwex23/JC_Assignment/stats"
+	"github.com/qwex23/JC_Assignment/statsgrpc/statspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+//helper to start a server on an in memory listener and connect a client to it
+func newTestClient(t *testing.T) statspb.StatsClient {
+	t.Helper()
+	st := stats.NewStats()
+	return newTestClientFor(t, &st)
+}
+
+//helper to serve st on an in memory listener and connect a client to it
+func newTestClientFor(t *testing.T, st *stats.Stats) statspb.StatsClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	statspb.RegisterStatsServer(grpcServer, NewServer(st))
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+	return statspb.NewStatsClient(conn)
+}
+
+//helper to read the averages into a map of action to average
+func averagesByAction(resp *statspb.GetStatsResponse) map[string]uint64 {
+	have := make(map[string]uint64)
+	for _, average := range resp.GetAverages() {
+		have[average.GetAction()] = average.GetAvg()
+	}
+	return have
+}
+
+//test the hello world calls through the service
+func TestRecordAndGetStats(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	for _, sample := range []*statspb.Sample{
+		{Action: "jump", Time: 100},
+		{Action: "run", Time: 75},
+		{Action: "jump", Time: 200},
+	} {
+		if _, err := client.Record(ctx, sample); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	resp, err := client.GetStats(ctx, &statspb.GetStatsRequest{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	have := averagesByAction(resp)
+	if len(have) != 2 || have["jump"] != 150 || have["run"] != 75 {
+		t.Fatalf("wrong stats %v", have)
+	}
+	if resp.GetAverages()[0].GetAction() != "jump" {
+		t.Errorf("stats are not sorted by name %v", resp.GetAverages())
+	}
+}
+
+//rejected samples come back as status errors
+func TestRecord_Errors(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		sample *statspb.Sample
+		want   codes.Code
+	}{
+		{"bad unit", &statspb.Sample{Action: "jump", Time: 1, Unit: "days"}, codes.InvalidArgument},
+		{"first sample", &statspb.Sample{Action: "jump", Time: 1 << 63}, codes.OK},
+		{"overflow", &statspb.Sample{Action: "jump", Time: 1 << 63}, codes.ResourceExhausted},
+	}
+	for _, tc := range tests {
+		_, err := client.Record(ctx, tc.sample)
+		if have := status.Code(err); have != tc.want {
+			t.Errorf("%s returned %s want %s", tc.name, have, tc.want)
+		}
+	}
+}
+
+//a storage that fails every call
+type brokenStorage struct{}
+
+func (brokenStorage) Store(shard int, shards int) stats.Store {
+	return brokenStore{}
+}
+
+type brokenStore struct{}
+
+var errBroken = errors.New("storage is down")
+
+func (brokenStore) Get(action string) (*stats.Average, error)       { return nil, errBroken }
+func (brokenStore) Put(action string, average *stats.Average) error { return errBroken }
+func (brokenStore) Delete(action string) error                      { return errBroken }
+func (brokenStore) Range(fn func(action string, average *stats.Average) error) error {
+	return errBroken
+}
+func (brokenStore) Len() int     { return 1 }
+func (brokenStore) Clear() error { return errBroken }
+
+//errors that are not a problem with the request are internal
+func TestErrors_Internal(t *testing.T) {
+	st := stats.NewStats(stats.WithStorage(brokenStorage{}))
+	client := newTestClientFor(t, &st)
+	ctx := context.Background()
+	if _, err := client.Record(ctx, &statspb.Sample{Action: "jump", Time: 1}); status.Code(err) != codes.Internal {
+		t.Errorf("failing storage on record returned %v", err)
+	}
+	if _, err := client.Record(ctx, &statspb.Sample{Action: "jump", Time: 1, Unit: "days"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("bad unit returned %v", err)
+	}
+	if _, err := client.GetStats(ctx, &statspb.GetStatsRequest{}); status.Code(err) != codes.Internal {
+		t.Errorf("failing storage on get stats returned %v", err)
+	}
+}
+
+//every valid sample on the stream is added and the rest are reported
+func TestRecordStream(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	stream, err := client.RecordStream(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, sample := range []*statspb.Sample{
+		{Action: "jump", Time: 100, Labels: map[string]string{"host": "a"}},
+		{Action: "jump", Time: 100, Unit: "days"},
+		{Action: "jump", Time: 200, Labels: map[string]string{"host": "b"}},
+		{Action: "run", Time: 75},
+	} {
+		if err := stream.Send(sample); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	report, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if report.GetAccepted() != 3 || report.GetRejectedCount() != 1 || len(report.GetRejected()) != 1 || report.GetRejected()[0].GetIndex() != 2 {
+		t.Fatalf("wrong report %v", report)
+	}
+
+	resp, err := client.GetStats(ctx, &statspb.GetStatsRequest{GroupBy: "host", Prefix: "j", Sort: statspb.GetStatsRequest_AVERAGE, Descending: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	averages := resp.GetAverages()
+	if len(averages) != 2 || averages[0].GetAvg() != 200 || averages[0].GetLabels()["host"] != "b" {
+		t.Fatalf("wrong grouped stats %v", averages)
+	}
+
+	if _, err := client.GetStats(ctx, &statspb.GetStatsRequest{Match: "["}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("bad regex returned %v", err)
+	}
+}
+
+//averages can be asked for in any unit or as durations
+func TestGetStats_Units(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	for _, sample := range []*statspb.Sample{
+		{Action: "jump", Time: 1, Unit: "ms"},
+		{Action: "jump", Time: 500, Unit: "us"},
+	} {
+		if _, err := client.Record(ctx, sample); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	resp, err := client.GetStats(ctx, &statspb.GetStatsRequest{Unit: "us", Duration: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	jump := resp.GetAverages()[0]
+	if jump.GetAvg() != 750 || jump.GetValue() != 750 || jump.GetUnit() != "us" || jump.GetDuration() != "750µs" {
+		t.Errorf("wrong average %v", jump)
+	}
+
+	if _, err := client.Record(ctx, &statspb.Sample{Action: "jump.high", Time: 2, Unit: "ms"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	resp, err = client.GetStats(ctx, &statspb.GetStatsRequest{Rollup: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if averages := resp.GetAverages(); len(averages) != 2 || averages[1].GetAction() != "jump.*" || averages[1].GetAvg() != 2 {
+		t.Errorf("wrong rollup %v", averages)
+	}
+	if _, err := client.GetStats(ctx, &statspb.GetStatsRequest{Unit: "days"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("bad unit returned %v", err)
+	}
+}
+
+//only the first rejected samples are listed, all of them are counted
+func TestRecordStream_MaxRejected(t *testing.T) {
+	client := newTestClient(t)
+	stream, err := client.RecordStream(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 0; i < maxRejected+50; i++ {
+		if err := stream.Send(&statspb.Sample{Action: fmt.Sprint("jump", i), Time: 1, Unit: "days"}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err := stream.Send(&statspb.Sample{Action: "run", Time: 1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	report, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if report.GetAccepted() != 1 || report.GetRejectedCount() != maxRejected+50 || len(report.GetRejected()) != maxRejected {
+		t.Fatalf("wrong report accepted %d rejected %d listed %d", report.GetAccepted(), report.GetRejectedCount(), len(report.GetRejected()))
+	}
+	if last := report.GetRejected()[maxRejected-1]; last.GetIndex() != maxRejected {
+		t.Errorf("wrong last listed sample %v", last)
+	}
+}
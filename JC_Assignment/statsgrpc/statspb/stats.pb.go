@@ -0,0 +1,694 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: statspb/stats.proto
+
+package statspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetStatsRequest_SortField int32
+
+const (
+	GetStatsRequest_NAME    GetStatsRequest_SortField = 0
+	GetStatsRequest_AVERAGE GetStatsRequest_SortField = 1
+	GetStatsRequest_COUNT   GetStatsRequest_SortField = 2
+)
+
+// Enum value maps for GetStatsRequest_SortField.
+var (
+	GetStatsRequest_SortField_name = map[int32]string{
+		0: "NAME",
+		1: "AVERAGE",
+		2: "COUNT",
+	}
+	GetStatsRequest_SortField_value = map[string]int32{
+		"NAME":    0,
+		"AVERAGE": 1,
+		"COUNT":   2,
+	}
+)
+
+func (x GetStatsRequest_SortField) Enum() *GetStatsRequest_SortField {
+	p := new(GetStatsRequest_SortField)
+	*p = x
+	return p
+}
+
+func (x GetStatsRequest_SortField) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (GetStatsRequest_SortField) Descriptor() protoreflect.EnumDescriptor {
+	return file_statspb_stats_proto_enumTypes[0].Descriptor()
+}
+
+func (GetStatsRequest_SortField) Type() protoreflect.EnumType {
+	return &file_statspb_stats_proto_enumTypes[0]
+}
+
+func (x GetStatsRequest_SortField) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use GetStatsRequest_SortField.Descriptor instead.
+func (GetStatsRequest_SortField) EnumDescriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{4, 0}
+}
+
+type Sample struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Action string                 `protobuf:"bytes,1,opt,name=action,proto3" json:"action,omitempty"`
+	Time   uint64                 `protobuf:"varint,2,opt,name=time,proto3" json:"time,omitempty"`
+	// Unit of time, one of ns, us, ms or s.
+	Unit string `protobuf:"bytes,3,opt,name=unit,proto3" json:"unit,omitempty"`
+	// When the sample was taken, defaults to when it is received.
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Sample) Reset() {
+	*x = Sample{}
+	mi := &file_statspb_stats_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Sample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Sample) ProtoMessage() {}
+
+func (x *Sample) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Sample.ProtoReflect.Descriptor instead.
+func (*Sample) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Sample) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *Sample) GetTime() uint64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *Sample) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *Sample) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Sample) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type RecordResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordResponse) Reset() {
+	*x = RecordResponse{}
+	mi := &file_statspb_stats_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordResponse) ProtoMessage() {}
+
+func (x *RecordResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordResponse.ProtoReflect.Descriptor instead.
+func (*RecordResponse) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{1}
+}
+
+type RecordStreamResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Accepted uint64                 `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	// The first 100 rejected samples, later ones are only counted.
+	Rejected []*SampleError `protobuf:"bytes,2,rep,name=rejected,proto3" json:"rejected,omitempty"`
+	// Number of rejected samples, listed or not.
+	RejectedCount uint64 `protobuf:"varint,3,opt,name=rejected_count,json=rejectedCount,proto3" json:"rejected_count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordStreamResponse) Reset() {
+	*x = RecordStreamResponse{}
+	mi := &file_statspb_stats_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordStreamResponse) ProtoMessage() {}
+
+func (x *RecordStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordStreamResponse.ProtoReflect.Descriptor instead.
+func (*RecordStreamResponse) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RecordStreamResponse) GetAccepted() uint64 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *RecordStreamResponse) GetRejected() []*SampleError {
+	if x != nil {
+		return x.Rejected
+	}
+	return nil
+}
+
+func (x *RecordStreamResponse) GetRejectedCount() uint64 {
+	if x != nil {
+		return x.RejectedCount
+	}
+	return 0
+}
+
+type SampleError struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Position of the sample in the stream, counting from 1.
+	Index         uint64 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Error         string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SampleError) Reset() {
+	*x = SampleError{}
+	mi := &file_statspb_stats_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SampleError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SampleError) ProtoMessage() {}
+
+func (x *SampleError) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SampleError.ProtoReflect.Descriptor instead.
+func (*SampleError) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SampleError) GetIndex() uint64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *SampleError) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type GetStatsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Label to group each action by.
+	GroupBy    string                    `protobuf:"bytes,1,opt,name=group_by,json=groupBy,proto3" json:"group_by,omitempty"`
+	Sort       GetStatsRequest_SortField `protobuf:"varint,2,opt,name=sort,proto3,enum=stats.GetStatsRequest_SortField" json:"sort,omitempty"`
+	Descending bool                      `protobuf:"varint,3,opt,name=descending,proto3" json:"descending,omitempty"`
+	// Most averages returned and averages skipped after sorting, 0 for no limit.
+	Limit  uint32 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset uint32 `protobuf:"varint,5,opt,name=offset,proto3" json:"offset,omitempty"`
+	// Only actions starting with prefix and matching the regex match are returned.
+	Prefix string `protobuf:"bytes,6,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Match  string `protobuf:"bytes,7,opt,name=match,proto3" json:"match,omitempty"`
+	// Unit to show every average in, one of ns, us, ms or s. Empty for the unit of each action.
+	Unit string `protobuf:"bytes,8,opt,name=unit,proto3" json:"unit,omitempty"`
+	// Adds the average as a Go duration string such as 1.5ms.
+	Duration bool `protobuf:"varint,9,opt,name=duration,proto3" json:"duration,omitempty"`
+	// Rolls actions up by the first rollup parts of their dotted names, e.g. checkout.* for 1. 0 for no rollups.
+	Rollup        uint32 `protobuf:"varint,10,opt,name=rollup,proto3" json:"rollup,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsRequest) Reset() {
+	*x = GetStatsRequest{}
+	mi := &file_statspb_stats_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsRequest) ProtoMessage() {}
+
+func (x *GetStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetStatsRequest) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetStatsRequest) GetGroupBy() string {
+	if x != nil {
+		return x.GroupBy
+	}
+	return ""
+}
+
+func (x *GetStatsRequest) GetSort() GetStatsRequest_SortField {
+	if x != nil {
+		return x.Sort
+	}
+	return GetStatsRequest_NAME
+}
+
+func (x *GetStatsRequest) GetDescending() bool {
+	if x != nil {
+		return x.Descending
+	}
+	return false
+}
+
+func (x *GetStatsRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *GetStatsRequest) GetOffset() uint32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *GetStatsRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *GetStatsRequest) GetMatch() string {
+	if x != nil {
+		return x.Match
+	}
+	return ""
+}
+
+func (x *GetStatsRequest) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *GetStatsRequest) GetDuration() bool {
+	if x != nil {
+		return x.Duration
+	}
+	return false
+}
+
+func (x *GetStatsRequest) GetRollup() uint32 {
+	if x != nil {
+		return x.Rollup
+	}
+	return 0
+}
+
+type GetStatsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Averages      []*ActionAverage       `protobuf:"bytes,1,rep,name=averages,proto3" json:"averages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsResponse) Reset() {
+	*x = GetStatsResponse{}
+	mi := &file_statspb_stats_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsResponse) ProtoMessage() {}
+
+func (x *GetStatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsResponse.ProtoReflect.Descriptor instead.
+func (*GetStatsResponse) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetStatsResponse) GetAverages() []*ActionAverage {
+	if x != nil {
+		return x.Averages
+	}
+	return nil
+}
+
+type ActionAverage struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Action string                 `protobuf:"bytes,1,opt,name=action,proto3" json:"action,omitempty"`
+	Avg    uint64                 `protobuf:"varint,2,opt,name=avg,proto3" json:"avg,omitempty"`
+	// Only set when the stats keep a moving average.
+	Ewma float64 `protobuf:"fixed64,3,opt,name=ewma,proto3" json:"ewma,omitempty"`
+	// The label values of the group, only set when grouping.
+	Labels map[string]string `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Unit of avg, value and ewma, empty for actions without a unit.
+	Unit string `protobuf:"bytes,5,opt,name=unit,proto3" json:"unit,omitempty"`
+	// The average without truncating, only set when the request has a unit.
+	Value float64 `protobuf:"fixed64,6,opt,name=value,proto3" json:"value,omitempty"`
+	// Only set when the request asks for durations.
+	Duration      string `protobuf:"bytes,7,opt,name=duration,proto3" json:"duration,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ActionAverage) Reset() {
+	*x = ActionAverage{}
+	mi := &file_statspb_stats_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ActionAverage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActionAverage) ProtoMessage() {}
+
+func (x *ActionAverage) ProtoReflect() protoreflect.Message {
+	mi := &file_statspb_stats_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActionAverage.ProtoReflect.Descriptor instead.
+func (*ActionAverage) Descriptor() ([]byte, []int) {
+	return file_statspb_stats_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ActionAverage) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *ActionAverage) GetAvg() uint64 {
+	if x != nil {
+		return x.Avg
+	}
+	return 0
+}
+
+func (x *ActionAverage) GetEwma() float64 {
+	if x != nil {
+		return x.Ewma
+	}
+	return 0
+}
+
+func (x *ActionAverage) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *ActionAverage) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *ActionAverage) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *ActionAverage) GetDuration() string {
+	if x != nil {
+		return x.Duration
+	}
+	return ""
+}
+
+var File_statspb_stats_proto protoreflect.FileDescriptor
+
+const file_statspb_stats_proto_rawDesc = "" +
+	"\n" +
+	"\x13statspb/stats.proto\x12\x05stats\x1a\x1fgoogle/protobuf/timestamp.proto\"\xf0\x01\n" +
+	"\x06Sample\x12\x16\n" +
+	"\x06action\x18\x01 \x01(\tR\x06action\x12\x12\n" +
+	"\x04time\x18\x02 \x01(\x04R\x04time\x12\x12\n" +
+	"\x04unit\x18\x03 \x01(\tR\x04unit\x128\n" +
+	"\ttimestamp\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x121\n" +
+	"\x06labels\x18\x05 \x03(\v2\x19.stats.Sample.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x10\n" +
+	"\x0eRecordResponse\"\x89\x01\n" +
+	"\x14RecordStreamResponse\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\x04R\baccepted\x12.\n" +
+	"\brejected\x18\x02 \x03(\v2\x12.stats.SampleErrorR\brejected\x12%\n" +
+	"\x0erejected_count\x18\x03 \x01(\x04R\rrejectedCount\"9\n" +
+	"\vSampleError\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x04R\x05index\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"\xd5\x02\n" +
+	"\x0fGetStatsRequest\x12\x19\n" +
+	"\bgroup_by\x18\x01 \x01(\tR\agroupBy\x124\n" +
+	"\x04sort\x18\x02 \x01(\x0e2 .stats.GetStatsRequest.SortFieldR\x04sort\x12\x1e\n" +
+	"\n" +
+	"descending\x18\x03 \x01(\bR\n" +
+	"descending\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\rR\x05limit\x12\x16\n" +
+	"\x06offset\x18\x05 \x01(\rR\x06offset\x12\x16\n" +
+	"\x06prefix\x18\x06 \x01(\tR\x06prefix\x12\x14\n" +
+	"\x05match\x18\a \x01(\tR\x05match\x12\x12\n" +
+	"\x04unit\x18\b \x01(\tR\x04unit\x12\x1a\n" +
+	"\bduration\x18\t \x01(\bR\bduration\x12\x16\n" +
+	"\x06rollup\x18\n" +
+	" \x01(\rR\x06rollup\"-\n" +
+	"\tSortField\x12\b\n" +
+	"\x04NAME\x10\x00\x12\v\n" +
+	"\aAVERAGE\x10\x01\x12\t\n" +
+	"\x05COUNT\x10\x02\"D\n" +
+	"\x10GetStatsResponse\x120\n" +
+	"\baverages\x18\x01 \x03(\v2\x14.stats.ActionAverageR\baverages\"\x88\x02\n" +
+	"\rActionAverage\x12\x16\n" +
+	"\x06action\x18\x01 \x01(\tR\x06action\x12\x10\n" +
+	"\x03avg\x18\x02 \x01(\x04R\x03avg\x12\x12\n" +
+	"\x04ewma\x18\x03 \x01(\x01R\x04ewma\x128\n" +
+	"\x06labels\x18\x04 \x03(\v2 .stats.ActionAverage.LabelsEntryR\x06labels\x12\x12\n" +
+	"\x04unit\x18\x05 \x01(\tR\x04unit\x12\x14\n" +
+	"\x05value\x18\x06 \x01(\x01R\x05value\x12\x1a\n" +
+	"\bduration\x18\a \x01(\tR\bduration\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\xb2\x01\n" +
+	"\x05Stats\x12.\n" +
+	"\x06Record\x12\r.stats.Sample\x1a\x15.stats.RecordResponse\x12<\n" +
+	"\fRecordStream\x12\r.stats.Sample\x1a\x1b.stats.RecordStreamResponse(\x01\x12;\n" +
+	"\bGetStats\x12\x16.stats.GetStatsRequest\x1a\x17.stats.GetStatsResponseB3Z1github.com/qwex23/JC_Assignment/statsgrpc/statspbb\x06proto3"
+
+var (
+	file_statspb_stats_proto_rawDescOnce sync.Once
+	file_statspb_stats_proto_rawDescData []byte
+)
+
+func file_statspb_stats_proto_rawDescGZIP() []byte {
+	file_statspb_stats_proto_rawDescOnce.Do(func() {
+		file_statspb_stats_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_statspb_stats_proto_rawDesc), len(file_statspb_stats_proto_rawDesc)))
+	})
+	return file_statspb_stats_proto_rawDescData
+}
+
+var file_statspb_stats_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_statspb_stats_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_statspb_stats_proto_goTypes = []any{
+	(GetStatsRequest_SortField)(0), // 0: stats.GetStatsRequest.SortField
+	(*Sample)(nil),                 // 1: stats.Sample
+	(*RecordResponse)(nil),         // 2: stats.RecordResponse
+	(*RecordStreamResponse)(nil),   // 3: stats.RecordStreamResponse
+	(*SampleError)(nil),            // 4: stats.SampleError
+	(*GetStatsRequest)(nil),        // 5: stats.GetStatsRequest
+	(*GetStatsResponse)(nil),       // 6: stats.GetStatsResponse
+	(*ActionAverage)(nil),          // 7: stats.ActionAverage
+	nil,                            // 8: stats.Sample.LabelsEntry
+	nil,                            // 9: stats.ActionAverage.LabelsEntry
+	(*timestamppb.Timestamp)(nil),  // 10: google.protobuf.Timestamp
+}
+var file_statspb_stats_proto_depIdxs = []int32{
+	10, // 0: stats.Sample.timestamp:type_name -> google.protobuf.Timestamp
+	8,  // 1: stats.Sample.labels:type_name -> stats.Sample.LabelsEntry
+	4,  // 2: stats.RecordStreamResponse.rejected:type_name -> stats.SampleError
+	0,  // 3: stats.GetStatsRequest.sort:type_name -> stats.GetStatsRequest.SortField
+	7,  // 4: stats.GetStatsResponse.averages:type_name -> stats.ActionAverage
+	9,  // 5: stats.ActionAverage.labels:type_name -> stats.ActionAverage.LabelsEntry
+	1,  // 6: stats.Stats.Record:input_type -> stats.Sample
+	1,  // 7: stats.Stats.RecordStream:input_type -> stats.Sample
+	5,  // 8: stats.Stats.GetStats:input_type -> stats.GetStatsRequest
+	2,  // 9: stats.Stats.Record:output_type -> stats.RecordResponse
+	3,  // 10: stats.Stats.RecordStream:output_type -> stats.RecordStreamResponse
+	6,  // 11: stats.Stats.GetStats:output_type -> stats.GetStatsResponse
+	9,  // [9:12] is the sub-list for method output_type
+	6,  // [6:9] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_statspb_stats_proto_init() }
+func file_statspb_stats_proto_init() {
+	if File_statspb_stats_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_statspb_stats_proto_rawDesc), len(file_statspb_stats_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_statspb_stats_proto_goTypes,
+		DependencyIndexes: file_statspb_stats_proto_depIdxs,
+		EnumInfos:         file_statspb_stats_proto_enumTypes,
+		MessageInfos:      file_statspb_stats_proto_msgTypes,
+	}.Build()
+	File_statspb_stats_proto = out.File
+	file_statspb_stats_proto_goTypes = nil
+	file_statspb_stats_proto_depIdxs = nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultIssuer     = "doggo-collector"
+	defaultAccessTTL  = 15 * time.Minute
+	defaultRefreshTTL = 30 * 24 * time.Hour
+	// HS256 keys shorter than the hash are easy to brute force
+	minKeyLength = 32
+)
+
+// how tokens are signed and checked, loaded from the environment by loadAuthConfig
+type authConfig struct {
+	issuer string
+	// signing keys by kid. Tokens are signed with the active key and checked with the key named
+	// by their kid, so a new key can be made active while tokens signed with the old one still work
+	keys       map[string][]byte
+	activeKid  string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// reads the auth settings:
+//
+//	JWT_KEYS         comma separated kid=secret pairs, each secret at least 32 bytes (required)
+//	JWT_ACTIVE_KID   kid of the key new tokens are signed with, optional when there is one key
+//	JWT_ISSUER       iss of the tokens, defaults to doggo-collector
+//	JWT_ACCESS_TTL   lifetime of access tokens such as 15m, the default
+//	JWT_REFRESH_TTL  lifetime of refresh tokens such as 720h, the default
+//
+// To rotate keys add the new key to JWT_KEYS and make it active, then drop the old key
+// once every access token signed with it has expired
+func loadAuthConfig(getenv func(string) string) (authConfig, error) {
+	config := authConfig{
+		issuer:     defaultIssuer,
+		keys:       make(map[string][]byte),
+		activeKid:  getenv("JWT_ACTIVE_KID"),
+		accessTTL:  defaultAccessTTL,
+		refreshTTL: defaultRefreshTTL,
+	}
+	if issuer := getenv("JWT_ISSUER"); issuer != "" {
+		config.issuer = issuer
+	}
+	for _, pair := range strings.Split(getenv("JWT_KEYS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kid, key, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || kid == "" {
+			return authConfig{}, errors.New("JWT_KEYS must be kid=secret pairs")
+		}
+		if len(key) < minKeyLength {
+			return authConfig{}, fmt.Errorf("JWT key %s must be at least %d bytes", kid, minKeyLength)
+		}
+		config.keys[kid] = []byte(key)
+	}
+	if len(config.keys) == 0 {
+		return authConfig{}, errors.New("JWT_KEYS is not set")
+	}
+	if config.activeKid == "" && len(config.keys) == 1 {
+		for kid := range config.keys {
+			config.activeKid = kid
+		}
+	}
+	if _, ok := config.keys[config.activeKid]; !ok {
+		return authConfig{}, fmt.Errorf("JWT_ACTIVE_KID %q is not one of JWT_KEYS", config.activeKid)
+	}
+	for name, ttl := range map[string]*time.Duration{"JWT_ACCESS_TTL": &config.accessTTL, "JWT_REFRESH_TTL": &config.refreshTTL} {
+		if value := getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return authConfig{}, fmt.Errorf("%s must be a positive duration such as 15m", name)
+			}
+			*ttl = d
+		}
+	}
+	return config, nil
+}
+
+// claims of an access token, the subject is the hex id of the user
+type accessClaims struct {
+	Username string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+// a long lived token that can be traded once for a new pair of tokens.
+// Only the sha256 of the token is stored so a leaked database cannot be used to log in
+type RefreshToken struct {
+	Hash     string `bson:"_id"`
+	UserId   string `bson:"userId"`
+	Username string `bson:"username"`
+	// every token traded for another since logging in shares the family of the first one,
+	// so a stolen token that is used twice revokes the whole login
+	Family    string    `bson:"family"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+	Revoked   bool      `bson:"revoked"`
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// signs a short lived access token for the user with the active key
+func (s *server) accessToken(u User) (string, error) {
+	now := s.now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims{
+		Username: u.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.auth.issuer,
+			Subject:   u.Id.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.auth.accessTTL)),
+		},
+	})
+	token.Header["kid"] = s.auth.activeKid
+	return token.SignedString(s.auth.keys[s.auth.activeKid])
+}
+
+// checks the signature, kid, issuer, iat and exp of an access token and returns its claims
+func (s *server) verifyAccessToken(tokenString string) (*accessClaims, error) {
+	claims := &accessClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.auth.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(s.auth.issuer),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(s.now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// the parser only checks exp and iat when they are present
+	if claims.ExpiresAt == nil || claims.IssuedAt == nil {
+		return nil, errors.New("token must have exp and iat")
+	}
+	if _, err := primitive.ObjectIDFromHex(claims.Subject); err != nil {
+		return nil, errors.New("token subject is not a user id")
+	}
+	return claims, nil
+}
+
+// issues an access token and a refresh token for the user. The refresh token joins family,
+// or starts a new one when family is empty
+func (s *server) issueTokens(ctx context.Context, u User, family string) (gin.H, error) {
+	access, err := s.accessToken(u)
+	if err != nil {
+		return nil, fmt.Errorf("signing token: %w", err)
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("generating refresh token: %w", err)
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(raw)
+	if family == "" {
+		family = primitive.NewObjectID().Hex()
+	}
+	err = s.db.CreateRefreshToken(ctx, RefreshToken{
+		Hash:      hashRefreshToken(refresh),
+		UserId:    u.Id.Hex(),
+		Username:  u.Username,
+		Family:    family,
+		ExpiresAt: s.now().Add(s.auth.refreshTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("saving refresh token: %w", err)
+	}
+	return gin.H{
+		"token":        access,
+		"tokenType":    "Bearer",
+		"expiresIn":    int64(s.auth.accessTTL / time.Second),
+		"refreshToken": refresh,
+	}, nil
+}
+
+// accepts access tokens from the Authorization header, with or without the Bearer prefix
+func (s *server) authMiddleware(c *gin.Context) {
+	tokenString := strings.TrimSpace(c.GetHeader("Authorization"))
+	if len(tokenString) > len("Bearer ") && strings.EqualFold(tokenString[:len("Bearer ")], "Bearer ") {
+		tokenString = strings.TrimSpace(tokenString[len("Bearer "):])
+	}
+	if tokenString == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	claims, err := s.verifyAccessToken(tokenString)
+	if err != nil {
+		fmt.Printf("rejected token: %v\n", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	c.Set(USER_ID, claims.Subject)
+	c.Next()
+}
+
+// reads the refresh token from the body of refresh and logout requests
+func bindRefreshToken(c *gin.Context) (string, bool) {
+	var request struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || request.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return "", false
+	}
+	return request.RefreshToken, true
+}
+
+// revokes every token of the login the token was issued from.
+// Tokens saved before families were kept have none, they are only revoked one at a time
+func (s *server) revokeFamily(ctx context.Context, token RefreshToken) error {
+	if token.Family == "" {
+		return nil
+	}
+	return s.db.RevokeRefreshTokenFamily(ctx, token.Family)
+}
+
+// trades a refresh token for a new access token and refresh token.
+// Each refresh token works once, the one sent is revoked. A token that was already traded
+// has been copied, so every token of its login is revoked and whoever has them must log in again
+func (s *server) refreshHandler(c *gin.Context) {
+	refresh, ok := bindRefreshToken(c)
+	if !ok {
+		return
+	}
+	stored, err := s.db.RevokeRefreshToken(c.Request.Context(), hashRefreshToken(refresh))
+	if err == ErrTokenRevoked {
+		fmt.Printf("refresh token of %s was reused, revoking its family\n", stored.Username)
+		if revokeErr := s.revokeFamily(c.Request.Context(), stored); revokeErr != nil {
+			err = revokeErr
+		} else {
+			err = ErrNotFound
+		}
+	}
+	if err == nil && !s.now().Before(stored.ExpiresAt) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		if err == ErrNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		fmt.Printf("revoking refresh token: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error refreshing token"})
+		return
+	}
+
+	// users deleted since logging in cannot refresh
+	u, err := s.db.GetUserByUsername(c.Request.Context(), stored.Username)
+	if err == ErrNotFound || (err == nil && u.Id.Hex() != stored.UserId) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("finding user: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error refreshing token"})
+		return
+	}
+	tokens, err := s.issueTokens(c.Request.Context(), u, stored.Family)
+	if err != nil {
+		fmt.Printf("refreshing: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error refreshing token"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// revokes a refresh token and every other token of its login.
+// Access tokens cannot be revoked and stop working when they expire
+func (s *server) logoutHandler(c *gin.Context) {
+	refresh, ok := bindRefreshToken(c)
+	if !ok {
+		return
+	}
+	stored, err := s.db.RevokeRefreshToken(c.Request.Context(), hashRefreshToken(refresh))
+	if err == nil || err == ErrTokenRevoked {
+		err = s.revokeFamily(c.Request.Context(), stored)
+	}
+	if err != nil && err != ErrNotFound {
+		fmt.Printf("revoking refresh token: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error logging out"})
+		return
+	}
+	// logging out twice is not an error
+	c.Status(http.StatusNoContent)
+}
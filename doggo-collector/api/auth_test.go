@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	testKey    = "0123456789abcdef0123456789abcdef"
+	testNewKey = "fedcba9876543210fedcba9876543210"
+)
+
+func testAuthConfig() authConfig {
+	config, err := loadAuthConfig(func(name string) string {
+		return map[string]string{"JWT_KEYS": "k1=" + testKey}[name]
+	})
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+// signs claims with key, kid and method so tests can build tokens the server would not
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestLoadAuthConfig(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(name string) string { return vars[name] }
+	}
+	config, err := loadAuthConfig(env(map[string]string{
+		"JWT_KEYS":        "old=" + testKey + ", new=" + testNewKey,
+		"JWT_ACTIVE_KID":  "new",
+		"JWT_ISSUER":      "test-issuer",
+		"JWT_ACCESS_TTL":  "5m",
+		"JWT_REFRESH_TTL": "24h",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.activeKid != "new" || len(config.keys) != 2 || string(config.keys["old"]) != testKey || config.issuer != "test-issuer" || config.accessTTL != 5*time.Minute || config.refreshTTL != 24*time.Hour {
+		t.Errorf("wrong config %+v", config)
+	}
+
+	invalid := map[string]map[string]string{
+		"no keys":          {},
+		"short key":        {"JWT_KEYS": "k1=short"},
+		"missing kid":      {"JWT_KEYS": "=" + testKey},
+		"no active kid":    {"JWT_KEYS": "a=" + testKey + ",b=" + testNewKey},
+		"unknown kid":      {"JWT_KEYS": "a=" + testKey, "JWT_ACTIVE_KID": "b"},
+		"bad ttl":          {"JWT_KEYS": "a=" + testKey, "JWT_ACCESS_TTL": "soon"},
+		"negative refresh": {"JWT_KEYS": "a=" + testKey, "JWT_REFRESH_TTL": "-1h"},
+	}
+	for name, vars := range invalid {
+		if _, err := loadAuthConfig(env(vars)); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	s, _ := newTestServer(t)
+	r := s.router()
+	token := strings.TrimPrefix(login(t, r), "Bearer ")
+
+	now := time.Now()
+	valid := jwt.RegisteredClaims{
+		Issuer:    defaultIssuer,
+		Subject:   primitive.NewObjectID().Hex(),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+	}
+	with := func(change func(c *jwt.RegisteredClaims)) jwt.RegisteredClaims {
+		c := valid
+		change(&c)
+		return c
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"garbage", "Bearer not-a-token", http.StatusUnauthorized},
+		{"bearer", "Bearer " + token, http.StatusOK},
+		{"lowercase bearer", "bearer " + token, http.StatusOK},
+		{"no prefix", token, http.StatusOK},
+		{"signed by the test", "Bearer " + signToken(t, jwt.SigningMethodHS256, "k1", []byte(testKey), valid), http.StatusOK},
+		{"wrong key", signToken(t, jwt.SigningMethodHS256, "k1", []byte(testNewKey), valid), http.StatusUnauthorized},
+		{"unknown kid", signToken(t, jwt.SigningMethodHS256, "k2", []byte(testKey), valid), http.StatusUnauthorized},
+		{"no kid", signToken(t, jwt.SigningMethodHS256, "", []byte(testKey), valid), http.StatusUnauthorized},
+		{"alg none", signToken(t, jwt.SigningMethodNone, "k1", jwt.UnsafeAllowNoneSignatureType, valid), http.StatusUnauthorized},
+		{"HS512", signToken(t, jwt.SigningMethodHS512, "k1", []byte(testKey), valid), http.StatusUnauthorized},
+		{"expired", signToken(t, jwt.SigningMethodHS256, "k1", []byte(testKey), with(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+		})), http.StatusUnauthorized},
+		{"no exp", signToken(t, jwt.SigningMethodHS256, "k1", []byte(testKey), with(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = nil
+		})), http.StatusUnauthorized},
+		{"issued in the future", signToken(t, jwt.SigningMethodHS256, "k1", []byte(testKey), with(func(c *jwt.RegisteredClaims) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(time.Hour))
+		})), http.StatusUnauthorized},
+		{"no iat", signToken(t, jwt.SigningMethodHS256, "k1", []byte(testKey), with(func(c *jwt.RegisteredClaims) {
+			c.IssuedAt = nil
+		})), http.StatusUnauthorized},
+		{"wrong issuer", signToken(t, jwt.SigningMethodHS256, "k1", []byte(testKey), with(func(c *jwt.RegisteredClaims) {
+			c.Issuer = "someone-else"
+		})), http.StatusUnauthorized},
+		{"subject is not a user id", signToken(t, jwt.SigningMethodHS256, "k1", []byte(testKey), with(func(c *jwt.RegisteredClaims) {
+			c.Subject = "admin"
+		})), http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		if code := do(t, r, http.MethodGet, "/api/card", tc.token, nil, nil); code != tc.want {
+			t.Errorf("%s got %d, want %d", tc.name, code, tc.want)
+		}
+	}
+}
+
+// tokens signed with the old key work until the key is removed from the config
+func TestAuth_KeyRotation(t *testing.T) {
+	s, _ := newTestServer(t)
+	oldToken := login(t, s.router())
+
+	s.auth.keys["k2"] = []byte(testNewKey)
+	s.auth.activeKid = "k2"
+	r := s.router()
+	newToken := login(t, r)
+	if header := decodeHeader(t, newToken); header["kid"] != "k2" {
+		t.Errorf("new token was signed with %v", header["kid"])
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if code := do(t, r, http.MethodGet, "/api/card", token, nil, nil); code != http.StatusOK {
+			t.Errorf("token signed with %v got %d", decodeHeader(t, token)["kid"], code)
+		}
+	}
+
+	delete(s.auth.keys, "k1")
+	if code := do(t, r, http.MethodGet, "/api/card", oldToken, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("token signed with a removed key got %d", code)
+	}
+}
+
+func decodeHeader(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(strings.TrimPrefix(token, "Bearer "), &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Header
+}
+
+// access tokens stop working once they expire, and a refresh token gets a new one
+func TestRefresh(t *testing.T) {
+	s, _ := newTestServer(t)
+	r := s.router()
+	tokens := loginTokens(t, r)
+
+	now := time.Now()
+	s.now = func() time.Time { return now.Add(defaultAccessTTL + time.Minute) }
+	if code := do(t, r, http.MethodGet, "/api/card", "Bearer "+tokens.Token, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("expired access token got %d", code)
+	}
+
+	var refreshed tokensResponse
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": tokens.RefreshToken}, &refreshed); code != http.StatusOK {
+		t.Fatalf("refresh got %d", code)
+	}
+	if refreshed.RefreshToken == tokens.RefreshToken || refreshed.Token == "" {
+		t.Errorf("refresh did not issue new tokens %+v", refreshed)
+	}
+	if code := do(t, r, http.MethodGet, "/api/card", "Bearer "+refreshed.Token, nil, nil); code != http.StatusOK {
+		t.Errorf("refreshed access token got %d", code)
+	}
+
+	// refresh tokens work once
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": tokens.RefreshToken}, nil); code != http.StatusUnauthorized {
+		t.Errorf("used refresh token got %d", code)
+	}
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": "made-up"}, nil); code != http.StatusUnauthorized {
+		t.Errorf("unknown refresh token got %d", code)
+	}
+	if code := do(t, r, http.MethodPost, "/refresh", "", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("missing refresh token got %d", code)
+	}
+
+	s.now = func() time.Time { return now.Add(defaultRefreshTTL + time.Hour) }
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": refreshed.RefreshToken}, nil); code != http.StatusUnauthorized {
+		t.Errorf("expired refresh token got %d", code)
+	}
+}
+
+func TestRefresh_DeletedUser(t *testing.T) {
+	s, db := newTestServer(t)
+	r := s.router()
+	tokens := loginTokens(t, r)
+
+	u, _ := db.GetUserByUsername(context.Background(), "user123")
+	if err := db.DeleteUser(context.Background(), u.Id.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": tokens.RefreshToken}, nil); code != http.StatusUnauthorized {
+		t.Errorf("deleted user refreshed, got %d", code)
+	}
+}
+
+// a refresh token used twice was copied, so every token of that login stops working
+func TestRefresh_Reuse(t *testing.T) {
+	s, _ := newTestServer(t)
+	r := s.router()
+	stolen := loginTokens(t, r)
+	other := loginTokens(t, r)
+
+	var refreshed tokensResponse
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": stolen.RefreshToken}, &refreshed); code != http.StatusOK {
+		t.Fatalf("refresh got %d", code)
+	}
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": stolen.RefreshToken}, nil); code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token got %d", code)
+	}
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": refreshed.RefreshToken}, nil); code != http.StatusUnauthorized {
+		t.Errorf("refresh token of a reused family got %d", code)
+	}
+	// other logins are left alone
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": other.RefreshToken}, nil); code != http.StatusOK {
+		t.Errorf("refresh token of another login got %d", code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	s, _ := newTestServer(t)
+	r := s.router()
+	tokens := loginTokens(t, r)
+
+	for i := 0; i < 2; i++ {
+		if code := do(t, r, http.MethodPost, "/logout", "", gin.H{"refreshToken": tokens.RefreshToken}, nil); code != http.StatusNoContent {
+			t.Errorf("logout %d got %d", i, code)
+		}
+	}
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": tokens.RefreshToken}, nil); code != http.StatusUnauthorized {
+		t.Errorf("refresh token still works after logout, got %d", code)
+	}
+	if code := do(t, r, http.MethodPost, "/logout", "", gin.H{}, nil); code != http.StatusBadRequest {
+		t.Errorf("logout without a refresh token got %d", code)
+	}
+
+	// logging out ends the login, including tokens refreshed since
+	tokens = loginTokens(t, r)
+	var refreshed tokensResponse
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": tokens.RefreshToken}, &refreshed); code != http.StatusOK {
+		t.Fatalf("refresh got %d", code)
+	}
+	if code := do(t, r, http.MethodPost, "/logout", "", gin.H{"refreshToken": refreshed.RefreshToken}, nil); code != http.StatusNoContent {
+		t.Errorf("logout got %d", code)
+	}
+	if code := do(t, r, http.MethodPost, "/refresh", "", gin.H{"refreshToken": refreshed.RefreshToken}, nil); code != http.StatusUnauthorized {
+		t.Errorf("refreshed token still works after logout, got %d", code)
+	}
+}
@@ -1,12 +1,10 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -16,45 +14,17 @@ type Card struct {
 	Photo string             `json:"photo"`
 }
 
-// type CardService interface {
-// 	// GetCards(userId string) ([]Card, error)
-// 	// CreateCard(c Card) error
-// 	// DeleteCard(cardId string) error
-// 	// DeleteAllCards(userId string) error
-// }
-// type cardService struct {
-// 	database Database
-// }
-
-//	func newCardService(db Database) CardService {
-//		return cardService{
-//			database: db,
-//		}
-//	}
-func getCardsHandler(c *gin.Context) {
+func (s *server) getCardsHandler(c *gin.Context) {
 	userID := c.GetString(USER_ID)
-	collection := client.Database("Cards").Collection(userID)
 	var cards []Card
 	response := gin.H{"cards": cards}
 
-	cur, err := collection.Find(context.Background(), bson.D{{}})
+	cards, err := s.db.GetCards(c.Request.Context(), userID)
 	if err != nil {
-		fmt.Printf("Error collection find: %v\n", err)
+		fmt.Printf("Error fetching cards: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching cards"})
 		return
 	}
-	defer cur.Close(context.Background())
-
-	for cur.Next(context.Background()) {
-		var card Card
-		err := cur.Decode(&card)
-		if err != nil {
-			fmt.Printf("Error parse: %v\n", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding cards"})
-			return
-		}
-		cards = append(cards, card)
-	}
 
 	if len(cards) > 0 {
 		response["cards"] = cards
@@ -62,7 +32,7 @@ func getCardsHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
-func postCardsHandler(c *gin.Context) {
+func (s *server) postCardsHandler(c *gin.Context) {
 	userID := c.GetString(USER_ID)
 	var request struct {
 		Label string `json:"breedLabel"`
@@ -73,7 +43,7 @@ func postCardsHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
-	photo, err := getPhotoForBreed(request.Path)
+	photo, err := s.photoForBreed(request.Path)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error making breed card"})
 		return
@@ -83,37 +53,36 @@ func postCardsHandler(c *gin.Context) {
 		Photo: photo,
 	}
 
-	collection := client.Database("Cards").Collection(userID)
-	_, err = collection.InsertOne(context.Background(), card)
+	card, err = s.db.CreateCard(c.Request.Context(), userID, card)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "error inserting card to DB"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"card": card})
 }
-func deleteAllCards(c *gin.Context) {
+func (s *server) deleteAllCards(c *gin.Context) {
 	userID := c.GetString(USER_ID)
-	collection := client.Database("Cards").Collection(userID)
 
-	result, err := collection.DeleteMany(context.Background(), bson.D{})
+	deleted, err := s.db.DeleteAllCards(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "error inserting card to DB"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error deleting cards"})
+		return
 	}
-	fmt.Printf("Deleted %d", result.DeletedCount)
-	c.JSON(http.StatusOK, gin.H{"deleted": result.DeletedCount})
+	fmt.Printf("Deleted %d", deleted)
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
 }
 
-func deleteCard(c *gin.Context) {
+func (s *server) deleteCard(c *gin.Context) {
 	userID := c.GetString(USER_ID)
 	cardId := c.Param("id")
 	fmt.Printf("Card ID: %v\n", cardId)
-	collection := client.Database("Cards").Collection(userID)
-	objId, _ := primitive.ObjectIDFromHex(cardId)
-	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": objId})
+	deleted, err := s.db.DeleteCard(c.Request.Context(), userID, cardId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "problem deleting card",
 		})
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"deleted": result.DeletedCount})
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type cardsResponse struct {
+	Cards []Card `json:"cards"`
+}
+
+func TestCards(t *testing.T) {
+	s, db := newTestServer(t)
+	r := s.router()
+	token := login(t, r)
+
+	var list cardsResponse
+	if code := do(t, r, http.MethodGet, "/api/card", token, nil, &list); code != http.StatusOK || len(list.Cards) != 0 {
+		t.Fatalf("new user has cards %d %+v", code, list)
+	}
+
+	ids := make([]string, 0)
+	for _, breed := range []gin.H{
+		{"breedLabel": "Husky", "breedPath": "/husky"},
+		{"breedLabel": "Boston Bulldog", "breedPath": "/bulldog/boston"},
+	} {
+		var created struct {
+			Card Card `json:"card"`
+		}
+		if code := do(t, r, http.MethodPost, "/api/card", token, breed, &created); code != http.StatusOK {
+			t.Fatalf("creating %v got %d", breed, code)
+		}
+		if created.Card.Id.IsZero() || created.Card.Breed != breed["breedLabel"] || created.Card.Photo != "https://images.dog.ceo/breeds"+breed["breedPath"].(string)+"/1.jpg" {
+			t.Errorf("wrong card %+v", created.Card)
+		}
+		ids = append(ids, created.Card.Id.Hex())
+	}
+
+	do(t, r, http.MethodGet, "/api/card", token, nil, &list)
+	if len(list.Cards) != 2 {
+		t.Fatalf("wrong cards %+v", list)
+	}
+
+	// cards belong to the user that created them
+	other, _ := db.CreateUser(context.Background(), User{Username: "other", Password: "other"})
+	otherToken, _ := s.accessToken(other)
+	var otherList cardsResponse
+	do(t, r, http.MethodGet, "/api/card", otherToken, nil, &otherList)
+	if len(otherList.Cards) != 0 {
+		t.Errorf("cards of another user were returned %+v", otherList)
+	}
+
+	var deleted struct {
+		Deleted int64 `json:"deleted"`
+	}
+	do(t, r, http.MethodDelete, "/api/card/"+ids[0], otherToken, nil, &deleted)
+	if deleted.Deleted != 0 {
+		t.Errorf("deleted the card of another user")
+	}
+	do(t, r, http.MethodDelete, "/api/card/"+ids[0], token, nil, &deleted)
+	if deleted.Deleted != 1 {
+		t.Errorf("card was not deleted")
+	}
+	do(t, r, http.MethodDelete, "/api/card/not-an-id", token, nil, &deleted)
+	if deleted.Deleted != 0 {
+		t.Errorf("deleted a card that does not exist")
+	}
+	do(t, r, http.MethodGet, "/api/card", token, nil, &list)
+	if len(list.Cards) != 1 || list.Cards[0].Id.Hex() != ids[1] {
+		t.Errorf("wrong cards after deleting %+v", list)
+	}
+
+	do(t, r, http.MethodDelete, "/api/card", token, nil, &deleted)
+	if deleted.Deleted != 1 {
+		t.Errorf("deleted %d cards, want 1", deleted.Deleted)
+	}
+	list = cardsResponse{}
+	do(t, r, http.MethodGet, "/api/card", token, nil, &list)
+	if len(list.Cards) != 0 {
+		t.Errorf("cards left after deleting all %+v", list)
+	}
+}
+
+func TestCards_Unauthorized(t *testing.T) {
+	s, db := newTestServer(t)
+	r := s.router()
+
+	if code := do(t, r, http.MethodPost, "/api/card", "", gin.H{"breedLabel": "Husky", "breedPath": "/husky"}, nil); code != http.StatusUnauthorized {
+		t.Errorf("created a card without a token, got %d", code)
+	}
+	if cards, _ := db.GetCards(context.Background(), ""); len(cards) != 0 {
+		t.Errorf("card was created without a user %+v", cards)
+	}
+}
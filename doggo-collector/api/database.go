@@ -1,17 +1,224 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// returned when a user or card does not exist
+var ErrNotFound = errors.New("not found")
+
+// returned by CreateUser when another user has the username
+var ErrUsernameTaken = errors.New("username is taken")
+
+// returned by RevokeRefreshToken along with the token when it was already revoked
+var ErrTokenRevoked = errors.New("refresh token is revoked")
+
 type Database interface {
-	//TODO add card Create Read and Deletes
+	// cards are kept per user, userId is the hex object id of the user
+	GetCards(ctx context.Context, userId string) ([]Card, error)
+	CreateCard(ctx context.Context, userId string, card Card) (Card, error)
+	DeleteCard(ctx context.Context, userId string, cardId string) (int64, error)
+	DeleteAllCards(ctx context.Context, userId string) (int64, error)
+
+	// usernames are unique
+	CreateUser(ctx context.Context, user User) (User, error)
+	GetUserByUsername(ctx context.Context, username string) (User, error)
+	UpdateUser(ctx context.Context, user User) error
+	// also removes the cards of the user
+	DeleteUser(ctx context.Context, userId string) error
+
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	// revokes a refresh token that has not been revoked yet and returns it. A token that was
+	// already revoked is returned with ErrTokenRevoked, and ErrNotFound if there is no token.
+	// Only one of many calls with the same hash succeeds, so a refresh token can only be used once
+	RevokeRefreshToken(ctx context.Context, hash string) (RefreshToken, error)
+	// revokes every refresh token issued from the same login
+	RevokeRefreshTokenFamily(ctx context.Context, family string) error
 }
 
 type mongoDatabase struct {
 	client *mongo.Client
 }
 
-func NewMongoDatabase(client *mongo.Client) Database {
-	return &mongoDatabase{client: client}
+// connects the database to client and makes sure the unique index on usernames,
+// the index that removes expired refresh tokens and the index on token families exist
+func NewMongoDatabase(ctx context.Context, client *mongo.Client) (Database, error) {
+	m := &mongoDatabase{client: client}
+	_, err := m.users().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating unique username index: %w", err)
+	}
+	_, err = m.refreshTokens().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating refresh token expiry index: %w", err)
+	}
+	_, err = m.refreshTokens().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "family", Value: 1}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating refresh token family index: %w", err)
+	}
+	return m, nil
+}
+
+// each user has a collection of their own in the Cards database, named after their id
+func (m *mongoDatabase) cards(userId string) *mongo.Collection {
+	return m.client.Database("Cards").Collection(userId)
+}
+
+func (m *mongoDatabase) users() *mongo.Collection {
+	return m.client.Database("DC-App").Collection("Users")
+}
+
+func (m *mongoDatabase) refreshTokens() *mongo.Collection {
+	return m.client.Database("DC-App").Collection("RefreshTokens")
+}
+
+func (m *mongoDatabase) GetCards(ctx context.Context, userId string) ([]Card, error) {
+	cur, err := m.cards(userId).Find(ctx, bson.D{{}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var cards []Card
+	for cur.Next(ctx) {
+		var card Card
+		if err := cur.Decode(&card); err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+	return cards, cur.Err()
+}
+
+func (m *mongoDatabase) CreateCard(ctx context.Context, userId string, card Card) (Card, error) {
+	result, err := m.cards(userId).InsertOne(ctx, card)
+	if err != nil {
+		return Card{}, err
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		card.Id = id
+	}
+	return card, nil
+}
+
+func (m *mongoDatabase) DeleteCard(ctx context.Context, userId string, cardId string) (int64, error) {
+	objId, err := primitive.ObjectIDFromHex(cardId)
+	if err != nil {
+		return 0, nil
+	}
+	result, err := m.cards(userId).DeleteOne(ctx, bson.M{"_id": objId})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (m *mongoDatabase) DeleteAllCards(ctx context.Context, userId string) (int64, error) {
+	result, err := m.cards(userId).DeleteMany(ctx, bson.D{})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (m *mongoDatabase) CreateUser(ctx context.Context, user User) (User, error) {
+	result, err := m.users().InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return User{}, ErrUsernameTaken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		user.Id = id
+	}
+	return user, nil
+}
+
+func (m *mongoDatabase) findUser(ctx context.Context, filter bson.M) (User, error) {
+	var u User
+	err := m.users().FindOne(ctx, filter).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return User{}, ErrNotFound
+	}
+	return u, err
+}
+
+func (m *mongoDatabase) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	return m.findUser(ctx, bson.M{"username": username})
+}
+
+func (m *mongoDatabase) UpdateUser(ctx context.Context, user User) error {
+	result, err := m.users().ReplaceOne(ctx, bson.M{"_id": user.Id}, user)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *mongoDatabase) DeleteUser(ctx context.Context, userId string) error {
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return ErrNotFound
+	}
+	result, err := m.users().DeleteOne(ctx, bson.M{"_id": objId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	// cards of a deleted user are removed with them
+	return m.cards(userId).Drop(ctx)
+}
+
+func (m *mongoDatabase) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	_, err := m.refreshTokens().InsertOne(ctx, token)
+	return err
+}
+
+func (m *mongoDatabase) RevokeRefreshToken(ctx context.Context, hash string) (RefreshToken, error) {
+	var token RefreshToken
+	err := m.refreshTokens().FindOneAndUpdate(ctx,
+		bson.M{"_id": hash, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true}},
+	).Decode(&token)
+	if err != mongo.ErrNoDocuments {
+		return token, err
+	}
+	// tell a token that was already used apart from one that never existed
+	err = m.refreshTokens().FindOne(ctx, bson.M{"_id": hash}).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		return RefreshToken{}, ErrNotFound
+	}
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return token, ErrTokenRevoked
+}
+
+func (m *mongoDatabase) RevokeRefreshTokenFamily(ctx context.Context, family string) error {
+	_, err := m.refreshTokens().UpdateMany(ctx,
+		bson.M{"family": family, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	return err
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// server backed by an in-memory database holding one user from before passwords were hashed
+func newTestServer(t *testing.T) (*server, Database) {
+	t.Helper()
+	db := NewMemoryDatabase()
+	if _, err := db.CreateUser(context.Background(), User{Username: "user123", Password: "password123"}); err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(db, testAuthConfig())
+	s.hashCost = bcrypt.MinCost
+	s.photoForBreed = func(breedPath string) (string, error) {
+		return "https://images.dog.ceo/breeds" + breedPath + "/1.jpg", nil
+	}
+	return s, db
+}
+
+// sends a request to the router and decodes the json response into out
+func do(t *testing.T, r http.Handler, method string, path string, token string, body interface{}, out interface{}) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+type tokensResponse struct {
+	Token        string `json:"token"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// logs in as the test user and returns the tokens
+func loginTokens(t *testing.T, r http.Handler) tokensResponse {
+	t.Helper()
+	var resp tokensResponse
+	code := do(t, r, http.MethodPost, "/login", "", gin.H{"username": "user123", "password": "password123"}, &resp)
+	if code != http.StatusOK || resp.Token == "" || resp.RefreshToken == "" {
+		t.Fatalf("login failed with %d", code)
+	}
+	return resp
+}
+
+// logs in as the test user and returns the access token with the Bearer prefix
+func login(t *testing.T, r http.Handler) string {
+	t.Helper()
+	return "Bearer " + loginTokens(t, r).Token
+}
+
+func TestLogin(t *testing.T) {
+	s, _ := newTestServer(t)
+	r := s.router()
+
+	tokens := loginTokens(t, r)
+	if tokens.TokenType != "Bearer" || tokens.ExpiresIn != int64(defaultAccessTTL.Seconds()) {
+		t.Errorf("wrong tokens %+v", tokens)
+	}
+
+	for _, creds := range []gin.H{
+		{"username": "user123", "password": "wrong"},
+		{"username": "nobody", "password": "password123"},
+	} {
+		if code := do(t, r, http.MethodPost, "/login", "", creds, nil); code != http.StatusUnauthorized {
+			t.Errorf("%v got %d, want 401", creds, code)
+		}
+	}
+	if code := do(t, r, http.MethodPost, "/login", "", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("empty body got %d, want 400", code)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// keeps users and cards in memory, used by the tests and for running without mongo
+type memoryDatabase struct {
+	mu    sync.Mutex
+	users map[primitive.ObjectID]User
+	cards map[string][]Card
+	// refresh tokens by hash
+	refreshTokens map[string]RefreshToken
+}
+
+func NewMemoryDatabase() Database {
+	return &memoryDatabase{
+		users:         make(map[primitive.ObjectID]User),
+		cards:         make(map[string][]Card),
+		refreshTokens: make(map[string]RefreshToken),
+	}
+}
+
+func (m *memoryDatabase) GetCards(ctx context.Context, userId string) ([]Card, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Card(nil), m.cards[userId]...), nil
+}
+
+func (m *memoryDatabase) CreateCard(ctx context.Context, userId string, card Card) (Card, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if card.Id.IsZero() {
+		card.Id = primitive.NewObjectID()
+	}
+	m.cards[userId] = append(m.cards[userId], card)
+	return card, nil
+}
+
+func (m *memoryDatabase) DeleteCard(ctx context.Context, userId string, cardId string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cards := m.cards[userId]
+	for i, card := range cards {
+		if card.Id.Hex() == cardId {
+			m.cards[userId] = append(cards[:i:i], cards[i+1:]...)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *memoryDatabase) DeleteAllCards(ctx context.Context, userId string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := int64(len(m.cards[userId]))
+	delete(m.cards, userId)
+	return deleted, nil
+}
+
+func (m *memoryDatabase) CreateUser(ctx context.Context, user User) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Username == user.Username {
+			return User{}, ErrUsernameTaken
+		}
+	}
+	if user.Id.IsZero() {
+		user.Id = primitive.NewObjectID()
+	}
+	m.users[user.Id] = user
+	return user, nil
+}
+
+// first user matching, the same as FindOne
+func (m *memoryDatabase) findUser(match func(u User) bool) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if match(u) {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *memoryDatabase) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	return m.findUser(func(u User) bool { return u.Username == username })
+}
+
+func (m *memoryDatabase) UpdateUser(ctx context.Context, user User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[user.Id]; !ok {
+		return ErrNotFound
+	}
+	m.users[user.Id] = user
+	return nil
+}
+
+func (m *memoryDatabase) DeleteUser(ctx context.Context, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	objId, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return ErrNotFound
+	}
+	if _, ok := m.users[objId]; !ok {
+		return ErrNotFound
+	}
+	delete(m.users, objId)
+	delete(m.cards, userId)
+	return nil
+}
+
+func (m *memoryDatabase) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refreshTokens[token.Hash] = token
+	return nil
+}
+
+func (m *memoryDatabase) RevokeRefreshToken(ctx context.Context, hash string) (RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.refreshTokens[hash]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	if token.Revoked {
+		return token, ErrTokenRevoked
+	}
+	revoked := token
+	revoked.Revoked = true
+	m.refreshTokens[hash] = revoked
+	return token, nil
+}
+
+func (m *memoryDatabase) RevokeRefreshTokenFamily(ctx context.Context, family string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, token := range m.refreshTokens {
+		if token.Family == family {
+			token.Revoked = true
+			m.refreshTokens[hash] = token
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	minPasswordLength = 8
+	// bcrypt only uses the first 72 bytes of a password
+	maxPasswordBytes = 72
+)
+
+// compared against when a username does not exist, so a missing user takes as long as a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("doggo-collector"), bcrypt.DefaultCost)
+
+// returns what is wrong with a username, nil if it can be registered
+func validateUsername(username string) error {
+	if n := utf8.RuneCountInString(username); n < minUsernameLength || n > maxUsernameLength {
+		return fmt.Errorf("username must be %d to %d characters", minUsernameLength, maxUsernameLength)
+	}
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._-", r) {
+			return errors.New("username can only contain letters, digits, '.', '_' and '-'")
+		}
+	}
+	return nil
+}
+
+// returns every rule the password breaks, empty if it is allowed
+func validatePassword(username string, password string) []string {
+	problems := make([]string, 0)
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		problems = append(problems, fmt.Sprintf("password must be at least %d characters", minPasswordLength))
+	}
+	if len(password) > maxPasswordBytes {
+		problems = append(problems, fmt.Sprintf("password must be at most %d bytes", maxPasswordBytes))
+	}
+	var letter, digit bool
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	if !letter || !digit {
+		problems = append(problems, "password must contain a letter and a digit")
+	}
+	if strings.EqualFold(password, username) {
+		problems = append(problems, "password must not be the username")
+	}
+	return problems
+}
+
+func (s *server) hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.hashCost)
+	return string(hash), err
+}
+
+// checks a password against a user. Users created before passwords were hashed still have
+// the plaintext password, which is replaced by a hash the first time they log in
+func (s *server) checkPassword(c *gin.Context, u *User, password string) (bool, error) {
+	if u.PasswordHash != "" {
+		return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil, nil
+	}
+	if u.Password == "" || subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) != 1 {
+		return false, nil
+	}
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return false, err
+	}
+	u.PasswordHash = hash
+	u.Password = ""
+	return true, s.db.UpdateUser(c.Request.Context(), *u)
+}
+
+func (s *server) registerHandler(c *gin.Context) {
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&credentials); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if err := validateUsername(credentials.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if problems := validatePassword(credentials.Username, credentials.Password); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is too weak", "problems": problems})
+		return
+	}
+
+	hash, err := s.hashPassword(credentials.Password)
+	if err != nil {
+		fmt.Printf("hashing password: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating user"})
+		return
+	}
+	u, err := s.db.CreateUser(c.Request.Context(), User{Username: credentials.Username, PasswordHash: hash})
+	if err != nil {
+		if err == ErrUsernameTaken {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username is taken"})
+			return
+		}
+		fmt.Printf("creating user: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating user"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": u.Id, "username": u.Username})
+}
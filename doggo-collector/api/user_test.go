@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRegister(t *testing.T) {
+	s, db := newTestServer(t)
+	r := s.router()
+
+	var created struct {
+		Id       string `json:"id"`
+		Username string `json:"username"`
+	}
+	creds := gin.H{"username": "newuser", "password": "good password 1"}
+	if code := do(t, r, http.MethodPost, "/register", "", creds, &created); code != http.StatusCreated {
+		t.Fatalf("register got %d", code)
+	}
+	if created.Username != "newuser" || created.Id == "" {
+		t.Errorf("wrong user %+v", created)
+	}
+	u, err := db.GetUserByUsername(context.Background(), "newuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Password != "" || bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte("good password 1")) != nil {
+		t.Errorf("password was not hashed %+v", u)
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if code := do(t, r, http.MethodPost, "/login", "", creds, &resp); code != http.StatusOK || resp.Token == "" {
+		t.Errorf("could not log in after registering, got %d", code)
+	}
+	if code := do(t, r, http.MethodPost, "/login", "", gin.H{"username": "newuser", "password": "good password 2"}, nil); code != http.StatusUnauthorized {
+		t.Errorf("logged in with the wrong password, got %d", code)
+	}
+
+	if code := do(t, r, http.MethodPost, "/register", "", gin.H{"username": "newuser", "password": "other password 1"}, nil); code != http.StatusConflict {
+		t.Errorf("registered a taken username, got %d", code)
+	}
+}
+
+func TestRegister_Invalid(t *testing.T) {
+	s, _ := newTestServer(t)
+	r := s.router()
+
+	cases := []struct {
+		name  string
+		creds gin.H
+	}{
+		{"short password", gin.H{"username": "newuser", "password": "abc1"}},
+		{"no digit", gin.H{"username": "newuser", "password": "password"}},
+		{"no letter", gin.H{"username": "newuser", "password": "12345678"}},
+		{"password is username", gin.H{"username": "newuser1", "password": "NewUser1"}},
+		{"too long", gin.H{"username": "newuser", "password": "a1234567890123456789012345678901234567890123456789012345678901234567890123"}},
+		{"short username", gin.H{"username": "ab", "password": "good password 1"}},
+		{"username with spaces", gin.H{"username": "new user", "password": "good password 1"}},
+		{"missing body", nil},
+	}
+	for _, tc := range cases {
+		if code := do(t, r, http.MethodPost, "/register", "", tc.creds, nil); code != http.StatusBadRequest {
+			t.Errorf("%s got %d, want 400", tc.name, code)
+		}
+	}
+
+	var weak struct {
+		Problems []string `json:"problems"`
+	}
+	do(t, r, http.MethodPost, "/register", "", gin.H{"username": "newuser", "password": "abc"}, &weak)
+	if len(weak.Problems) != 2 {
+		t.Errorf("wrong problems %v", weak.Problems)
+	}
+}
+
+// users from before passwords were hashed get a hash the first time they log in
+func TestLogin_RehashesLegacyPassword(t *testing.T) {
+	s, db := newTestServer(t)
+	r := s.router()
+
+	if code := do(t, r, http.MethodPost, "/login", "", gin.H{"username": "user123", "password": "wrong"}, nil); code != http.StatusUnauthorized {
+		t.Fatalf("logged in with the wrong password, got %d", code)
+	}
+	if u, _ := db.GetUserByUsername(context.Background(), "user123"); u.PasswordHash != "" {
+		t.Errorf("rehashed after a failed login")
+	}
+
+	login(t, r)
+	u, _ := db.GetUserByUsername(context.Background(), "user123")
+	if u.Password != "" || bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte("password123")) != nil {
+		t.Errorf("legacy password was not rehashed %+v", u)
+	}
+	login(t, r)
+}
@@ -1,18 +1,88 @@
-import React, { useState, useEffect } from 'react';
+import React, { useState, useEffect, useRef } from 'react';
 import Card from './Card';
 import SearchBar from './SearchBar';
 
-const Dashboard = ({ token }) => {
+const Dashboard = ({ tokens, onTokensChange, onLogout }) => {
   const [cards, setCards] = useState([]);
+  // the latest tokens, requests that are already running see a refresh straight away
+  const tokensRef = useRef(tokens);
+  const refreshing = useRef(null);
 
+  // trades the refresh token for new tokens. Requests that fail together share one refresh,
+  // the server ends the whole login when a refresh token is used twice
+  const refreshTokens = () => {
+    if (!refreshing.current) {
+      refreshing.current = fetch('http://localhost:8080/refresh', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ refreshToken: tokensRef.current.refreshToken }),
+      })
+        .then(async (response) => {
+          if (!response.ok) {
+            throw new Error('Session expired');
+          }
+          const data = await response.json();
+          tokensRef.current = { token: data.token, refreshToken: data.refreshToken };
+          onTokensChange(tokensRef.current);
+          return tokensRef.current;
+        })
+        .finally(() => {
+          refreshing.current = null;
+        });
+    }
+    return refreshing.current;
+  };
+
+  // fetch with the access token, refreshing it and trying once more when it has expired
+  const authFetch = async (url, options = {}) => {
+    const send = (token) => fetch(url, {
+      ...options,
+      headers: {
+        ...options.headers,
+        Authorization: `Bearer ${token}`,
+      },
+    });
+    const used = tokensRef.current.token;
+    const response = await send(used);
+    if (response.status !== 401) {
+      return response;
+    }
+    // another request may have refreshed the token in the meantime
+    if (tokensRef.current.token !== used) {
+      return send(tokensRef.current.token);
+    }
+    try {
+      const refreshed = await refreshTokens();
+      return send(refreshed.token);
+    } catch (error) {
+      onLogout();
+      throw error;
+    }
+  };
+
+  const handleLogout = async () => {
+    try {
+      await fetch('http://localhost:8080/logout', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ refreshToken: tokensRef.current.refreshToken }),
+      });
+    } catch (error) {
+      console.error('Error logging out:', error);
+    }
+    onLogout();
+  };
+
+  // the cards are loaded once per login, refreshing the tokens does not load them again
   useEffect(() => {
     const fetchCards = async () => {
         try {
-          const response = await fetch('http://localhost:8080/api/card', {
+          const response = await authFetch('http://localhost:8080/api/card', {
             method: 'GET',
-            headers: {
-              Authorization: `${token}`,
-            },
           });
           if (!response.ok) {
             throw new Error('Network response was not ok');
@@ -26,16 +96,16 @@ const Dashboard = ({ token }) => {
       };
 
     fetchCards();
-  }, [token]);
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, []);
 
 
   const handleAddCard = async (value,label ) => {
     try {
-        const response = await fetch('http://localhost:8080/api/card', {
+        const response = await authFetch('http://localhost:8080/api/card', {
           method: 'POST',
           headers: {
             'Content-Type': 'application/json',
-            Authorization: `${token}`,
           },
           body: JSON.stringify({ 
             breedPath: value,
@@ -58,11 +128,10 @@ const Dashboard = ({ token }) => {
   };
   const deleteAllCards = async() => {
     try {
-    const response = await fetch('http://localhost:8080/api/card', {
+    const response = await authFetch('http://localhost:8080/api/card', {
           method: 'DELETE',
           headers: {
             'Content-Type': 'application/json',
-            Authorization: `${token}`,
           }});
           if (!response.ok) {
             throw new Error('Network response was not ok');
@@ -75,11 +144,10 @@ const Dashboard = ({ token }) => {
   const handleDeleteCard =async(id)=>{
     try {
         console.log("Deleting " + id);
-        const response = await fetch(`http://localhost:8080/api/card/${id}`, {
+        const response = await authFetch(`http://localhost:8080/api/card/${id}`, {
             method: 'DELETE',
             headers: {
                 'Content-Type': 'application/json',
-                Authorization: `${token}`,
               }
             }
         );
@@ -97,6 +165,7 @@ const Dashboard = ({ token }) => {
   return (
     <div>
       <h2>Doggo Collector</h2>
+      <button onClick={handleLogout}>Log Out</button>
       <SearchBar onAddCard={handleAddCard} />
       <button onClick={deleteAllCards}>Clear All Cards</button>
       <h2>Caught Breeds</h2>
@@ -5,7 +5,8 @@ import Dashboard from './Dashboard';
 const LoginPage = () => {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
-  const [token, setToken] = useState(null);
+  // access token and the refresh token that can be traded for new ones once it expires
+  const [tokens, setTokens] = useState(null);
 
   const handleLogin = async () => {
     try {
@@ -13,16 +14,15 @@ const LoginPage = () => {
         username: username,
         password: password,
       });
-      const { token } = response.data;
-      setToken(token);
-      console.log(token)
+      const { token, refreshToken } = response.data;
+      setTokens({ token, refreshToken });
     } catch (error) {
       console.error('Login failed:', error);
     }
   };
 
-  if (token) {
-    return <Dashboard token={token} />;
+  if (tokens) {
+    return <Dashboard tokens={tokens} onTokensChange={setTokens} onLogout={() => setTokens(null)} />;
   }
 
   return (